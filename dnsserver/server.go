@@ -0,0 +1,386 @@
+// Package dnsserver implements a small authoritative DNS server for a single
+// zone. It answers every lookup under the zone and logs it, which detects
+// blind SSRF that only ever resolves a hostname.
+package dnsserver
+
+import (
+	"container/list"
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// Config describes the zone served and how lookups in it are answered.
+type Config struct {
+	// Addr is the address listened on with both UDP and TCP.
+	Addr string
+
+	// Zone is the domain the server is authoritative for. Lookups for any
+	// name under it are answered, anything else is refused.
+	Zone string
+
+	// A and AAAA are the addresses returned for A and AAAA lookups. Lookups
+	// for a type without an address get an empty answer.
+	A    net.IP
+	AAAA net.IP
+
+	// TXT returns the answer to TXT lookups from remote, typically the
+	// secret token.
+	TXT func(remote net.Addr) string
+
+	// TTL of every record served, in seconds.
+	TTL uint32
+
+	// Rebind, if set, makes names under a subdomain of the zone switch
+	// addresses between queries.
+	Rebind *RebindConfig
+
+	// ExfilLabel, if set, is the subdomain of the zone under which names
+	// carry exfiltrated data: the labels left of ExfilLabel.Zone are joined
+	// and decoded as unpadded base32, ignoring case.
+	ExfilLabel string
+
+	// OnExfil, if set, is called with the data decoded from each query under
+	// ExfilLabel.Zone.
+	OnExfil func(Exfil)
+
+	// OnLookup, if set, is called for every query for a name under the zone,
+	// before it is answered.
+	OnLookup func(Lookup)
+}
+
+// Lookup is a query for a name under the zone.
+type Lookup struct {
+	// IP is the address the query came from, usually a resolver's.
+	IP string
+
+	// Name is the name queried, as sent.
+	Name string
+
+	// Type is the query type, such as "A" or "TXT".
+	Type string
+}
+
+// Exfil is data received through a lookup under the exfil subdomain.
+type Exfil struct {
+	// IP is the address the query came from, usually a resolver's.
+	IP string
+
+	// Name is the name queried.
+	Name string
+
+	// Data is the decoded data. It is nil if Err is set.
+	Data []byte
+
+	// Err is why the labels couldn't be decoded.
+	Err error
+}
+
+// exfilEncoding decodes exfil labels. DNS names are case-insensitive and
+// can't hold '=', so the data is lowercase-tolerant unpadded base32.
+var exfilEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RebindConfig describes DNS rebinding: A and AAAA lookups for names under
+// Label.Zone are answered with the zone's address for the first After
+// queries of each name and type, and with Internal from then on, or the other
+// way round if InternalFirst is set. Answers have a TTL of 0 so that clients
+// look the name up again rather than caching it.
+type RebindConfig struct {
+	// Label is the subdomain of the zone whose names rebind.
+	Label string
+
+	// Internal is the address rebound to, typically an internal one such as
+	// 127.0.0.1 or 169.254.169.254.
+	Internal net.IP
+
+	// InternalFirst answers with Internal first and the zone's address after.
+	InternalFirst bool
+
+	// After is how many queries are answered with the first address.
+	After int
+
+	// Window is how long a name's query count is kept after its last query.
+	// A name looked up again later starts over.
+	Window time.Duration
+
+	// MaxNames bounds how many names are tracked at once. Beyond it the
+	// least recently queried name is forgotten. Zero means no limit.
+	MaxNames int
+}
+
+// Server is a DNS server for a Config. It listens on UDP and TCP.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	servers []*dns.Server
+
+	// rebindSuffix is the domain whose names rebind, or "" if rebinding is
+	// disabled.
+	rebindSuffix string
+	rebindMu     sync.Mutex
+	rebindNames  map[rebindKey]*list.Element
+	// rebindLRU holds the tracked names' rebindStates, most recently queried
+	// first, so idle names are expired from the back.
+	rebindLRU *list.List
+
+	// exfilSuffix is the domain whose names carry data, or "" if exfil
+	// decoding is disabled.
+	exfilSuffix string
+}
+
+// rebindKey identifies the names tracked for rebinding. A and AAAA lookups
+// are counted separately, since clients often send both at once.
+type rebindKey struct {
+	name  string
+	qtype uint16
+}
+
+type rebindState struct {
+	key     rebindKey
+	queries int
+	last    time.Time
+}
+
+// New builds a Server for the given config. Lookups are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	cfg.Zone = dns.Fqdn(strings.ToLower(cfg.Zone))
+	s := &Server{cfg: cfg, logger: logger}
+	if cfg.Rebind != nil {
+		s.rebindSuffix = strings.ToLower(cfg.Rebind.Label) + "." + cfg.Zone
+		s.rebindNames = make(map[rebindKey]*list.Element)
+		s.rebindLRU = list.New()
+	}
+	if cfg.ExfilLabel != "" {
+		s.exfilSuffix = strings.ToLower(cfg.ExfilLabel) + "." + cfg.Zone
+	}
+	return s
+}
+
+// Start starts listening on UDP and TCP and blocks until both listeners are
+// ready or the context finishes.
+func (s *Server) Start(ctx context.Context) error {
+	if len(s.servers) > 0 {
+		return errors.New("server is already running")
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		started := make(chan struct{})
+		errCh := make(chan error, 1)
+		srv := &dns.Server{
+			Addr:              s.cfg.Addr,
+			Net:               network,
+			Handler:           s,
+			NotifyStartedFunc: func() { close(started) },
+		}
+		go func() { errCh <- srv.ListenAndServe() }()
+
+		select {
+		case <-started:
+			s.servers = append(s.servers, srv)
+		case err := <-errCh:
+			s.Shutdown(ctx)
+			return fmt.Errorf("error starting DNS server on %s %q: %v", network, s.cfg.Addr, err)
+		case <-ctx.Done():
+			srv.Shutdown()
+			s.Shutdown(ctx)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Shutdown stops the listeners, waiting for in-flight queries until the
+// context finishes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, srv := range s.servers {
+		if err := srv.ShutdownContext(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.servers = nil
+	return errors.Join(errs...)
+}
+
+// ServeDNS answers and logs a query.
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	res := new(dns.Msg)
+	res.SetReply(req)
+	res.Authoritative = true
+
+	for _, q := range req.Question {
+		name := strings.ToLower(q.Name)
+		s.logger.Info("New inbound DNS query",
+			zap.String("IP", w.RemoteAddr().String()),
+			zap.String("Protocol", w.RemoteAddr().Network()),
+			zap.String("Query Name", q.Name),
+			zap.String("Query Type", dns.TypeToString[q.Qtype]),
+		)
+
+		if !dns.IsSubDomain(s.cfg.Zone, name) {
+			res.Rcode = dns.RcodeRefused
+			continue
+		}
+		if s.cfg.OnLookup != nil {
+			s.cfg.OnLookup(Lookup{IP: remoteIP(w), Name: q.Name, Type: dns.TypeToString[q.Qtype]})
+		}
+		if s.exfilSuffix != "" && name != s.exfilSuffix && dns.IsSubDomain(s.exfilSuffix, name) {
+			s.receiveExfil(w, q, name)
+		}
+		if s.rebindSuffix != "" && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) && dns.IsSubDomain(s.rebindSuffix, name) {
+			if rr := s.rebindAnswer(w, q, name); rr != nil {
+				res.Answer = append(res.Answer, rr)
+			}
+			continue
+		}
+		if rr := s.answer(w, q); rr != nil {
+			res.Answer = append(res.Answer, rr)
+		}
+	}
+
+	w.WriteMsg(res)
+}
+
+// answer returns the record answering q, or nil if there is none.
+func (s *Server) answer(w dns.ResponseWriter, q dns.Question) dns.RR {
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: s.cfg.TTL}
+
+	switch q.Qtype {
+	case dns.TypeA:
+		if ip := s.cfg.A.To4(); ip != nil {
+			return &dns.A{Hdr: hdr, A: ip}
+		}
+	case dns.TypeAAAA:
+		if s.cfg.AAAA != nil {
+			return &dns.AAAA{Hdr: hdr, AAAA: s.cfg.AAAA}
+		}
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: []string{s.cfg.TXT(w.RemoteAddr())}}
+	}
+	return nil
+}
+
+// rebindAnswer answers an A or AAAA query for a rebinding name with the
+// address for the query's place in the sequence, and logs it. It returns nil
+// if there is no address of the queried type for that place.
+func (s *Server) rebindAnswer(w dns.ResponseWriter, q dns.Question, name string) dns.RR {
+	rebind := s.cfg.Rebind
+	seq := s.rebindSequence(rebindKey{name, q.Qtype})
+
+	internal := seq > rebind.After
+	if rebind.InternalFirst {
+		internal = !internal
+	}
+	ip, phase := s.cfg.A, "public"
+	if q.Qtype == dns.TypeAAAA {
+		ip = s.cfg.AAAA
+	}
+	if internal {
+		ip, phase = rebind.Internal, "internal"
+	}
+
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 0}
+	var rr dns.RR
+	if q.Qtype == dns.TypeA && ip.To4() != nil {
+		rr = &dns.A{Hdr: hdr, A: ip.To4()}
+	} else if q.Qtype == dns.TypeAAAA && ip != nil && ip.To4() == nil {
+		rr = &dns.AAAA{Hdr: hdr, AAAA: ip}
+	}
+
+	answer := ""
+	if rr != nil {
+		answer = ip.String()
+	}
+	s.logger.Info("DNS rebinding answer",
+		zap.String("IP", w.RemoteAddr().String()),
+		zap.String("Query Name", q.Name),
+		zap.String("Query Type", dns.TypeToString[q.Qtype]),
+		zap.Int("Sequence", seq),
+		zap.String("Phase", phase),
+		zap.String("Answer", answer),
+	)
+	return rr
+}
+
+// rebindSequence counts a query for the name and type and returns its place
+// in the sequence, starting at 1. Names idle for longer than the window are
+// forgotten, as is the least recently queried name once MaxNames are tracked.
+func (s *Server) rebindSequence(key rebindKey) int {
+	s.rebindMu.Lock()
+	defer s.rebindMu.Unlock()
+
+	now := time.Now()
+	for back := s.rebindLRU.Back(); back != nil; back = s.rebindLRU.Back() {
+		state := back.Value.(*rebindState)
+		if now.Sub(state.last) <= s.cfg.Rebind.Window {
+			break
+		}
+		s.forgetRebindName(back)
+	}
+
+	elem, ok := s.rebindNames[key]
+	if ok {
+		s.rebindLRU.MoveToFront(elem)
+	} else {
+		if limit := s.cfg.Rebind.MaxNames; limit > 0 && s.rebindLRU.Len() >= limit {
+			s.forgetRebindName(s.rebindLRU.Back())
+		}
+		elem = s.rebindLRU.PushFront(&rebindState{key: key})
+		s.rebindNames[key] = elem
+	}
+	state := elem.Value.(*rebindState)
+	state.queries++
+	state.last = now
+	return state.queries
+}
+
+// forgetRebindName stops tracking a name. rebindMu must be held.
+func (s *Server) forgetRebindName(elem *list.Element) {
+	s.rebindLRU.Remove(elem)
+	delete(s.rebindNames, elem.Value.(*rebindState).key)
+}
+
+// receiveExfil decodes the data carried by a name under the exfil subdomain,
+// logs it and passes it to OnExfil. The query is still answered like any
+// other.
+func (s *Server) receiveExfil(w dns.ResponseWriter, q dns.Question, name string) {
+	exfil := Exfil{IP: remoteIP(w), Name: q.Name}
+	encoded := strings.ReplaceAll(strings.TrimSuffix(name, "."+s.exfilSuffix), ".", "")
+	exfil.Data, exfil.Err = exfilEncoding.DecodeString(strings.ToUpper(encoded))
+	if exfil.Err != nil {
+		exfil.Data = nil
+		s.logger.Warn("Failed to decode DNS exfil data",
+			zap.String("IP", exfil.IP),
+			zap.String("Query Name", q.Name),
+			zap.Error(exfil.Err),
+		)
+	} else {
+		s.logger.Warn("DNS exfil data received",
+			zap.String("IP", exfil.IP),
+			zap.String("Query Name", q.Name),
+			zap.Int("Bytes", len(exfil.Data)),
+			zap.String("Data", fmt.Sprintf("%q", exfil.Data)),
+		)
+	}
+	if s.cfg.OnExfil != nil {
+		s.cfg.OnExfil(exfil)
+	}
+}
+
+// remoteIP returns the IP address, without the port, a query came from.
+func remoteIP(w dns.ResponseWriter) string {
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return w.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,77 @@
+package dnsserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func newRebindServer(window time.Duration, maxNames int) *Server {
+	return New(Config{
+		Zone: "sheriff.example.com",
+		Rebind: &RebindConfig{
+			Label:    "rebind",
+			Internal: net.IPv4(127, 0, 0, 1),
+			After:    1,
+			Window:   window,
+			MaxNames: maxNames,
+		},
+	}, zap.NewNop())
+}
+
+func TestRebindSequenceCountsPerNameAndType(t *testing.T) {
+	s := newRebindServer(time.Hour, 0)
+	a := rebindKey{"x1.rebind.sheriff.example.com.", dns.TypeA}
+	aaaa := rebindKey{a.name, dns.TypeAAAA}
+
+	for want := 1; want <= 3; want++ {
+		if got := s.rebindSequence(a); got != want {
+			t.Errorf("A query %d: sequence = %d", want, got)
+		}
+	}
+	if got := s.rebindSequence(aaaa); got != 1 {
+		t.Errorf("first AAAA query: sequence = %d, want 1", got)
+	}
+}
+
+func TestRebindSequenceExpiresIdleNames(t *testing.T) {
+	s := newRebindServer(10*time.Millisecond, 0)
+	idle := rebindKey{"idle.rebind.sheriff.example.com.", dns.TypeA}
+	s.rebindSequence(idle)
+	s.rebindSequence(idle)
+
+	time.Sleep(20 * time.Millisecond)
+	// A query for another name expires the idle one without touching it.
+	s.rebindSequence(rebindKey{"other.rebind.sheriff.example.com.", dns.TypeA})
+	if _, ok := s.rebindNames[idle]; ok {
+		t.Error("idle name still tracked after the window")
+	}
+	if got := s.rebindSequence(idle); got != 1 {
+		t.Errorf("sequence after the window = %d, want 1", got)
+	}
+}
+
+func TestRebindSequenceForgetsLeastRecentlyQueried(t *testing.T) {
+	s := newRebindServer(time.Hour, 2)
+	first := rebindKey{"first.rebind.sheriff.example.com.", dns.TypeA}
+	second := rebindKey{"second.rebind.sheriff.example.com.", dns.TypeA}
+	third := rebindKey{"third.rebind.sheriff.example.com.", dns.TypeA}
+
+	s.rebindSequence(first)
+	s.rebindSequence(second)
+	s.rebindSequence(first) // second is now the least recently queried
+	s.rebindSequence(third)
+
+	if len(s.rebindNames) != 2 || s.rebindLRU.Len() != 2 {
+		t.Fatalf("tracking %d names (%d in the list), want 2", len(s.rebindNames), s.rebindLRU.Len())
+	}
+	if got := s.rebindSequence(first); got != 3 {
+		t.Errorf("first: sequence = %d, want 3", got)
+	}
+	if _, ok := s.rebindNames[second]; ok {
+		t.Error("least recently queried name still tracked")
+	}
+}
@@ -0,0 +1,396 @@
+// Package ftpserver implements just enough of an FTP server for ftp:// URLs
+// fetched by an SSRF-vulnerable client to succeed. Every file it is asked for
+// has the same contents, and every connection and command is logged, which
+// detects SSRF through the ftp:// scheme.
+package ftpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"github.com/teknogeek/ssrf-sheriff/tcplistener"
+	"go.uber.org/zap"
+)
+
+const (
+	// idleTimeout is how long a control connection may go without a command.
+	idleTimeout = 5 * time.Minute
+
+	// dataTimeout is how long the client has to open a data connection.
+	dataTimeout = 30 * time.Second
+
+	// maxLineBytes bounds a command line, so a client can't make the server
+	// allocate without limit.
+	maxLineBytes = 4096
+)
+
+// errLineTooLong is returned by reads from a lineLimiter once a line grows
+// over maxLineBytes.
+var errLineTooLong = errors.New("line too long")
+
+// lineLimiter is a control connection whose reads fail with errLineTooLong
+// once the client sends more than maxLineBytes without a line ending.
+type lineLimiter struct {
+	net.Conn
+	line int
+}
+
+func (l *lineLimiter) Read(p []byte) (int, error) {
+	n, err := l.Conn.Read(p)
+	for _, b := range p[:n] {
+		l.line++
+		if b == '\n' {
+			l.line = 0
+		} else if l.line > maxLineBytes {
+			return 0, errLineTooLong
+		}
+	}
+	return n, err
+}
+
+// Config describes where the server listens and what it serves.
+type Config struct {
+	// Addr is the address the control connection is accepted on.
+	Addr string
+
+	// PublicIP is the IPv4 address advertised in PASV replies. Defaults to
+	// the local address of the control connection, which is wrong behind
+	// NAT.
+	PublicIP net.IP
+
+	// Content returns what is served as the contents of every file to a
+	// client connecting from remote.
+	Content func(remote net.Addr) []byte
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is an FTP server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	// Server runs the accept loop and provides Start and Shutdown.
+	*tcplistener.Server
+}
+
+// New builds a Server for the given config. Connections are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	s := &Server{cfg: cfg, logger: logger}
+	s.Server = tcplistener.New("FTP", []string{cfg.Addr}, cfg.Proxy, func(conn net.Conn) { newSession(s, conn).run() })
+	return s
+}
+
+// session is the state of one control connection.
+type session struct {
+	s      *Server
+	conn   net.Conn
+	tp     *textproto.Conn
+	logger *zap.Logger
+
+	// content is served as every file.
+	content []byte
+
+	user string
+	cwd  string
+	// offset is where the next RETR starts, as set by REST.
+	offset int64
+
+	// passive is the listener opened by PASV or EPSV, and active the
+	// address given by PORT or EPRT, for the next data connection.
+	passive net.Listener
+	active  string
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	logger := s.logger.With(zap.String("IP", conn.RemoteAddr().String()))
+	if proxy := proxyproto.ProxyAddr(conn); proxy != nil {
+		logger = logger.With(zap.Stringer("Proxy", proxy))
+	}
+	return &session{
+		s:       s,
+		conn:    conn,
+		tp:      textproto.NewConn(&lineLimiter{Conn: conn}),
+		logger:  logger,
+		content: s.cfg.Content(conn.RemoteAddr()),
+		cwd:     "/",
+	}
+}
+
+func (ss *session) run() {
+	defer ss.closeData()
+
+	ss.logger.Info("New inbound FTP connection")
+	ss.reply(220, "(vsFTPd 3.0.3)")
+
+	for {
+		ss.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := ss.tp.ReadLine()
+		if errors.Is(err, errLineTooLong) {
+			ss.logger.Info("FTP line too long, closing connection", zap.Int("Limit", maxLineBytes))
+			ss.reply(500, "Command line too long.")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		cmd = strings.ToUpper(cmd)
+		ss.logger.Info("New inbound FTP command",
+			zap.String("User", ss.user),
+			zap.String("Command", cmd),
+			zap.String("Argument", arg),
+		)
+		if cmd == "QUIT" {
+			ss.reply(221, "Goodbye.")
+			return
+		}
+		ss.handle(cmd, arg)
+	}
+}
+
+func (ss *session) reply(code int, msg string) {
+	ss.tp.PrintfLine("%d %s", code, msg)
+}
+
+func (ss *session) handle(cmd, arg string) {
+	switch cmd {
+	case "USER":
+		ss.user = arg
+		ss.reply(331, "Please specify the password.")
+	case "PASS":
+		ss.logger.Info("FTP login",
+			zap.String("User", ss.user),
+			zap.String("Password", arg),
+		)
+		ss.reply(230, "Login successful.")
+	case "SYST":
+		ss.reply(215, "UNIX Type: L8")
+	case "FEAT":
+		ss.tp.PrintfLine("211-Features:\r\n EPRT\r\n EPSV\r\n MDTM\r\n PASV\r\n REST STREAM\r\n SIZE\r\n UTF8\r\n211 End")
+	case "OPTS":
+		ss.reply(200, "Always in UTF8 mode.")
+	case "NOOP":
+		ss.reply(200, "NOOP ok.")
+	case "TYPE":
+		if strings.HasPrefix(strings.ToUpper(arg), "A") {
+			ss.reply(200, "Switching to ASCII mode.")
+		} else {
+			ss.reply(200, "Switching to Binary mode.")
+		}
+	case "MODE", "STRU":
+		ss.reply(200, "Mode set.")
+	case "PWD", "XPWD":
+		ss.reply(257, strconv.Quote(ss.cwd)+" is the current directory")
+	case "CWD", "XCWD":
+		ss.cwd = ss.resolve(arg)
+		ss.reply(250, "Directory successfully changed.")
+	case "CDUP", "XCUP":
+		ss.cwd = path.Dir(ss.cwd)
+		ss.reply(250, "Directory successfully changed.")
+	case "PASV":
+		ss.passiveMode(false)
+	case "EPSV":
+		ss.passiveMode(true)
+	case "PORT":
+		ss.activeMode(parsePORT(arg))
+	case "EPRT":
+		ss.activeMode(parseEPRT(arg))
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			ss.reply(501, "REST: invalid parameter.")
+			return
+		}
+		ss.offset = offset
+		ss.reply(350, "Restart position accepted ("+arg+").")
+	case "SIZE":
+		ss.reply(213, strconv.Itoa(len(ss.content)))
+	case "MDTM":
+		ss.reply(213, time.Now().UTC().Format("20060102150405"))
+	case "RETR":
+		ss.retrieve(arg)
+	case "LIST", "NLST":
+		ss.list(cmd == "LIST")
+	case "STOR", "STOU", "APPE", "DELE", "MKD", "XMKD", "RMD", "XRMD", "RNFR", "RNTO", "SITE":
+		ss.reply(550, "Permission denied.")
+	default:
+		ss.reply(500, "Unknown command.")
+	}
+}
+
+// resolve returns the absolute path of p relative to the working directory.
+func (ss *session) resolve(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(ss.cwd, p)
+}
+
+func (ss *session) passiveMode(extended bool) {
+	ss.closeData()
+
+	host, _, _ := net.SplitHostPort(ss.conn.LocalAddr().String())
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		ss.reply(425, "Can't open passive connection.")
+		return
+	}
+	ss.passive = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	if extended {
+		ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+
+	ip := ss.s.cfg.PublicIP.To4()
+	if ip == nil {
+		ip = net.ParseIP(host).To4()
+	}
+	if ip == nil {
+		ss.closeData()
+		ss.reply(425, "PASV is IPv4 only, use EPSV.")
+		return
+	}
+	ss.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d).", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff))
+}
+
+// activeMode records the address of the next data connection. It must be
+// the client's own address, so the sheriff can't be used to bounce
+// connections elsewhere.
+func (ss *session) activeMode(ip net.IP, port int, err error) {
+	ss.closeData()
+	if err != nil {
+		ss.reply(501, "Illegal PORT command.")
+		return
+	}
+
+	clientHost, _, _ := net.SplitHostPort(ss.conn.RemoteAddr().String())
+	if !ip.Equal(net.ParseIP(clientHost)) {
+		ss.reply(500, "Illegal PORT command.")
+		return
+	}
+	ss.active = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	ss.reply(200, "PORT command successful. Consider using PASV.")
+}
+
+// openData opens the data connection set up by the last PASV, EPSV, PORT or
+// EPRT command.
+func (ss *session) openData() (net.Conn, error) {
+	defer ss.closeData()
+
+	switch {
+	case ss.passive != nil:
+		ss.passive.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+		return ss.passive.Accept()
+	case ss.active != "":
+		return net.DialTimeout("tcp", ss.active, dataTimeout)
+	default:
+		return nil, errors.New("no data connection set up")
+	}
+}
+
+func (ss *session) closeData() {
+	if ss.passive != nil {
+		ss.passive.Close()
+		ss.passive = nil
+	}
+	ss.active = ""
+}
+
+// transfer sends data over a new data connection with the usual replies.
+func (ss *session) transfer(opening string, data []byte) {
+	if ss.passive == nil && ss.active == "" {
+		ss.reply(425, "Use PORT or PASV first.")
+		return
+	}
+	ss.reply(150, opening)
+
+	conn, err := ss.openData()
+	if err != nil {
+		ss.reply(425, "Failed to establish connection.")
+		return
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(dataTimeout))
+	if _, err := conn.Write(data); err != nil {
+		ss.reply(426, "Failure writing network stream.")
+		return
+	}
+	ss.reply(226, "Transfer complete.")
+}
+
+func (ss *session) retrieve(arg string) {
+	file := ss.resolve(arg)
+	ss.logger.Info("New inbound FTP request",
+		zap.String("User", ss.user),
+		zap.String("Path", file),
+	)
+
+	content := ss.content
+	offset := ss.offset
+	ss.offset = 0
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	ss.transfer(fmt.Sprintf("Opening BINARY mode data connection for %s (%d bytes).", file, len(content)), content[offset:])
+}
+
+// list sends a directory listing with a single file in it.
+func (ss *session) list(long bool) {
+	const name = "token.txt"
+	entry := name + "\r\n"
+	if long {
+		entry = fmt.Sprintf("-rw-r--r--    1 0        0        %8d Jan 01 00:00 %s\r\n", len(ss.content), name)
+	}
+	ss.transfer("Here comes the directory listing.", []byte(entry))
+}
+
+// parsePORT parses the h1,h2,h3,h4,p1,p2 argument of PORT.
+func parsePORT(arg string) (net.IP, int, error) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		return nil, 0, errors.New("invalid PORT argument")
+	}
+	var b [6]byte
+	for i, part := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		b[i] = byte(n)
+	}
+	return net.IPv4(b[0], b[1], b[2], b[3]), int(b[4])<<8 | int(b[5]), nil
+}
+
+// parseEPRT parses the |proto|address|port| argument of EPRT.
+func parseEPRT(arg string) (net.IP, int, error) {
+	if len(arg) < 2 {
+		return nil, 0, errors.New("invalid EPRT argument")
+	}
+	parts := strings.Split(arg[1:len(arg)-1], arg[:1])
+	if len(parts) != 3 {
+		return nil, 0, errors.New("invalid EPRT argument")
+	}
+	ip := net.ParseIP(parts[1])
+	if ip == nil {
+		return nil, 0, errors.New("invalid EPRT address")
+	}
+	port, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, int(port), nil
+}
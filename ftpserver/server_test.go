@@ -0,0 +1,40 @@
+package ftpserver
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLongLinesDropTheSession(t *testing.T) {
+	s := &Server{
+		cfg:    Config{Content: func(net.Addr) []byte { return []byte("token=tok") }},
+		logger: zap.NewNop(),
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		newSession(s, server).run()
+	}()
+
+	tp := textproto.NewConn(client)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	tp.PrintfLine("USER anonymous")
+	if _, _, err := tp.ReadResponse(331); err != nil {
+		t.Fatal(err)
+	}
+
+	go tp.PrintfLine("USER %s", strings.Repeat("a", maxLineBytes))
+	if code, _, _ := tp.ReadResponse(331); code != 500 {
+		t.Errorf("reply to a long line = %d, want 500", code)
+	}
+	if _, err := tp.ReadLine(); err == nil {
+		t.Error("session still open after a long line")
+	}
+}
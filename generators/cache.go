@@ -0,0 +1,83 @@
+package generators
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxCachedRuns bounds the number of generator runs whose output is kept, so
+// that tokens minted per request can't grow the cache without limit
+const maxCachedRuns = 1024
+
+// Cache generates media in memory the first time one of the files a
+// generator produces is needed for a token, and keeps it for later requests
+type Cache struct {
+	gens        []generator
+	concurrency int
+
+	mu    sync.Mutex
+	runs  map[cacheKey]*cacheRun
+	order []cacheKey
+}
+
+type cacheKey struct {
+	token string
+	name  string
+}
+
+type cacheRun struct {
+	once  sync.Once
+	files Files
+	err   error
+}
+
+// function that returns a Cache running the generators enabled by opts
+func NewCache(opts Options) *Cache {
+	return &Cache{
+		gens:        opts.generators(),
+		concurrency: opts.Concurrency,
+		runs:        make(map[cacheKey]*cacheRun),
+	}
+}
+
+// function that returns the contents of file generated for the token. ok is
+// false if no generator produces file. Concurrent callers for the same
+// generator and token wait for a single run and share its result.
+func (c *Cache) Get(ssrfToken string, file string) (data []byte, ok bool, err error) {
+	for _, gen := range c.gens {
+		for _, f := range gen.files {
+			if f != file {
+				continue
+			}
+			files, err := c.generate(ssrfToken, gen)
+			return files[file], true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// function that runs gen for the token unless its output is already cached,
+// evicting the oldest runs once maxCachedRuns is reached
+func (c *Cache) generate(ssrfToken string, gen generator) (Files, error) {
+	c.mu.Lock()
+	key := cacheKey{token: ssrfToken, name: gen.name}
+	run, ok := c.runs[key]
+	if !ok {
+		if len(c.order) >= maxCachedRuns {
+			delete(c.runs, c.order[0])
+			c.order = c.order[1:]
+		}
+		run = &cacheRun{}
+		c.runs[key] = run
+		c.order = append(c.order, key)
+	}
+	c.mu.Unlock()
+
+	run.once.Do(func() {
+		run.files, run.err = gen.run(ssrfToken)
+		if run.err != nil {
+			run.err = fmt.Errorf("%s generator: %v", gen.name, run.err)
+		}
+	})
+	return run.files, run.err
+}
@@ -0,0 +1,139 @@
+package generators
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// xmpJPEGNamespace precedes XMP packets in JPEG APP1 segments
+	xmpJPEGNamespace = "http://ns.adobe.com/xap/1.0/"
+	// xmpPNGKeyword is the keyword of the iTXt chunk holding XMP in PNG
+	xmpPNGKeyword = "XML:com.adobe.xmp"
+)
+
+// TIFF tags and field types used in EXIF metadata
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffLong      = 4
+	tiffUndefined = 7
+
+	tagImageDescription   = 0x010E
+	tagArtist             = 0x013B
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagUserComment        = 0x9286
+	tagGPSVersionID       = 0x0000
+	tagGPSProcessing      = 0x001B
+	tagGPSAreaInformation = 0x001C
+)
+
+// tiffEntry is a field of a TIFF IFD. Entries must be listed in ascending
+// tag order.
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	value := append([]byte(s), 0)
+	return tiffEntry{tag, tiffASCII, uint32(len(value)), value}
+}
+
+// function that returns an UNDEFINED entry holding ASCII text behind the
+// character code prefix used by UserComment and the GPS text fields
+func textEntry(tag uint16, s string) tiffEntry {
+	value := append([]byte("ASCII\x00\x00\x00"), s...)
+	return tiffEntry{tag, tiffUndefined, uint32(len(value)), value}
+}
+
+func longEntry(tag uint16, v uint32) tiffEntry {
+	return tiffEntry{tag, tiffLong, 1, binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+// function that returns the size of an IFD, including the values that don't
+// fit in its entries
+func ifdSize(entries []tiffEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += uint32(len(e.value) + len(e.value)%2)
+		}
+	}
+	return size
+}
+
+// function that appends an IFD located at offset, followed by the values
+// that don't fit in its entries
+func appendIFD(tiff []byte, entries []tiffEntry, offset uint32) []byte {
+	valueOffset := offset + uint32(2+12*len(entries)+4)
+	var values []byte
+
+	tiff = binary.LittleEndian.AppendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = binary.LittleEndian.AppendUint16(tiff, e.tag)
+		tiff = binary.LittleEndian.AppendUint16(tiff, e.kind)
+		tiff = binary.LittleEndian.AppendUint32(tiff, e.count)
+		if len(e.value) <= 4 {
+			tiff = append(tiff, e.value...)
+			tiff = append(tiff, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		tiff = binary.LittleEndian.AppendUint32(tiff, valueOffset+uint32(len(values)))
+		values = append(values, e.value...)
+		if len(e.value)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0) // no next IFD
+	return append(tiff, values...)
+}
+
+// function that builds a little-endian TIFF structure, as stored in EXIF
+// metadata, holding the token as the image description, artist and user
+// comment, and in the GPS processing method and area information, so that
+// it comes out of whichever fields a metadata parser reads
+func exifForToken(ssrfToken string) []byte {
+	text := canaryKeyword + "=" + ssrfToken
+
+	exif := []tiffEntry{
+		textEntry(tagUserComment, text),
+	}
+	gps := []tiffEntry{
+		{tagGPSVersionID, tiffByte, 4, []byte{2, 2, 0, 0}},
+		textEntry(tagGPSProcessing, text),
+		textEntry(tagGPSAreaInformation, text),
+	}
+
+	const ifd0Offset = 8
+	ifd0 := []tiffEntry{
+		asciiEntry(tagImageDescription, text),
+		asciiEntry(tagArtist, ssrfToken),
+		longEntry(tagExifIFD, 0),
+		longEntry(tagGPSIFD, 0),
+	}
+	exifOffset := ifd0Offset + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	ifd0[2] = longEntry(tagExifIFD, exifOffset)
+	ifd0[3] = longEntry(tagGPSIFD, gpsOffset)
+
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, ifd0Offset)
+	tiff = appendIFD(tiff, ifd0, ifd0Offset)
+	tiff = appendIFD(tiff, exif, exifOffset)
+	return appendIFD(tiff, gps, gpsOffset)
+}
+
+// xmpPacket is an XMP packet holding the token as the Dublin Core title,
+// description and creator, and as the EXIF user comment
+const xmpPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:exif="http://ns.adobe.com/exif/1.0/"><dc:title><rdf:Alt><rdf:li xml:lang="x-default">%[1]s</rdf:li></rdf:Alt></dc:title><dc:description><rdf:Alt><rdf:li xml:lang="x-default">%[2]s=%[1]s</rdf:li></rdf:Alt></dc:description><dc:creator><rdf:Seq><rdf:li>%[1]s</rdf:li></rdf:Seq></dc:creator><exif:UserComment><rdf:Alt><rdf:li xml:lang="x-default">%[2]s=%[1]s</rdf:li></rdf:Alt></exif:UserComment></rdf:Description></rdf:RDF></x:xmpmeta>
+<?xpacket end="w"?>`
+
+// function that builds an XMP packet holding the token
+func xmpForToken(ssrfToken string) []byte {
+	return []byte(fmt.Sprintf(xmpPacket, escapeXML(ssrfToken), canaryKeyword))
+}
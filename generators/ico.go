@@ -0,0 +1,60 @@
+package generators
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/gobold"
+)
+
+// icoSize is the width and height of generated icons
+const icoSize = 32
+
+// function that generates a favicon showing the first characters of the
+// token's SHA-256 hash, which is enough to tell tokens apart at a glance. The
+// icon is a PNG wrapped in an ICO container; the PNG carries the token in a
+// tEXt chunk and the token is also appended after the image data, where icon
+// readers ignore it but string extraction finds it.
+func GenerateICO(ssrfToken string) (Files, error) {
+	sum := sha256.Sum256([]byte(ssrfToken))
+	hash := hex.EncodeToString(sum[:2])
+
+	dc, err := renderText("", icoSize, icoSize)
+	if err != nil {
+		return nil, err
+	}
+	font, err := truetype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	dc.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: 9}))
+	dc.DrawStringAnchored(hash[:2], icoSize/2, icoSize/4+1, 0.5, 0.5)
+	dc.DrawStringAnchored(hash[2:], icoSize/2, 3*icoSize/4-1, 0.5, 0.5)
+
+	var png bytes.Buffer
+	if err := dc.EncodePNG(&png); err != nil {
+		return nil, err
+	}
+	pngData, err := pngWithText(png.Bytes(), canaryKeyword, ssrfToken)
+	if err != nil {
+		return nil, err
+	}
+
+	const headerLen = 6 + 16 // ICONDIR, one ICONDIRENTRY
+	ico := make([]byte, 0, headerLen+len(pngData)+len(canaryKeyword)+1+len(ssrfToken))
+	ico = binary.LittleEndian.AppendUint16(ico, 0) // reserved
+	ico = binary.LittleEndian.AppendUint16(ico, 1) // icon
+	ico = binary.LittleEndian.AppendUint16(ico, 1) // image count
+	ico = append(ico, icoSize, icoSize, 0, 0)      // width, height, palette size, reserved
+	ico = binary.LittleEndian.AppendUint16(ico, 1) // color planes
+	ico = binary.LittleEndian.AppendUint16(ico, 32)
+	ico = binary.LittleEndian.AppendUint32(ico, uint32(len(pngData)))
+	ico = binary.LittleEndian.AppendUint32(ico, headerLen)
+	ico = append(ico, pngData...)
+	ico = append(ico, canaryKeyword+"="+ssrfToken...)
+
+	return Files{"favicon.ico": ico}, nil
+}
@@ -1,32 +1,122 @@
 package generators
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font/gofont/goregular"
 )
 
-// function that generates JPG and PNG images with the provided text
-// and save them into "/templates" directory
-func GenerateJPGAndPNG(ssrfToken string) {
-	const W = 1024
-	const H = 768
+// imageFormat encodes the rendered token into one image format
+type imageFormat struct {
+	file string
+
+	// encode encodes img, also storing the token in the format's metadata
+	// when metadata is set
+	encode func(img image.Image, ssrfToken string, metadata bool) ([]byte, error)
+}
+
+// imageFormats are the formats produced by GenerateImages, all from the same
+// rendered image. Supporting another format only takes an encoder here. AVIF
+// is left out until there is an AV1 encoder to build on: the standard library
+// and golang.org/x/image have none, and linking libaom would add a native
+// library to the image for one file.
+var imageFormats = []imageFormat{
+	{"jpeg.jpg", encodeJPEGImage},
+	{"png.png", encodePNGImage},
+	{"webp.webp", encodeWebPImage},
+}
+
+// function that lists the files produced by GenerateImages
+func imageFiles() []string {
+	files := make([]string, len(imageFormats))
+	for i, f := range imageFormats {
+		files[i] = f.file
+	}
+	return files
+}
+
+// function that generates images in every format of imageFormats with the
+// provided text. With metadata enabled, the text is also stored in each
+// format's metadata.
+func GenerateImages(ssrfToken string, metadata bool) (Files, error) {
+	dc, err := renderText(ssrfToken, 1024, 768)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(Files, len(imageFormats))
+	for _, f := range imageFormats {
+		data, err := f.encode(dc.Image(), ssrfToken, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.file, err)
+		}
+		files[f.file] = data
+	}
+	return files, nil
+}
+
+// function that encodes a JPEG image, with the text in a comment, EXIF and
+// XMP when metadata is enabled
+func encodeJPEGImage(img image.Image, ssrfToken string, metadata bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	if !metadata {
+		return buf.Bytes(), nil
+	}
+	return jpegWithMetadata(buf.Bytes(), ssrfToken)
+}
+
+// function that encodes a PNG image, with the text in a tEXt chunk, EXIF and
+// XMP when metadata is enabled
+func encodePNGImage(img image.Image, ssrfToken string, metadata bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if !metadata {
+		return buf.Bytes(), nil
+	}
+	return pngWithMetadata(buf.Bytes(), ssrfToken)
+}
 
-	dc := gg.NewContext(W, H)
+// function that encodes a lossless WebP image, with the text in EXIF and XMP
+// chunks when metadata is enabled
+func encodeWebPImage(img image.Image, ssrfToken string, metadata bool) ([]byte, error) {
+	vp8l, err := encodeWebP(img)
+	if err != nil {
+		return nil, err
+	}
+	var exif, xmp []byte
+	if metadata {
+		exif, xmp = exifForToken(ssrfToken), xmpForToken(ssrfToken)
+	}
+	b := img.Bounds()
+	return webpContainer(vp8l, b.Dx(), b.Dy(), exif, xmp), nil
+}
+
+// function that draws the provided text in white, centered on a black canvas
+// of the given size
+func renderText(ssrfToken string, w, h int) (*gg.Context, error) {
+	dc := gg.NewContext(w, h)
 	dc.SetRGB(0, 0, 0)
 	dc.Clear()
 	dc.SetRGB(1, 1, 1)
 	font, err := truetype.Parse(goregular.TTF)
 	if err != nil {
-		panic("")
+		return nil, err
 	}
 	face := truetype.NewFace(font, &truetype.Options{
 		Size: 14,
 	})
 	dc.SetFontFace(face)
-	dc.DrawStringAnchored(ssrfToken,  W/2, H/2, 0.5, 0.5)
-
-
-	dc.SaveJPG("./templates/jpeg.jpg", 80)
-	dc.SavePNG("./templates/png.png")
-}
\ No newline at end of file
+	dc.DrawStringAnchored(ssrfToken, float64(w)/2, float64(h)/2, 0.5, 0.5)
+	return dc, nil
+}
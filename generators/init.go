@@ -1,6 +1,112 @@
 package generators
 
-// function that run all media files generators with the provided text
-func InitMediaGenerators(ssrfToken string)  {
-	GenerateJPGAndPNG(ssrfToken)
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// Options selects which optional media generators are run
+type Options struct {
+	// Skip disables generation entirely, so only the static templates are
+	// served
+	Skip bool `yaml:"skip"`
+
+	// Office enables generation of DOCX and XLSX documents
+	Office bool `yaml:"office"`
+
+	// Metadata embeds the token in format-specific metadata (EXIF and XMP in
+	// JPEG, PNG and WebP, plus a JPEG comment and PNG tEXt, MP4 udta box) in
+	// addition to the rendered media. MP3 files
+	// always carry it in their ID3 tags
+	Metadata bool `yaml:"metadata"`
+
+	// QRURL is encoded in QR codes instead of the bare token, with every
+	// "{token}" replaced by the token
+	QRURL string `yaml:"qr_url"`
+
+	// Concurrency bounds how many generators run at once. Defaults to the
+	// number of CPUs.
+	Concurrency int `yaml:"concurrency"`
+
+	// Lazy defers generation for the configured tokens until a format is
+	// first requested, instead of warming the cache at startup
+	Lazy bool `yaml:"lazy"`
+}
+
+// Files maps file names to their generated contents
+type Files map[string][]byte
+
+// generator renders the token into one or more files
+type generator struct {
+	name  string
+	files []string
+	run   func(ssrfToken string) (Files, error)
+}
+
+// generators returns the generators enabled by opts
+func (opts Options) generators() []generator {
+	gens := []generator{
+		{"images", imageFiles(), func(ssrfToken string) (Files, error) {
+			return GenerateImages(ssrfToken, opts.Metadata)
+		}},
+		{"pdf", []string{"pdf.pdf"}, GeneratePDF},
+		{"svg", []string{"svg.svg"}, GenerateSVG},
+		{"ico", []string{"favicon.ico"}, GenerateICO},
+		{"qr", []string{"qr.png"}, func(ssrfToken string) (Files, error) {
+			return GenerateQRCode(ssrfToken, opts.QRURL, opts.Metadata)
+		}},
+		{"mp3", []string{"mp3.mp3"}, GenerateMP3},
+		{"mp4", []string{"mp4.mp4"}, func(ssrfToken string) (Files, error) {
+			return GenerateMP4(ssrfToken, opts.Metadata)
+		}},
+	}
+	if opts.Office {
+		gens = append(gens, generator{"docx/xlsx", []string{"docx.docx", "xlsx.xlsx"}, GenerateOfficeDocuments})
+	}
+	return gens
+}
+
+// function that runs every generator for every token ahead of time, using a
+// bounded pool of workers, and returns all errors that occurred
+func (c *Cache) Warm(tokens []string) error {
+	workers := c.concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	type job struct {
+		gen   generator
+		token string
+	}
+	jobs := make(chan job)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if _, err := c.generate(j.token, j.gen); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, token := range tokens {
+		for _, gen := range c.gens {
+			jobs <- job{gen: gen, token: token}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,128 @@
+package generators
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+)
+
+const (
+	// mp4Width and mp4Height are the dimensions of the generated video
+	mp4Width  = 640
+	mp4Height = 480
+
+	// mp4Duration is how long the single frame of the video is shown, in
+	// units of mp4Timescale
+	mp4Timescale = 1000
+	mp4Duration  = 2000
+)
+
+// mp4Matrix is the identity transformation matrix of movie and track headers
+var mp4Matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// function that generates an MP4 video whose single frame shows the provided
+// text. The frame is a JPEG image, stored as MPEG-4 visual object type 0x6C,
+// which ffmpeg-based thumbnailers and transcoders decode. With metadata
+// enabled, the text is also stored in a ©cmt comment.
+func GenerateMP4(ssrfToken string, metadata bool) (Files, error) {
+	dc, err := renderText(ssrfToken, mp4Width, mp4Height)
+	if err != nil {
+		return nil, err
+	}
+	var frame bytes.Buffer
+	if err := jpeg.Encode(&frame, dc.Image(), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	ftyp := buildMP4Box("ftyp", []byte("isom"), be32(0x200), []byte("isomiso2mp41"))
+	mdat := buildMP4Box("mdat", frame.Bytes())
+	// The frame starts right after the ftyp box and the mdat header
+	moov := mp4Movie(uint32(len(ftyp)+8), uint32(frame.Len()))
+
+	out := append(append(ftyp, mdat...), moov...)
+	if metadata {
+		if out, err = mp4WithComment(out, ssrfToken); err != nil {
+			return nil, err
+		}
+	}
+	return Files{"mp4.mp4": out}, nil
+}
+
+// function that builds the moov box describing a video track with a single
+// JPEG frame of the given size, stored at the given file offset
+func mp4Movie(offset, size uint32) []byte {
+	mvhd := fullBox("mvhd", 0,
+		be32(0), be32(0), be32(mp4Timescale), be32(mp4Duration),
+		be32(0x00010000), be16(0x0100), make([]byte, 10),
+		be32(mp4Matrix...), make([]byte, 24), be32(2))
+
+	tkhd := fullBox("tkhd", 3, // enabled and in movie
+		be32(0), be32(0), be32(1), be32(0), be32(mp4Duration),
+		make([]byte, 8), be16(0, 0, 0, 0),
+		be32(mp4Matrix...), be32(mp4Width<<16, mp4Height<<16))
+
+	mdhd := fullBox("mdhd", 0,
+		be32(0), be32(0), be32(mp4Timescale), be32(mp4Duration),
+		be16(0x55C4, 0)) // language "und"
+	hdlr := fullBox("hdlr", 0, be32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	vmhd := fullBox("vmhd", 1, make([]byte, 8))
+	dinf := buildMP4Box("dinf", fullBox("dref", 0, be32(1), fullBox("url ", 1)))
+
+	stbl := buildMP4Box("stbl",
+		fullBox("stsd", 0, be32(1), mp4VisualSampleEntry()),
+		fullBox("stts", 0, be32(1), be32(1, mp4Duration)),
+		fullBox("stsc", 0, be32(1), be32(1, 1, 1)),
+		fullBox("stsz", 0, be32(0), be32(1), be32(size)),
+		fullBox("stco", 0, be32(1), be32(offset)),
+	)
+
+	minf := buildMP4Box("minf", vmhd, dinf, stbl)
+	mdia := buildMP4Box("mdia", mdhd, hdlr, minf)
+	trak := buildMP4Box("trak", tkhd, mdia)
+	return buildMP4Box("moov", mvhd, trak)
+}
+
+// function that builds the mp4v sample entry for JPEG frames
+func mp4VisualSampleEntry() []byte {
+	compressor := make([]byte, 32)
+	compressor[0] = byte(copy(compressor[1:], "JPEG"))
+
+	// ES_Descriptor holding a DecoderConfigDescriptor for object type 0x6C
+	// (JPEG) and stream type 4 (visual), and the predefined SLConfig
+	decoderConfig := []byte{0x04, 13, 0x6C, 0x04<<2 | 1, 0, 0, 0}
+	decoderConfig = append(decoderConfig, be32(0, 0)...)
+	slConfig := []byte{0x06, 1, 0x02}
+	es := []byte{0x03, byte(3 + len(decoderConfig) + len(slConfig)), 0, 1, 0}
+	es = append(append(es, decoderConfig...), slConfig...)
+
+	return buildMP4Box("mp4v",
+		make([]byte, 6), be16(1), // data reference index
+		make([]byte, 16), be16(mp4Width, mp4Height),
+		be32(0x00480000, 0x00480000, 0), // 72dpi
+		be16(1), compressor, be16(0x0018, 0xFFFF),
+		fullBox("esds", 0, es),
+	)
+}
+
+// function that builds a box starting with a zero version and the given
+// flags
+func fullBox(kind string, flags uint32, payload ...[]byte) []byte {
+	return buildMP4Box(kind, append([][]byte{be32(flags & 0xFFFFFF)}, payload...)...)
+}
+
+func be32(values ...uint32) []byte {
+	out := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+func be16(values ...uint16) []byte {
+	out := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint16(out, v)
+	}
+	return out
+}
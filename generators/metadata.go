@@ -0,0 +1,244 @@
+package generators
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// canaryKeyword is the key under which the token is stored in metadata
+const canaryKeyword = "ssrf_token"
+
+// function that inserts a tEXt chunk holding the text right after the IHDR
+// chunk of a PNG image
+func pngWithText(png []byte, keyword, text string) ([]byte, error) {
+	data := append([]byte(keyword), 0)
+	data = append(data, text...)
+	return pngWithChunks(png, pngChunk{"tEXt", data})
+}
+
+// pngChunk is an ancillary PNG chunk to insert into an image
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// function that inserts chunks right after the IHDR chunk of a PNG image
+func pngWithChunks(png []byte, chunks ...pngChunk) ([]byte, error) {
+	const (
+		signatureLen = 8
+		ihdrLen      = 4 + 4 + 13 + 4 // length, type, data, CRC
+	)
+	if len(png) < signatureLen+ihdrLen || string(png[signatureLen+4:signatureLen+8]) != "IHDR" {
+		return nil, errors.New("not a PNG image")
+	}
+
+	var encoded []byte
+	for _, c := range chunks {
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(c.data)))
+		chunk = append(chunk, c.kind...)
+		chunk = append(chunk, c.data...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+		encoded = append(encoded, chunk...)
+	}
+
+	at := signatureLen + ihdrLen
+	out := make([]byte, 0, len(png)+len(encoded))
+	out = append(out, png[:at]...)
+	out = append(out, encoded...)
+	return append(out, png[at:]...), nil
+}
+
+// function that stores EXIF metadata in an eXIf chunk and an XMP packet in
+// an iTXt chunk of a PNG image, along with the text in a tEXt chunk
+func pngWithMetadata(png []byte, ssrfToken string) ([]byte, error) {
+	text := append([]byte(canaryKeyword), 0)
+	text = append(text, ssrfToken...)
+
+	// keyword, then no compression, an empty language tag and an empty
+	// translated keyword
+	itxt := append([]byte(xmpPNGKeyword), 0, 0, 0, 0, 0)
+	itxt = append(itxt, xmpForToken(ssrfToken)...)
+
+	return pngWithChunks(png,
+		pngChunk{"tEXt", text},
+		pngChunk{"eXIf", exifForToken(ssrfToken)},
+		pngChunk{"iTXt", itxt},
+	)
+}
+
+// jpegSegment is a marker segment to insert into a JPEG image
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// function that inserts marker segments right after the SOI marker of a
+// JPEG image
+func jpegWithSegments(jpg []byte, segments ...jpegSegment) ([]byte, error) {
+	if len(jpg) < 2 || jpg[0] != 0xFF || jpg[1] != 0xD8 {
+		return nil, errors.New("not a JPEG image")
+	}
+
+	var encoded []byte
+	for _, s := range segments {
+		if len(s.data) > 0xFFFF-2 {
+			return nil, errors.New("metadata too long for a JPEG segment")
+		}
+		encoded = append(encoded, 0xFF, s.marker)
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(s.data)+2))
+		encoded = append(encoded, s.data...)
+	}
+
+	out := make([]byte, 0, len(jpg)+len(encoded))
+	out = append(out, jpg[:2]...)
+	out = append(out, encoded...)
+	return append(out, jpg[2:]...), nil
+}
+
+// function that stores EXIF metadata and an XMP packet in APP1 segments of a
+// JPEG image, along with the text in a comment
+func jpegWithMetadata(jpg []byte, ssrfToken string) ([]byte, error) {
+	exif := append([]byte("Exif\x00\x00"), exifForToken(ssrfToken)...)
+	xmp := append([]byte(xmpJPEGNamespace), 0)
+	xmp = append(xmp, xmpForToken(ssrfToken)...)
+
+	return jpegWithSegments(jpg,
+		jpegSegment{0xE1, exif},
+		jpegSegment{0xE1, xmp},
+		jpegSegment{0xFE, []byte(canaryKeyword + "=" + ssrfToken)},
+	)
+}
+
+// function that prepends an ID3v2.4 tag with the token as title, comment and
+// a user-defined text frame, replacing any ID3v2 tag already present
+func mp3WithID3(mp3 []byte, ssrfToken string) []byte {
+	mp3 = stripID3(mp3)
+
+	var frames bytes.Buffer
+	// 0x03 is the UTF-8 text encoding
+	writeID3Frame(&frames, "TIT2", append([]byte{0x03}, "token="+ssrfToken...))
+	comment := append([]byte{0x03}, "eng"...)
+	comment = append(comment, 0)
+	writeID3Frame(&frames, "COMM", append(comment, ssrfToken...))
+	txxx := append([]byte{0x03}, canaryKeyword...)
+	txxx = append(txxx, 0)
+	writeID3Frame(&frames, "TXXX", append(txxx, ssrfToken...))
+
+	out := []byte("ID3")
+	out = append(out, 0x04, 0x00, 0x00)
+	out = append(out, syncsafe(uint32(frames.Len()))...)
+	out = append(out, frames.Bytes()...)
+	return append(out, mp3...)
+}
+
+func writeID3Frame(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	buf.Write(syncsafe(uint32(len(data))))
+	buf.Write([]byte{0, 0})
+	buf.Write(data)
+}
+
+// function that removes a leading ID3v2 tag
+func stripID3(mp3 []byte) []byte {
+	if len(mp3) < 10 || string(mp3[:3]) != "ID3" {
+		return mp3
+	}
+	size := int(mp3[6])<<21 | int(mp3[7])<<14 | int(mp3[8])<<7 | int(mp3[9])
+	if 10+size > len(mp3) {
+		return mp3
+	}
+	return mp3[10+size:]
+}
+
+func syncsafe(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// function that stores the token as a ©cmt comment in a udta box of an MP4
+// file. The box replaces any udta box in the moov box when moov is the last
+// top-level box, so that no chunk offsets need to be rewritten. Otherwise it
+// is appended as a top-level box.
+func mp4WithComment(mp4 []byte, ssrfToken string) ([]byte, error) {
+	boxes, err := mp4Boxes(mp4)
+	if err != nil {
+		return nil, err
+	}
+
+	udta := mp4CommentBox(ssrfToken)
+
+	last := boxes[len(boxes)-1]
+	if last.kind != "moov" {
+		return append(append([]byte{}, mp4...), udta...), nil
+	}
+
+	children, err := mp4Boxes(mp4[last.start+8 : last.end])
+	if err != nil {
+		return nil, err
+	}
+	moov := make([]byte, 8)
+	copy(moov[4:], "moov")
+	for _, child := range children {
+		if child.kind == "udta" {
+			continue
+		}
+		moov = append(moov, mp4[last.start+8+child.start:last.start+8+child.end]...)
+	}
+	moov = append(moov, udta...)
+	binary.BigEndian.PutUint32(moov, uint32(len(moov)))
+
+	out := make([]byte, 0, last.start+len(moov))
+	out = append(out, mp4[:last.start]...)
+	return append(out, moov...), nil
+}
+
+type mp4Box struct {
+	kind       string
+	start, end int
+}
+
+// function that lists the boxes at the top level of data
+func mp4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 8 {
+			return nil, errors.New("truncated MP4 box header")
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		if size == 0 {
+			size = len(data) - pos
+		}
+		if size < 8 || pos+size > len(data) {
+			return nil, errors.New("invalid MP4 box size")
+		}
+		boxes = append(boxes, mp4Box{kind: string(data[pos+4 : pos+8]), start: pos, end: pos + size})
+		pos += size
+	}
+	if len(boxes) == 0 {
+		return nil, errors.New("not an MP4 file")
+	}
+	return boxes, nil
+}
+
+func buildMP4Box(kind string, payload ...[]byte) []byte {
+	box := make([]byte, 8)
+	copy(box[4:], kind)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+// function that builds udta/meta/ilst/©cmt boxes holding the token, as read
+// by iTunes-style metadata parsers such as ffprobe
+func mp4CommentBox(ssrfToken string) []byte {
+	// data box: type 1 (UTF-8) and a zero locale, followed by the value
+	data := buildMP4Box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(ssrfToken))
+	ilst := buildMP4Box("ilst", buildMP4Box("\xa9cmt", data))
+	hdlr := buildMP4Box("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+	// meta is a full box: version and flags precede its children
+	meta := buildMP4Box("meta", []byte{0, 0, 0, 0}, hdlr, ilst)
+	return buildMP4Box("udta", meta)
+}
@@ -0,0 +1,189 @@
+package generators
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+const testToken = "abcdefghijklmnopqrst"
+
+// pngTextChunks returns the tEXt chunks of a PNG image by keyword, checking
+// every chunk's CRC on the way.
+func pngTextChunks(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("missing PNG signature")
+	}
+	texts := make(map[string]string)
+	for pos := 8; pos < len(data); {
+		if len(data)-pos < 12 {
+			t.Fatalf("truncated chunk at %d", pos)
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 8 + size
+		if end+4 > len(data) {
+			t.Fatalf("chunk at %d overruns the image", pos)
+		}
+		kind, body := string(data[pos+4:pos+8]), data[pos+8:end]
+		if crc := binary.BigEndian.Uint32(data[end:]); crc != crc32.ChecksumIEEE(data[pos+4:end]) {
+			t.Fatalf("bad CRC for %s chunk", kind)
+		}
+		if kind == "tEXt" {
+			keyword, text, _ := bytes.Cut(body, []byte{0})
+			texts[string(keyword)] = string(text)
+		}
+		pos = end + 4
+	}
+	return texts
+}
+
+// jpegComments returns the contents of the COM segments of a JPEG image.
+func jpegComments(t *testing.T, data []byte) []string {
+	t.Helper()
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		t.Fatal("missing JPEG SOI marker")
+	}
+	var comments []string
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			t.Fatalf("expected a marker at %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			// Entropy-coded data follows the start of scan.
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			t.Fatalf("segment at %d overruns the image", pos)
+		}
+		if marker == 0xFE {
+			comments = append(comments, string(data[pos+4:pos+2+size]))
+		}
+		pos += 2 + size
+	}
+	return comments
+}
+
+// mp4Path walks data down the given box path and returns the payload of the
+// last box. skip gives the number of bytes to skip at the start of a box's
+// payload before its children, for full boxes such as meta.
+func mp4Path(t *testing.T, data []byte, skip map[string]int, path ...string) ([]byte, bool) {
+	t.Helper()
+	for _, kind := range path {
+		found := false
+		for pos := 0; pos+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[pos:]))
+			if size < 8 || pos+size > len(data) {
+				t.Fatalf("invalid size for box at %d", pos)
+			}
+			if string(data[pos+4:pos+8]) == kind {
+				data = data[pos+8+skip[kind] : pos+size]
+				found = true
+				break
+			}
+			pos += size
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func TestPNGTextChunkHoldsToken(t *testing.T) {
+	for _, metadata := range []bool{true, false} {
+		files, err := GenerateImages(testToken, metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := files["png.png"]
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("metadata=%v: image no longer decodes: %v", metadata, err)
+		}
+
+		text, ok := pngTextChunks(t, data)[canaryKeyword]
+		if metadata && text != testToken {
+			t.Errorf("tEXt %s = %q, want %q", canaryKeyword, text, testToken)
+		}
+		if !metadata && ok {
+			t.Errorf("tEXt %s present without metadata", canaryKeyword)
+		}
+	}
+}
+
+func TestJPEGCommentHoldsToken(t *testing.T) {
+	for _, metadata := range []bool{true, false} {
+		files, err := GenerateImages(testToken, metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := files["jpeg.jpg"]
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("metadata=%v: image no longer decodes: %v", metadata, err)
+		}
+
+		comments := jpegComments(t, data)
+		want := canaryKeyword + "=" + testToken
+		if metadata && (len(comments) != 1 || comments[0] != want) {
+			t.Errorf("COM segments = %q, want [%q]", comments, want)
+		}
+		if !metadata && len(comments) > 0 {
+			t.Errorf("COM segments %q present without metadata", comments)
+		}
+	}
+}
+
+func TestMP4CommentHoldsToken(t *testing.T) {
+	skip := map[string]int{"meta": 4, "data": 8}
+	path := []string{"moov", "udta", "meta", "ilst", "\xa9cmt", "data"}
+
+	files, err := GenerateMP4(testToken, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, ok := mp4Path(t, files["mp4.mp4"], skip, path...)
+	if !ok {
+		t.Fatal("no moov/udta/meta/ilst/©cmt/data box")
+	}
+	if string(comment) != testToken {
+		t.Errorf("©cmt = %q, want %q", comment, testToken)
+	}
+	// The frame must still be where the sample table says it is.
+	if _, ok := mp4Path(t, files["mp4.mp4"], nil, "mdat"); !ok {
+		t.Error("mdat box lost")
+	}
+
+	files, err = GenerateMP4(testToken, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mp4Path(t, files["mp4.mp4"], skip, path...); ok {
+		t.Error("©cmt present without metadata")
+	}
+}
+
+func TestMP4CommentReplacesUdta(t *testing.T) {
+	moov := buildMP4Box("moov", buildMP4Box("mvhd", make([]byte, 4)), buildMP4Box("udta", []byte("old")))
+	in := append(buildMP4Box("ftyp", []byte("isom")), moov...)
+
+	out, err := mp4WithComment(in, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udta, ok := mp4Path(t, out, nil, "moov", "udta")
+	if !ok || bytes.Contains(udta, []byte("old")) {
+		t.Fatalf("udta = %q, want the old one replaced", udta)
+	}
+	if _, ok := mp4Path(t, out, nil, "moov", "mvhd"); !ok {
+		t.Error("mvhd box lost")
+	}
+
+	if _, err := mp4WithComment([]byte("not an mp4"), testToken); err == nil {
+		t.Error("expected an error for a file that isn't MP4")
+	}
+}
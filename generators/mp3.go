@@ -0,0 +1,48 @@
+package generators
+
+import (
+	"bytes"
+)
+
+const (
+	// mp3SilentFrames is the number of frames generated, about a second of
+	// audio at 1152 samples per frame and 44.1kHz
+	mp3SilentFrames = 38
+
+	// mp3FrameLen is the length of an MPEG-1 Layer III frame at 32kbps and
+	// 44.1kHz without padding: 144 * 32000 / 44100
+	mp3FrameLen = 104
+)
+
+// mp3FrameHeader is an MPEG-1 Layer III header without CRC, at 32kbps,
+// 44.1kHz, mono and marked as original
+var mp3FrameHeader = []byte{0xFF, 0xFB, 0x10, 0xC4}
+
+// function that generates an MP3 file of silence carrying the provided text
+// in an ID3v2 tag at the start of the file and an ID3v1 tag at its end, so
+// that it is found by parsers reading either
+func GenerateMP3(ssrfToken string) (Files, error) {
+	var audio bytes.Buffer
+	for i := 0; i < mp3SilentFrames; i++ {
+		// All-zero side information means no main data: the frame decodes
+		// to silence
+		audio.Write(mp3FrameHeader)
+		audio.Write(make([]byte, mp3FrameLen-len(mp3FrameHeader)))
+	}
+	audio.Write(id3v1Tag(ssrfToken))
+
+	return Files{"mp3.mp3": mp3WithID3(audio.Bytes(), ssrfToken)}, nil
+}
+
+// function that builds an ID3v1.1 tag with the text as title and comment,
+// truncated to the 30 and 28 bytes the fields hold
+func id3v1Tag(ssrfToken string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], "token="+ssrfToken)
+	copy(tag[33:63], "ssrf-sheriff")
+	copy(tag[97:125], ssrfToken)
+	// genre: none
+	tag[127] = 0xFF
+	return tag
+}
@@ -0,0 +1,44 @@
+package generators
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfEscaper escapes the characters that are special inside PDF literal
+// strings
+var pdfEscaper = strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`, "\n", `\n`)
+
+// function that generates a single-page PDF document showing the provided
+// text, which is also stored in the document's Title, Subject and Keywords
+func GeneratePDF(ssrfToken string) (Files, error) {
+	text := pdfEscaper.Replace("token=" + ssrfToken)
+	content := fmt.Sprintf("BT /F1 14 Tf 72 720 Td (%s) Tj ET", text)
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		fmt.Sprintf("<< /Title (%s) /Subject (%s) /Keywords (%s) /Producer (ssrf-sheriff) >>", text, text, pdfEscaper.Replace(ssrfToken)),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, len(objects), xref)
+
+	return Files{"pdf.pdf": buf.Bytes()}, nil
+}
@@ -0,0 +1,505 @@
+package generators
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+const (
+	// qrScale is the size in pixels of a QR code module
+	qrScale = 8
+	// qrQuietZone is the width in modules of the light border around a QR
+	// code
+	qrQuietZone = 4
+)
+
+// qrBlocks describes the error correction blocks of a QR code version at
+// error correction level M: the number of EC codewords per block, then the
+// number of blocks and data codewords per block in each of up to two groups
+type qrBlocks struct {
+	ecPerBlock           int
+	blocks1, dataPerBlk1 int
+	blocks2, dataPerBlk2 int
+}
+
+// qrVersions are versions 1 to 10 at level M, enough for up to 213 bytes
+var qrVersions = []qrBlocks{
+	{10, 1, 16, 0, 0},
+	{16, 1, 28, 0, 0},
+	{26, 1, 44, 0, 0},
+	{18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0},
+	{18, 4, 31, 0, 0},
+	{22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37},
+	{26, 4, 43, 1, 44},
+}
+
+// qrAlignment lists the alignment pattern coordinates of versions 1 to 10
+var qrAlignment = [][]int{
+	nil,
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	return b.blocks1*b.dataPerBlk1 + b.blocks2*b.dataPerBlk2
+}
+
+// function that generates a PNG image of a QR code encoding the token, or
+// url with every "{token}" replaced by the token if url is set. With
+// metadata enabled, the token is also stored in a tEXt chunk.
+func GenerateQRCode(ssrfToken, url string, metadata bool) (Files, error) {
+	text := ssrfToken
+	if url != "" {
+		text = strings.ReplaceAll(url, "{token}", ssrfToken)
+	}
+
+	modules, err := encodeQR([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	size := (len(modules) + 2*qrQuietZone) * qrScale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < qrScale; py++ {
+				for px := 0; px < qrScale; px++ {
+					img.SetGray((x+qrQuietZone)*qrScale+px, (y+qrQuietZone)*qrScale+py, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if metadata {
+		if data, err = pngWithText(data, canaryKeyword, ssrfToken); err != nil {
+			return nil, err
+		}
+	}
+	return Files{"qr.png": data}, nil
+}
+
+// function that encodes data in byte mode as a QR code at error correction
+// level M, in the smallest version that fits, and returns its modules, true
+// being dark
+func encodeQR(data []byte) ([][]bool, error) {
+	version := 0
+	for v, blocks := range qrVersions {
+		countBits := 8
+		if v+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*blocks.dataCodewords() {
+			version = v + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("too much data for a QR code")
+	}
+
+	q := newQRCode(version)
+	q.drawFunctionPatterns()
+	q.drawCodewords(qrCodewords(data, version))
+
+	best, bestPenalty := -1, 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); best < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q.modules, nil
+}
+
+// function that returns the data codewords for data in byte mode, padded to
+// the capacity of version, followed by their error correction codewords,
+// interleaved across blocks
+func qrCodewords(data []byte, version int) []byte {
+	blocks := qrVersions[version-1]
+	capacity := blocks.dataCodewords()
+
+	var bits qrBits
+	bits.append(0x4, 4) // byte mode
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	terminator := 8*capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b = b<<1 | bit
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	var dataBlocks, ecBlocks [][]byte
+	divisor := rsGenerator(blocks.ecPerBlock)
+	for i := 0; i < blocks.blocks1+blocks.blocks2; i++ {
+		n := blocks.dataPerBlk1
+		if i >= blocks.blocks1 {
+			n = blocks.dataPerBlk2
+		}
+		block := codewords[:n]
+		codewords = codewords[n:]
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	var out []byte
+	for _, group := range [][][]byte{dataBlocks, ecBlocks} {
+		for i := 0; ; i++ {
+			added := false
+			for _, block := range group {
+				if i < len(block) {
+					out = append(out, block[i])
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// qrBits is a sequence of bits, most significant first
+type qrBits []byte
+
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>i&1))
+	}
+}
+
+// function that multiplies in GF(256) with the QR code polynomial 0x11D
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// function that returns the Reed-Solomon generator polynomial of the given
+// degree, highest coefficient first and without the leading 1
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// function that returns the Reed-Solomon error correction codewords for
+// data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMul(coef, factor)
+		}
+	}
+	return result
+}
+
+// qrCode is a QR code being drawn
+type qrCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newQRCode(version int) *qrCode {
+	size := 4*version + 17
+	q := &qrCode{version: version, size: size}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	return q
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// function that draws the finder, timing and alignment patterns, the version
+// information and the areas reserved for format information
+func (q *qrCode) drawFunctionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || y < 0 || x >= q.size || y >= q.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				q.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := qrAlignment[q.version-1]
+	last := len(positions) - 1
+	for i, cx := range positions {
+		for j, cy := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(0)
+
+	if q.version >= 7 {
+		rem := q.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := q.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+// function that draws both copies of the format information for level M
+// and the given mask, along with the dark module
+func (q *qrCode) drawFormatBits(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// function that places the codewords in the zigzag order of the data area.
+// Modules left over are remainder bits and stay light.
+func (q *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(codewords)*8 {
+					q.modules[y][x] = codewords[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// function that flips the data modules selected by mask. Applying the same
+// mask twice undoes it.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// function that scores how hard the symbol is to read, following the four
+// penalty rules used to pick a mask
+func (q *qrCode) penalty() int {
+	n := q.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	penalty, dark := 0, 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			for x := 0; x+7 <= n; x++ {
+				match := true
+				for k, d := range finder {
+					if at(x+k, y, transpose) != d {
+						match = false
+						break
+					}
+				}
+				if match && (q.lightRun(x-4, x, y, transpose, at) || q.lightRun(x+7, x+11, y, transpose, at)) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	total := n * n
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return penalty + max(k, 0)*10
+}
+
+// function that reports whether the modules from x0 up to x1 on row y are
+// all light, counting modules outside the symbol as light
+func (q *qrCode) lightRun(x0, x1, y int, transpose bool, at func(x, y int, transpose bool) bool) bool {
+	for x := x0; x < x1; x++ {
+		if x >= 0 && x < q.size && at(x, y, transpose) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,31 @@
+package generators
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// function that generates an SVG image rendering the provided text with a
+// <text> element, also leaving it in a comment so it survives rasterization
+// by sinks that only log or store the source
+func GenerateSVG(ssrfToken string) (Files, error) {
+	var text bytes.Buffer
+	if err := xml.EscapeText(&text, []byte(ssrfToken)); err != nil {
+		return nil, err
+	}
+	// "--" may not appear inside an XML comment
+	comment := strings.ReplaceAll(ssrfToken, "--", "- -")
+
+	svg := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!-- token=%s -->
+<svg xmlns="http://www.w3.org/2000/svg" width="600" height="100" viewBox="0 0 600 100">
+  <title>token=%s</title>
+  <rect width="100%%" height="100%%" fill="#ffffff"/>
+  <text x="20" y="60" font-family="monospace" font-size="24" fill="#000000">%s</text>
+</svg>
+`, comment, text.String(), text.String())
+
+	return Files{"svg.svg": []byte(svg)}, nil
+}
@@ -0,0 +1,157 @@
+package generators
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+)
+
+// vp8lThreshold is the luminance at which antialiased pixels of the rendered
+// text become white in WebP images, which are encoded in black and white
+const vp8lThreshold = 0x80
+
+// function that encodes img as a lossless WebP image. The encoder only
+// handles what the sheriff renders, white text on black: every pixel is
+// reduced to black or white, the subtract green transform makes red and blue
+// constant, and green takes one bit per pixel.
+func encodeWebP(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 1 || h < 1 || w > 1<<14 || h > 1<<14 {
+		return nil, errors.New("image size not supported by VP8L")
+	}
+
+	white := make([]bool, 0, w*h)
+	var hasBlack, hasWhite bool
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			isWhite := (r+g+bl)/3>>8 >= vp8lThreshold
+			white = append(white, isWhite)
+			hasWhite = hasWhite || isWhite
+			hasBlack = hasBlack || !isWhite
+		}
+	}
+
+	var bw bitWriter
+	bw.write(0x2F, 8) // signature
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(0, 1) // alpha_is_used
+	bw.write(0, 3) // version
+
+	bw.write(1, 1) // transform present
+	bw.write(2, 2) // SUBTRACT_GREEN
+	bw.write(0, 1) // no more transforms
+
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	// green: black and/or white
+	switch {
+	case hasBlack && hasWhite:
+		bw.writeSimpleCode(0x00, 0xFF)
+	case hasWhite:
+		bw.writeSimpleCode(0xFF)
+	default:
+		bw.writeSimpleCode(0x00)
+	}
+	bw.writeSimpleCode(0x00) // red, minus green
+	bw.writeSimpleCode(0x00) // blue, minus green
+	bw.writeSimpleCode(0xFF) // alpha
+	bw.writeSimpleCode(0x00) // distance, unused
+
+	// Only green takes any bits, and only when both colors are present. With
+	// two symbols of code length 1, the smaller symbol (black) is code 0.
+	if hasBlack && hasWhite {
+		for _, isWhite := range white {
+			if isWhite {
+				bw.write(1, 1)
+			} else {
+				bw.write(0, 1)
+			}
+		}
+	}
+
+	return bw.bytes(), nil
+}
+
+// bitWriter packs values least significant bit first, as VP8L expects
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+// function that writes a simple prefix code for one or two 8-bit symbols
+func (bw *bitWriter) writeSimpleCode(symbols ...uint32) {
+	bw.write(1, 1) // simple code
+	bw.write(uint32(len(symbols)-1), 1)
+	bw.write(1, 1) // 8-bit first symbol
+	for _, s := range symbols {
+		bw.write(s, 8)
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		return append(bw.buf, byte(bw.acc))
+	}
+	return bw.buf
+}
+
+// function that wraps a VP8L bitstream in a WebP RIFF container. EXIF and
+// XMP metadata, when given, are stored in their own chunks behind a VP8X
+// header.
+func webpContainer(vp8l []byte, width, height int, exif, xmp []byte) []byte {
+	var chunks []byte
+	if exif != nil || xmp != nil {
+		var flags byte
+		if exif != nil {
+			flags |= 0x08
+		}
+		if xmp != nil {
+			flags |= 0x04
+		}
+		vp8x := []byte{flags, 0, 0, 0}
+		vp8x = appendUint24(vp8x, uint32(width-1))
+		vp8x = appendUint24(vp8x, uint32(height-1))
+		chunks = appendRIFFChunk(chunks, "VP8X", vp8x)
+	}
+	chunks = appendRIFFChunk(chunks, "VP8L", vp8l)
+	if exif != nil {
+		chunks = appendRIFFChunk(chunks, "EXIF", exif)
+	}
+	if xmp != nil {
+		chunks = appendRIFFChunk(chunks, "XMP ", xmp)
+	}
+
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(chunks)))
+	out = append(out, "WEBP"...)
+	return append(out, chunks...)
+}
+
+func appendRIFFChunk(dst []byte, fourCC string, data []byte) []byte {
+	dst = append(dst, fourCC...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(data)))
+	dst = append(dst, data...)
+	if len(data)%2 == 1 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+func appendUint24(dst []byte, v uint32) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16))
+}
@@ -0,0 +1,286 @@
+// Package grpcserver implements a gRPC server with server reflection and a
+// single method answering with the token. It logs the metadata of every call,
+// including calls to services it doesn't implement, to detect SSRF into
+// gRPC-heavy internal service meshes.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// tokenMetadataKey is the response header and trailer carrying the token.
+const tokenMetadataKey = "ssrf-token"
+
+// Config describes where the server listens and what it answers with.
+type Config struct {
+	// Addr is the address listened on.
+	Addr string
+
+	// Token returns the token for a client calling from remote. It is
+	// returned by the GetToken method and in the ssrf-token header and
+	// trailer of every call.
+	Token func(remote net.Addr) string
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is a gRPC server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+	files  *protoregistry.Files
+	method protoreflect.MethodDescriptor
+
+	mu       sync.Mutex
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// New builds a Server for the given config. Calls are logged to logger.
+func New(cfg Config, logger *zap.Logger) (*Server, error) {
+	file, err := protodesc.NewFile(sheriffProto, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build gRPC service descriptor: %v", err)
+	}
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(file); err != nil {
+		return nil, fmt.Errorf("failed to register gRPC service descriptor: %v", err)
+	}
+	return &Server{
+		cfg:    cfg,
+		logger: logger,
+		files:  files,
+		method: file.Services().Get(0).Methods().Get(0),
+	}, nil
+}
+
+// sheriffProto describes the sheriff.v1.Sheriff service, as listed by server
+// reflection:
+//
+//	service Sheriff {
+//	  rpc GetToken(GetTokenRequest) returns (GetTokenResponse);
+//	}
+//	message GetTokenRequest {}
+//	message GetTokenResponse { string token = 1; }
+var sheriffProto = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("sheriff/v1/sheriff.proto"),
+	Package: proto.String("sheriff.v1"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		{Name: proto.String("GetTokenRequest")},
+		{
+			Name: proto.String("GetTokenResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("token"),
+				JsonName: proto.String("token"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		},
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("Sheriff"),
+		Method: []*descriptorpb.MethodDescriptorProto{{
+			Name:       proto.String("GetToken"),
+			InputType:  proto.String(".sheriff.v1.GetTokenRequest"),
+			OutputType: proto.String(".sheriff.v1.GetTokenResponse"),
+		}},
+	}},
+}
+
+// Start starts listening and serving calls in the background.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return errors.New("server is already running")
+	}
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting gRPC server on %q: %v", s.cfg.Addr, err)
+	}
+	if s.cfg.Proxy != nil {
+		ln = proxyproto.NewListener(ln, *s.cfg.Proxy)
+	}
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(s.logUnary),
+		grpc.StreamInterceptor(s.logStream),
+		grpc.UnknownServiceHandler(s.unknown),
+	)
+	srv.RegisterService(s.serviceDesc(), s)
+
+	reflectionOpts := reflection.ServerOptions{Services: srv, DescriptorResolver: s.files}
+	reflectionv1.RegisterServerReflectionServer(srv, reflection.NewServerV1(reflectionOpts))
+	reflectionv1alpha.RegisterServerReflectionServer(srv, reflection.NewServer(reflectionOpts))
+
+	s.server = srv
+	s.listener = ln
+	go srv.Serve(ln)
+	return nil
+}
+
+// Shutdown stops the server, letting calls in progress finish until the
+// context does.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.server
+	s.server = nil
+	s.listener = nil
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// serviceDesc describes the Sheriff service to grpc-go, which otherwise
+// learns it from generated code.
+func (s *Server) serviceDesc() *grpc.ServiceDesc {
+	service := s.method.Parent().(protoreflect.ServiceDescriptor)
+	return &grpc.ServiceDesc{
+		ServiceName: string(service.FullName()),
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: string(s.method.Name()),
+			Handler:    s.getToken,
+		}},
+		Metadata: sheriffProto.GetName(),
+	}
+}
+
+// getToken handles GetToken calls.
+func (s *Server) getToken(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := dynamicpb.NewMessage(s.method.Input())
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, _ any) (any, error) {
+		resp := dynamicpb.NewMessage(s.method.Output())
+		resp.Set(s.method.Output().Fields().ByNumber(1), protoreflect.ValueOfString(s.token(ctx)))
+		return resp, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     s,
+		FullMethod: fmt.Sprintf("/%s/%s", s.method.Parent().FullName(), s.method.Name()),
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+// unknown answers calls to services the server doesn't implement with
+// Unimplemented, carrying the token in the message and trailer.
+func (s *Server) unknown(_ any, stream grpc.ServerStream) error {
+	return status.Errorf(codes.Unimplemented, "ssrf_token=%s", s.token(stream.Context()))
+}
+
+// logUnary logs unary calls and sends the token in their header and trailer.
+func (s *Server) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	s.logCall(ctx, info.FullMethod)
+	token := s.token(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(tokenMetadataKey, token))
+	grpc.SetTrailer(ctx, metadata.Pairs(tokenMetadataKey, token))
+	return handler(ctx, req)
+}
+
+// logStream logs streaming calls, including reflection and calls to unknown
+// services, and sends the token in their header and trailer.
+func (s *Server) logStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.logCall(stream.Context(), info.FullMethod)
+	token := s.token(stream.Context())
+	stream.SetHeader(metadata.Pairs(tokenMetadataKey, token))
+	stream.SetTrailer(metadata.Pairs(tokenMetadataKey, token))
+	return handler(srv, stream)
+}
+
+// token returns the token for the peer of a call.
+func (s *Server) token(ctx context.Context) string {
+	var remote net.Addr
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr
+	}
+	return s.cfg.Token(remote)
+}
+
+// logCall logs the method and metadata of a call.
+func (s *Server) logCall(ctx context.Context, method string) {
+	fields := []zap.Field{zap.String("Method", method)}
+	// Behind a trusted proxy, the peer address is the one from the PROXY
+	// protocol header.
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("IP", p.Addr.String()))
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		fields = append(fields,
+			zap.String("Authority", first(md, ":authority")),
+			zap.String("User-Agent", first(md, "user-agent")),
+			zap.Strings("Metadata", formatMetadata(md)),
+		)
+	}
+	s.logger.Info("New inbound gRPC call", fields...)
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// formatMetadata renders metadata as sorted key: value lines. Binary values,
+// in -bin keys, are already base64 decoded by grpc-go and quoted here.
+func formatMetadata(md metadata.MD) []string {
+	var lines []string
+	for key, values := range md {
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = fmt.Sprintf("%q", value)
+			}
+			lines = append(lines, key+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// authorizeAdmin checks that the request carries the configured admin token
+// as a bearer token. If it doesn't, a 401 is written and false is returned.
+// Admin endpoints are unusable while no admin.token is configured.
+func (s *SSRFSheriffRouter) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	scheme, token := splitAuthorization(r.Header.Get("Authorization"))
+	if s.adminToken == "" || scheme != "bearer" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ssrf-sheriff"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// secretToken returns the current generation of ssrf_token.
+func (s *SSRFSheriffRouter) secretToken() string {
+	return s.generations.current().Token
+}
+
+type tokenListResponse struct {
+	Current     int               `json:"current"`
+	Generations []tokenGeneration `json:"generations"`
+}
+
+// TokenHandler lists the generations of ssrf_token on GET. On POST it adds a
+// generation with the token in the JSON body, or a random one if the body is
+// empty or doesn't set one, served between the optional not_before and
+// not_after RFC 3339 times, and returns it. Earlier generations are still
+// recognized in callback URLs, so stale callbacks can be told apart. Every
+// listener, HTTP or not, serves the new generation once its window starts.
+// Current is 0 while no generation is being served.
+func (s *SSRFSheriffRouter) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	var res []byte
+	switch r.Method {
+	case http.MethodGet:
+		res, _ = json.Marshal(tokenListResponse{
+			Current:     s.generations.current().Generation,
+			Generations: s.generations.list(),
+		})
+	case http.MethodPost:
+		var body struct {
+			Token     string `json:"token"`
+			NotBefore string `json:"not_before"`
+			NotAfter  string `json:"not_after"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		notBefore, err := parseWindowTime(body.NotBefore)
+		if err != nil {
+			http.Error(w, "invalid not_before: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		notAfter, err := parseWindowTime(body.NotAfter)
+		if err != nil {
+			http.Error(w, "invalid not_after: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !notAfter.IsZero() && !notAfter.After(notBefore) {
+			http.Error(w, "not_after must be after not_before", http.StatusBadRequest)
+			return
+		}
+		if body.Token == "" {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				s.logger.Error("Failed to generate token", zap.Error(err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			body.Token = hex.EncodeToString(b)
+		}
+
+		gen := s.generations.add(body.Token, notBefore, notAfter)
+		s.logger.Warn("Rotated ssrf_token",
+			zap.String("IP", r.RemoteAddr),
+			zap.Int("Token Generation", gen.Generation),
+		)
+		res, _ = json.Marshal(gen)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// ReloadHandler returns a handler that asks for a graceful reload through
+// trigger, as if the process had received a reload signal. The reload happens
+// after the response is sent.
+func (s *SSRFSheriffRouter) ReloadHandler(trigger ReloadTrigger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorizeAdmin(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !trigger.request() {
+			http.Error(w, "a reload is already pending", http.StatusConflict)
+			return
+		}
+		s.logger.Info("Reload requested", zap.String("IP", r.RemoteAddr))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/config"
+)
+
+// dashboardPage is the single-page dashboard served at the root of the admin
+// listener. It asks for the admin token and uses the hits API and hit stream.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// AdminHandle is the Handle of the admin listener, which serves endpoints
+// meant for the operator, such as /metrics and the admin API, on a port of
+// its own so they can be kept off the public interface. Handle is nil when admin.address
+// isn't configured.
+type AdminHandle struct {
+	*httpserver.Handle
+}
+
+// NewAdminHandle builds the admin listener configured in admin.address.
+func NewAdminHandle(cfg config.Provider, metrics *Metrics, s *SSRFSheriffRouter, reload ReloadTrigger) (AdminHandle, error) {
+	address := cfg.Get("admin.address").String()
+	if address == "" {
+		return AdminHandle{}, nil
+	}
+
+	addr, err := httpserver.NormalizeAddr(address)
+	if err != nil {
+		return AdminHandle{}, fmt.Errorf("invalid admin.address: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.Path("/metrics").Handler(metrics.Handler())
+	router.Path("/").Methods(http.MethodGet).HandlerFunc(serveDashboard)
+	router.Path("/api/hits").HandlerFunc(s.HitsHandler)
+	router.Path("/api/hits/stream").HandlerFunc(s.HitStreamHandler)
+	router.Path("/api/interactions").HandlerFunc(s.InteractionsHandler)
+	router.Path("/api/token").HandlerFunc(s.TokenHandler)
+	router.Path("/api/targets").HandlerFunc(s.TargetsHandler)
+	router.Path("/api/reload").HandlerFunc(s.ReloadHandler(reload))
+	router.Path("/api/modes").HandlerFunc(s.ModesHandler)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+	server.RegisterOnShutdown(s.feed.close)
+	return AdminHandle{httpserver.NewHandle(server,
+		listenFunc(),
+	)}, nil
+}
+
+// serveDashboard serves the dashboard page. The page itself holds no data, so
+// it needs no authentication.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dashboardPage)
+}
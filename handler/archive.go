@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+)
+
+// archiveTokenFile is the name of the file holding the token in archive
+// responses.
+const archiveTokenFile = "token.txt"
+
+// archiveOptions controls what goes into .zip and .tar.gz responses besides
+// token.txt.
+type archiveOptions struct {
+	// Nested adds a copy of the archive, holding only token.txt, inside it.
+	Nested bool `yaml:"nested"`
+	// TraversalPath, if set, adds an entry holding the token under this name,
+	// e.g. "../../tmp/sheriff.txt", to catch extractors vulnerable to zip
+	// slip.
+	TraversalPath string `yaml:"traversal_path"`
+}
+
+func loadArchiveOptions(cfg config.Provider) (archiveOptions, error) {
+	var opts archiveOptions
+	if err := cfg.Get("archives").Populate(&opts); err != nil {
+		return archiveOptions{}, fmt.Errorf("failed to load archives: %v", err)
+	}
+	return opts, nil
+}
+
+// archiveExtension returns ".tar.gz" or ".tgz" for paths ending in them,
+// which filepath.Ext would cut short at ".gz", and ext otherwise.
+func archiveExtension(path, ext string) string {
+	lower := strings.ToLower(path)
+	for _, double := range []string{".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, double) {
+			return double
+		}
+	}
+	return ext
+}
+
+// archiveEntry is a file written into an archive response.
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// archiveEntries returns the files put in an archive for token, with nested
+// holding the nested archive and its name, if enabled.
+func (opts archiveOptions) archiveEntries(token string, nested *archiveEntry) []archiveEntry {
+	entries := []archiveEntry{{archiveTokenFile, []byte(token)}}
+	if nested != nil {
+		entries = append(entries, *nested)
+	}
+	if opts.TraversalPath != "" {
+		entries = append(entries, archiveEntry{opts.TraversalPath, []byte(token)})
+	}
+	return entries
+}
+
+// buildZIP returns a ZIP archive holding the token, built for this request.
+func (opts archiveOptions) buildZIP(token string) ([]byte, error) {
+	var nested *archiveEntry
+	if opts.Nested {
+		data, err := writeZIP([]archiveEntry{{archiveTokenFile, []byte(token)}})
+		if err != nil {
+			return nil, err
+		}
+		nested = &archiveEntry{"nested.zip", data}
+	}
+	return writeZIP(opts.archiveEntries(token, nested))
+}
+
+// buildTarGz returns a gzipped tar archive holding the token, built for this
+// request.
+func (opts archiveOptions) buildTarGz(token string) ([]byte, error) {
+	var nested *archiveEntry
+	if opts.Nested {
+		data, err := writeTarGz([]archiveEntry{{archiveTokenFile, []byte(token)}})
+		if err != nil {
+			return nil, err
+		}
+		nested = &archiveEntry{"nested.tar.gz", data}
+	}
+	return writeTarGz(opts.archiveEntries(token, nested))
+}
+
+func writeZIP(entries []archiveEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTarGz(entries []archiveEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    entry.name,
+			Mode:    0644,
+			Size:    int64(len(entry.data)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultAuthChallengePrefix is where the auth challenge routes live unless
+// auth.challenge_prefix is configured.
+const defaultAuthChallengePrefix = "/auth"
+
+// authChallengeRealm is the realm advertised in WWW-Authenticate challenges.
+const authChallengeRealm = "ssrf-sheriff"
+
+// authChallengeSchemes are the schemes with a challenge route, e.g.
+// /auth/basic, and the WWW-Authenticate challenge sent for each.
+var authChallengeSchemes = map[string]string{
+	"basic":  fmt.Sprintf("Basic realm=%q", authChallengeRealm),
+	"bearer": fmt.Sprintf("Bearer realm=%q", authChallengeRealm),
+}
+
+// loadAuthChallengePrefix reads auth.challenge_prefix. An empty prefix
+// disables the auth challenge routes.
+func loadAuthChallengePrefix(cfg config.Provider) (string, error) {
+	prefix := defaultAuthChallengePrefix
+	if err := cfg.Get("auth.challenge_prefix").Populate(&prefix); err != nil {
+		return "", fmt.Errorf("failed to load auth.challenge_prefix: %v", err)
+	}
+	if prefix != "" && (!strings.HasPrefix(prefix, "/") || prefix == "/") {
+		return "", fmt.Errorf("invalid auth.challenge_prefix %q: must start with / and not be /", prefix)
+	}
+	return strings.TrimSuffix(prefix, "/"), nil
+}
+
+// AuthChallengeHandler answers <prefix>/<scheme>[/<path>] with a 401 and a
+// WWW-Authenticate challenge for the scheme until the client presents
+// credentials. They are logged, and the request is then answered like a
+// callback to /<path>. This shows whether the SSRF client retries with
+// credentials, and catches any it has configured for the target.
+func (s *SSRFSheriffRouter) AuthChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, s.authChallengePrefix+"/")
+	scheme, path, _ := strings.Cut(rest, "/")
+	challenge, ok := authChallengeSchemes[strings.ToLower(scheme)]
+	if !ok {
+		s.PathHandler(w, r)
+		return
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		// The first request, often the only one, is a callback too.
+		defer s.callbacks.record()
+		s.acceptCallback(r)
+		s.logger.Info("Sending auth challenge",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.String("Scheme", scheme),
+		)
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Authorization", header),
+	}
+	switch presented, credentials := splitAuthorization(header); presented {
+	case "basic":
+		if decoded, err := base64.StdEncoding.DecodeString(credentials); err == nil {
+			user, password, _ := strings.Cut(string(decoded), ":")
+			fields = append(fields, zap.String("User", user), zap.String("Password", password))
+		}
+	case "bearer":
+		fields = append(fields, zap.String("Token", credentials))
+	}
+	s.logger.Warn("Captured credentials", fields...)
+
+	r = r.Clone(r.Context())
+	r.URL.Path = "/" + path
+	r.URL.RawPath = ""
+	s.PathHandler(w, r)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultBodyCaptureBytes is how much of each request body is logged unless
+// body_capture.max_bytes is configured.
+const defaultBodyCaptureBytes = 64 * 1024
+
+// loadBodyCaptureBytes reads the body_capture section and returns how many
+// bytes of each request body to log, or 0 if body capture is disabled.
+func loadBodyCaptureBytes(cfg config.Provider) (int, error) {
+	raw := struct {
+		Enabled  bool `yaml:"enabled"`
+		MaxBytes int  `yaml:"max_bytes"`
+	}{
+		MaxBytes: defaultBodyCaptureBytes,
+	}
+	if err := cfg.Get("body_capture").Populate(&raw); err != nil {
+		return 0, fmt.Errorf("failed to load body_capture: %v", err)
+	}
+	if !raw.Enabled {
+		return 0, nil
+	}
+	if raw.MaxBytes <= 0 {
+		return 0, fmt.Errorf("body_capture.max_bytes must be positive")
+	}
+	return raw.MaxBytes, nil
+}
+
+// captureBody reads up to s.bodyCaptureBytes of the request body and returns
+// log fields describing it. The body is put back together so handlers can
+// still read all of it. Bodies that aren't valid UTF-8 are logged as base64.
+//
+// A gzip-encoded body is logged decompressed, up to s.bodyCaptureBytes of
+// output however well it compresses, along with its compressed and
+// decompressed sizes. Handlers still read it compressed.
+func (s *SSRFSheriffRouter) captureBody(r *http.Request) []zap.Field {
+	if s.bodyCaptureBytes == 0 {
+		return nil
+	}
+
+	body, truncated, err := peekBody(r, s.bodyCaptureBytes)
+	if len(body) == 0 && err == nil {
+		return nil
+	}
+
+	var decodeFields []zap.Field
+	if isGzipEncoded(r) {
+		decoded, decodedTruncated, decodeErr := gunzipBody(body, truncated, s.bodyCaptureBytes)
+		if decodeErr != nil {
+			decodeFields = []zap.Field{zap.NamedError("Request Body Decode Error", decodeErr)}
+		} else {
+			decodeFields = []zap.Field{
+				zap.Int("Request Body Compressed Bytes", len(body)),
+				zap.Int("Request Body Decompressed Bytes", len(decoded)),
+			}
+			body, truncated = decoded, decodedTruncated
+		}
+	}
+
+	logged, encoding := textOrBase64(body)
+	fields := []zap.Field{
+		zap.String("Request Body", logged),
+		zap.String("Request Body Encoding", encoding),
+		zap.Bool("Request Body Truncated", truncated),
+	}
+	fields = append(fields, decodeFields...)
+	if err != nil {
+		fields = append(fields, zap.NamedError("Request Body Error", err))
+	}
+	return fields
+}
+
+// isGzipEncoded reports whether the request body is gzip-encoded, with no
+// other content coding applied.
+func isGzipEncoded(r *http.Request) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		return true
+	}
+	return false
+}
+
+// gunzipBody decompresses a captured gzip body, stopping after limit bytes of
+// output so a decompression bomb costs no more than an uncompressed body.
+// truncated tells whether the captured body is only the start of the real
+// one, in which case running out of input isn't an error. It reports whether
+// the output stops short of the whole decompressed body.
+func gunzipBody(body []byte, truncated bool, limit int) ([]byte, bool, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	decoded, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if len(decoded) > limit {
+		return decoded[:limit], true, nil
+	}
+	if truncated && errors.Is(err, io.ErrUnexpectedEOF) {
+		return decoded, true, nil
+	}
+	return decoded, truncated, err
+}
+
+// peekBody reads up to limit bytes of the request body and puts them back in
+// front of the rest of it, so handlers can still read the whole body. It
+// reports whether the body is longer than limit.
+func peekBody(r *http.Request, limit int) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if len(body) > limit {
+		return body[:limit], true, err
+	}
+	return body, false, err
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// textOrBase64 returns data as text if it is valid UTF-8, and as base64
+// otherwise, along with the encoding used.
+func textOrBase64(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), "utf-8"
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
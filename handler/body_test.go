@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// captureFields runs captureBody on a request with the given body and
+// Content-Encoding, and returns the fields by key along with the body the
+// handler would read.
+func captureFields(t *testing.T, limit int, body []byte, contentEncoding string) (map[string]interface{}, []byte) {
+	t.Helper()
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), bodyCaptureBytes: limit}
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	if contentEncoding != "" {
+		r.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range s.captureBody(r) {
+		f.AddTo(enc)
+	}
+	rest, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc.Fields, rest
+}
+
+func TestCaptureBodyDecodesGzip(t *testing.T) {
+	compressed := gzipped(t, "token=abcdefghijklmnopqrst")
+	fields, rest := captureFields(t, 1024, compressed, "gzip")
+
+	if got := fields["Request Body"]; got != "token=abcdefghijklmnopqrst" {
+		t.Errorf("Request Body = %q, want the decompressed body", got)
+	}
+	if got := fields["Request Body Compressed Bytes"]; got != int64(len(compressed)) {
+		t.Errorf("Request Body Compressed Bytes = %v, want %d", got, len(compressed))
+	}
+	if got := fields["Request Body Decompressed Bytes"]; got != int64(26) {
+		t.Errorf("Request Body Decompressed Bytes = %v, want 26", got)
+	}
+	if got := fields["Request Body Truncated"]; got != false {
+		t.Errorf("Request Body Truncated = %v, want false", got)
+	}
+	if !bytes.Equal(rest, compressed) {
+		t.Error("handler no longer reads the body as sent")
+	}
+}
+
+func TestCaptureBodyCapsDecompression(t *testing.T) {
+	// A few hundred bytes that expand to a megabyte.
+	bomb := gzipped(t, strings.Repeat("A", 1<<20))
+	fields, _ := captureFields(t, 1024, bomb, "x-gzip")
+
+	if got := fields["Request Body Decompressed Bytes"]; got != int64(1024) {
+		t.Errorf("Request Body Decompressed Bytes = %v, want the 1024 byte cap", got)
+	}
+	if got := fields["Request Body Truncated"]; got != true {
+		t.Errorf("Request Body Truncated = %v, want true", got)
+	}
+}
+
+func TestCaptureBodyDecodesTruncatedGzip(t *testing.T) {
+	// Incompressible enough that capturing 256 bytes cuts the stream short.
+	var data strings.Builder
+	for i := 0; data.Len() < 4096; i++ {
+		data.WriteString(strings.Repeat(string(rune('a'+i%26)), i%7+1))
+		data.WriteString(string(rune('0' + i%10)))
+	}
+	fields, _ := captureFields(t, 256, gzipped(t, data.String()), "gzip")
+
+	if _, ok := fields["Request Body Decode Error"]; ok {
+		t.Fatalf("truncated gzip body reported as an error: %v", fields["Request Body Decode Error"])
+	}
+	got, _ := fields["Request Body"].(string)
+	if got == "" || !strings.HasPrefix(data.String(), got) {
+		t.Errorf("Request Body = %q, want a prefix of the decompressed body", got)
+	}
+	if got := fields["Request Body Truncated"]; got != true {
+		t.Errorf("Request Body Truncated = %v, want true", got)
+	}
+}
+
+func TestCaptureBodyKeepsInvalidGzip(t *testing.T) {
+	fields, _ := captureFields(t, 1024, []byte("not gzip"), "gzip")
+
+	if got := fields["Request Body"]; got != "not gzip" {
+		t.Errorf("Request Body = %q, want the body as sent", got)
+	}
+	if _, ok := fields["Request Body Decode Error"]; !ok {
+		t.Error("no Request Body Decode Error")
+	}
+}
+
+func TestCaptureBodyIgnoresOtherEncodings(t *testing.T) {
+	compressed := gzipped(t, "token")
+	fields, _ := captureFields(t, 1024, compressed, "br")
+
+	if _, ok := fields["Request Body Decompressed Bytes"]; ok {
+		t.Error("body decoded despite not being gzip-encoded")
+	}
+	if got := fields["Request Body Encoding"]; got != "base64" {
+		t.Errorf("Request Body Encoding = %v, want base64", got)
+	}
+}
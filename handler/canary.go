@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/notifier"
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultCanaryBodyBytes is how much of each request body is searched for
+// echoed tokens unless canary.max_body_bytes is configured.
+const defaultCanaryBodyBytes = 64 * 1024
+
+// requestTokenPattern matches the shape of per-request tokens, so the ones
+// found in a request can be looked up instead of searching for every issued
+// token.
+var requestTokenPattern = regexp.MustCompile(`[0-9a-z]+-[0-9a-f]{24}`)
+
+// canaryConfig controls the search for tokens echoed back in inbound
+// requests.
+type canaryConfig struct {
+	enabled   bool
+	bodyBytes int
+}
+
+func loadCanary(cfg config.Provider) (canaryConfig, error) {
+	raw := struct {
+		Enabled      bool `yaml:"enabled"`
+		MaxBodyBytes int  `yaml:"max_body_bytes"`
+	}{
+		MaxBodyBytes: defaultCanaryBodyBytes,
+	}
+	if err := cfg.Get("canary").Populate(&raw); err != nil {
+		return canaryConfig{}, fmt.Errorf("failed to load canary: %v", err)
+	}
+	if raw.MaxBodyBytes < 0 {
+		return canaryConfig{}, fmt.Errorf("canary.max_body_bytes can't be negative")
+	}
+	return canaryConfig{enabled: raw.Enabled, bodyBytes: raw.MaxBodyBytes}, nil
+}
+
+// detectEchoedToken looks for a token the sheriff has served anywhere in r:
+// its path, query, headers or the start of its body. A token coming back in a
+// later request means the target stored or reflected the response, e.g.
+// second-order SSRF. This is logged as a warning, counted and sent to the
+// webhooks as a high priority token_echo event.
+func (s *SSRFSheriffRouter) detectEchoedToken(r *http.Request) {
+	if !s.canary.enabled {
+		return
+	}
+	token, location, ok := s.findEchoedToken(r)
+	if !ok {
+		return
+	}
+
+	s.metrics.tokenEchoed(location)
+	s.logger.Warn("Token echoed back",
+		zap.String("IP", clientIP(r)),
+		zap.String("Path", r.URL.Path),
+		zap.String("Token", token),
+		zap.String("Location", location),
+	)
+	addLogFields(r,
+		zap.String("Echoed Token", token),
+		zap.String("Echoed Token Location", location),
+	)
+	if s.webhooks != nil {
+		s.webhooks.Notify(notifier.Event{
+			Type:     notifier.EventTokenEcho,
+			Priority: notifier.PriorityHigh,
+			Time:     time.Now().UTC(),
+			IP:       clientIP(r),
+			Method:   r.Method,
+			Host:     r.Host,
+			Path:     r.URL.Path,
+			Token:    token,
+			Location: location,
+			Headers:  r.Header,
+			Session:  requestSession(r),
+		})
+	}
+}
+
+// findEchoedToken returns the first served token found in r and where it
+// was found: "path", "query", "header:<Name>" or "body". A minted token in
+// the path is how its callback URLs are built, so it doesn't count.
+func (s *SSRFSheriffRouter) findEchoedToken(r *http.Request) (string, string, bool) {
+	tokens := s.servedTokens()
+	minted, _ := s.tokens.match(r)
+
+	find := func(text, skip string) (string, bool) {
+		for _, token := range tokens {
+			if token != skip && strings.Contains(text, token) {
+				return token, true
+			}
+		}
+		if s.requestTokens != nil {
+			for _, token := range requestTokenPattern.FindAllString(text, -1) {
+				if _, ok := s.requestTokens.lookup(token); ok {
+					return token, true
+				}
+			}
+		}
+		return "", false
+	}
+
+	if token, ok := find(unescape(r.URL.Path), minted); ok {
+		return token, "path", true
+	}
+	if token, ok := find(unescape(r.URL.RawQuery), ""); ok {
+		return token, "query", true
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			if token, ok := find(value, ""); ok {
+				return token, "header:" + name, true
+			}
+		}
+	}
+	if s.canary.bodyBytes > 0 {
+		if body, _, _ := peekBody(r, s.canary.bodyBytes); len(body) > 0 {
+			if token, ok := find(unescape(string(body)), ""); ok {
+				return token, "body", true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// servedTokens returns every fixed token the sheriff answers with: each
+// generation of ssrf_token, the per-host tokens and the minted tokens still
+// valid.
+func (s *SSRFSheriffRouter) servedTokens() []string {
+	var tokens []string
+	for _, gen := range s.generations.list() {
+		tokens = append(tokens, gen.Token)
+	}
+	for _, rule := range s.hostRules {
+		tokens = append(tokens, rule.profile.Token)
+	}
+	tokens = append(tokens, s.tokens.list()...)
+
+	served := tokens[:0]
+	for _, token := range tokens {
+		if token != "" {
+			served = append(served, token)
+		}
+	}
+	return served
+}
+
+// unescape undoes URL encoding, so tokens are found in encoded paths, query
+// strings and form bodies. Text that isn't validly encoded is returned as is.
+func unescape(text string) string {
+	if unescaped, err := url.QueryUnescape(text); err == nil {
+		return unescaped
+	}
+	return text
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// metadataEmulationConfig configures the fake cloud metadata endpoints.
+type metadataEmulationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Role is the name of the AWS IAM role and the GCP/Azure identity whose
+	// credentials are handed out.
+	Role string `yaml:"role"`
+}
+
+var defaultMetadataEmulation = metadataEmulationConfig{Role: "ssrf-sheriff"}
+
+func loadMetadataEmulation(cfg config.Provider) (metadataEmulationConfig, error) {
+	me := defaultMetadataEmulation
+	if err := cfg.Get("metadata_emulation").Populate(&me); err != nil {
+		return me, fmt.Errorf("failed to load metadata_emulation: %v", err)
+	}
+	if me.Enabled && me.Role == "" {
+		return me, fmt.Errorf("metadata_emulation.role must not be empty")
+	}
+	return me, nil
+}
+
+// metadataProvider is one emulated cloud metadata service.
+type metadataProvider struct {
+	name   string
+	prefix string
+
+	// header and value must be present on requests, as the real service
+	// requires, or they are rejected with rejectStatus and rejectBody.
+	header, value string
+	rejectStatus  int
+	rejectBody    string
+
+	// documents returns the documents served by path, relative to prefix.
+	documents func(role, token string) map[string]string
+}
+
+var metadataProviders = []metadataProvider{
+	{
+		name:      "AWS",
+		prefix:    "/latest/",
+		documents: awsMetadataDocuments,
+	},
+	{
+		name:         "GCP",
+		prefix:       "/computeMetadata/v1/",
+		header:       "Metadata-Flavor",
+		value:        "Google",
+		rejectStatus: http.StatusForbidden,
+		rejectBody:   "Missing Metadata-Flavor:Google header.\n",
+		documents:    gcpMetadataDocuments,
+	},
+	{
+		name:         "Azure",
+		prefix:       "/metadata/",
+		header:       "Metadata",
+		value:        "true",
+		rejectStatus: http.StatusBadRequest,
+		rejectBody:   `{"error":"Bad request. Required metadata header not specified"}`,
+		documents:    azureMetadataDocuments,
+	},
+}
+
+// metadataContentType returns the Content-Type of a metadata document, which
+// is either JSON or plain text.
+func metadataContentType(doc string) string {
+	if strings.HasPrefix(doc, "{") {
+		return "application/json"
+	}
+	return "text/plain"
+}
+
+func metadataJSON(v interface{}) string {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	return string(b)
+}
+
+func awsMetadataDocuments(role, token string) map[string]string {
+	now := time.Now().UTC()
+	return map[string]string{
+		"api/token": token,
+		"meta-data/": strings.Join([]string{
+			"ami-id", "hostname", "iam/", "instance-id", "instance-type",
+			"local-hostname", "local-ipv4", "placement/", "public-ipv4",
+		}, "\n"),
+		"meta-data/ami-id":                    "ami-0c55b159cbfafe1f0",
+		"meta-data/hostname":                  "ip-10-0-0-10.ec2.internal",
+		"meta-data/local-hostname":            "ip-10-0-0-10.ec2.internal",
+		"meta-data/instance-id":               "i-0ssrfsheriff0000",
+		"meta-data/instance-type":             "t3.medium",
+		"meta-data/local-ipv4":                "10.0.0.10",
+		"meta-data/public-ipv4":               "203.0.113.10",
+		"meta-data/placement/":                "availability-zone\nregion",
+		"meta-data/placement/region":          "us-east-1",
+		"meta-data/iam/":                      "info\nsecurity-credentials/",
+		"meta-data/iam/security-credentials/": role,
+		"meta-data/iam/info": metadataJSON(map[string]string{
+			"Code":               "Success",
+			"LastUpdated":        now.Format(time.RFC3339),
+			"InstanceProfileArn": "arn:aws:iam::123456789012:instance-profile/" + role,
+			"InstanceProfileId":  "AIPASSRFSHERIFF00000",
+		}),
+		"meta-data/iam/security-credentials/" + role: metadataJSON(map[string]string{
+			"Code":            "Success",
+			"LastUpdated":     now.Format(time.RFC3339),
+			"Type":            "AWS-HMAC",
+			"AccessKeyId":     "ASIASSRFSHERIFF00000",
+			"SecretAccessKey": token,
+			"Token":           token,
+			"Expiration":      now.Add(6 * time.Hour).Format(time.RFC3339),
+		}),
+		"user-data": "#!/bin/bash\n# token=" + token + "\n",
+		"dynamic/instance-identity/document": metadataJSON(map[string]string{
+			"accountId":        "123456789012",
+			"architecture":     "x86_64",
+			"availabilityZone": "us-east-1a",
+			"imageId":          "ami-0c55b159cbfafe1f0",
+			"instanceId":       "i-0ssrfsheriff0000",
+			"instanceType":     "t3.medium",
+			"privateIp":        "10.0.0.10",
+			"region":           "us-east-1",
+			"token":            token,
+		}),
+	}
+}
+
+func gcpMetadataDocuments(role, token string) map[string]string {
+	email := role + "@ssrf-sheriff.iam.gserviceaccount.com"
+	return map[string]string{
+		"":                                   "instance/\nproject/\n",
+		"project/project-id":                 "ssrf-sheriff",
+		"instance/":                          "hostname\nid\nservice-accounts/\nzone\n",
+		"instance/hostname":                  "ssrf-sheriff.c.ssrf-sheriff.internal",
+		"instance/id":                        "1234567890123456789",
+		"instance/zone":                      "projects/123456789012/zones/us-central1-a",
+		"instance/service-accounts/":         "default/\n" + email + "/\n",
+		"instance/service-accounts/default/": "aliases\nemail\nscopes\ntoken\n",
+		"instance/service-accounts/default/email":  email,
+		"instance/service-accounts/default/scopes": "https://www.googleapis.com/auth/cloud-platform\n",
+		"instance/service-accounts/default/token": metadataJSON(map[string]interface{}{
+			"access_token": token,
+			"expires_in":   3599,
+			"token_type":   "Bearer",
+		}),
+	}
+}
+
+func azureMetadataDocuments(role, token string) map[string]string {
+	return map[string]string{
+		"instance": metadataJSON(map[string]interface{}{
+			"compute": map[string]string{
+				"location":          "eastus",
+				"name":              role,
+				"resourceGroupName": "ssrf-sheriff",
+				"subscriptionId":    "00000000-0000-0000-0000-000000000000",
+				"vmId":              "00000000-0000-0000-0000-000000000001",
+				"vmSize":            "Standard_B2s",
+				"tags":              "token:" + token,
+			},
+			"network": map[string]interface{}{
+				"interface": []interface{}{map[string]interface{}{
+					"ipv4": map[string]interface{}{
+						"ipAddress": []map[string]string{{"privateIpAddress": "10.0.0.10", "publicIpAddress": "203.0.113.10"}},
+					},
+				}},
+			},
+		}),
+		"identity/oauth2/token": metadataJSON(map[string]string{
+			"access_token": token,
+			"client_id":    "00000000-0000-0000-0000-000000000002",
+			"expires_in":   "3599",
+			"resource":     "https://management.azure.com/",
+			"token_type":   "Bearer",
+		}),
+	}
+}
+
+// CloudMetadataHandler answers requests shaped like AWS, GCP and Azure
+// metadata service requests with realistic fake documents carrying the
+// token, to prove an SSRF can reach metadata-shaped responses and see whether
+// the target parses them. Like the real services, GCP and Azure requests
+// without the metadata header are rejected, but they are callbacks all the
+// same.
+func (s *SSRFSheriffRouter) CloudMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	defer s.callbacks.record()
+
+	profile := s.acceptCallback(r)
+	for _, provider := range metadataProviders {
+		if !strings.HasPrefix(r.URL.Path, provider.prefix) {
+			continue
+		}
+
+		hasHeader := provider.header == "" || r.Header.Get(provider.header) == provider.value
+		s.logger.Info("Cloud metadata request",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.String("Provider", provider.name),
+			zap.Bool("Metadata Header", hasHeader),
+		)
+		if !hasHeader {
+			w.Header().Set("Content-Type", metadataContentType(provider.rejectBody))
+			w.WriteHeader(provider.rejectStatus)
+			w.Write([]byte(provider.rejectBody))
+			return
+		}
+
+		doc, ok := provider.documents(s.metadataEmulation.Role, profile.Token)[strings.TrimPrefix(r.URL.Path, provider.prefix)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", metadataContentType(doc))
+		if provider.header != "" {
+			w.Header().Set(provider.header, provider.value)
+		}
+		s.writeResponse(w, r, http.StatusOK, []byte(doc))
+		return
+	}
+	http.NotFound(w, r)
+}
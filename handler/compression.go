@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// compressors are the supported content codings. "deflate" is the zlib
+// format, as HTTP defines it.
+var compressors = map[string]func(io.Writer) io.WriteCloser{
+	"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+}
+
+// loadCompression reads http.compression, the content codings responses may
+// be compressed with in order of preference.
+func loadCompression(cfg config.Provider) ([]string, error) {
+	var encodings []string
+	if err := cfg.Get("http.compression").Populate(&encodings); err != nil {
+		return nil, fmt.Errorf("failed to load http.compression: %v", err)
+	}
+	for i, encoding := range encodings {
+		encoding = strings.ToLower(encoding)
+		if _, ok := compressors[encoding]; !ok {
+			return nil, fmt.Errorf("unsupported encoding %q in http.compression", encoding)
+		}
+		encodings[i] = encoding
+	}
+	return encodings, nil
+}
+
+// forcedEncoding compresses the responses to paths matching Path with
+// Encoding whatever the client accepts, to see whether it decodes them.
+type forcedEncoding struct {
+	// Path is a path.Match pattern, e.g. "/gzip/*".
+	Path string `yaml:"path"`
+	// Encoding is one of the supported content codings, or "identity" to
+	// never compress matching responses.
+	Encoding string `yaml:"encoding"`
+}
+
+// loadForcedEncodings reads http.forced_encodings. The first entry matching
+// the request path applies.
+func loadForcedEncodings(cfg config.Provider) ([]forcedEncoding, error) {
+	var forced []forcedEncoding
+	if err := cfg.Get("http.forced_encodings").Populate(&forced); err != nil {
+		return nil, fmt.Errorf("failed to load http.forced_encodings: %v", err)
+	}
+	for i, f := range forced {
+		if _, err := path.Match(f.Path, ""); err != nil || f.Path == "" {
+			return nil, fmt.Errorf("invalid path %q in http.forced_encodings", f.Path)
+		}
+		f.Encoding = strings.ToLower(f.Encoding)
+		if _, ok := compressors[f.Encoding]; !ok && f.Encoding != "identity" {
+			return nil, fmt.Errorf("unsupported encoding %q in http.forced_encodings", f.Encoding)
+		}
+		forced[i] = f
+	}
+	return forced, nil
+}
+
+// forcedEncodingFor returns the coding forced for the path, or "" if none is.
+func (s *SSRFSheriffRouter) forcedEncodingFor(urlPath string) string {
+	for _, f := range s.forcedEncodings {
+		if ok, _ := path.Match(f.Path, urlPath); ok {
+			return f.Encoding
+		}
+	}
+	return ""
+}
+
+// negotiateEncoding picks the content coding to respond with from the client's
+// Accept-Encoding header. The coding with the highest quality value wins, and
+// ties go to the earliest one in supported. It returns "" if the client
+// accepts none of them.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a body of the given Content-Type is worth
+// compressing. Images, audio, video, archives and Office documents already
+// are, except for SVG, which is text.
+func compressible(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/", "application/vnd.openxmlformats-officedocument.", "application/zip", "application/gzip"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressResponse compresses body with the coding negotiated with the client
+// and sets the matching response headers. The body is returned unchanged if
+// compression is disabled, the client doesn't accept any of the configured
+// codings or the content is already compressed. A coding forced for the path
+// in http.forced_encodings is used regardless of all of these.
+func (s *SSRFSheriffRouter) compressResponse(w http.ResponseWriter, r *http.Request, body []byte) ([]byte, error) {
+	if w.Header().Get("Content-Encoding") != "" {
+		return body, nil
+	}
+
+	encoding := s.forcedEncodingFor(r.URL.Path)
+	switch encoding {
+	case "identity":
+		return body, nil
+	case "":
+		if len(s.compression) == 0 || len(body) == 0 || !compressible(w.Header().Get("Content-Type")) {
+			return body, nil
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), s.compression)
+		if encoding == "" {
+			return body, nil
+		}
+	default:
+		addLogFields(r,
+			zap.String("Forced Content-Encoding", encoding),
+			zap.String("Accept-Encoding", r.Header.Get("Accept-Encoding")),
+		)
+	}
+
+	var buf bytes.Buffer
+	cw := compressors[encoding](&buf)
+	if _, err := cw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	return buf.Bytes(), nil
+}
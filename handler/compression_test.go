@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"go.uber.org/zap"
+)
+
+// decoders undo each supported content coding.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+}
+
+const compressionTestBody = "ssrf-sheriff token abcdefghijklmnopqrst, repeated: abcdefghijklmnopqrst"
+
+// serveCompressed writes compressionTestBody as contentType through
+// writeResponse and returns the recorded response.
+func serveCompressed(s *SSRFSheriffRouter, path, contentType, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", contentType)
+	s.writeResponse(w, r, http.StatusOK, []byte(compressionTestBody))
+	return w
+}
+
+// decodeBody decodes a recorded response according to its Content-Encoding.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	encoding := w.Header().Get("Content-Encoding")
+	if encoding == "" {
+		return w.Body.String()
+	}
+	decode, ok := decoders[encoding]
+	if !ok {
+		t.Fatalf("unexpected Content-Encoding %q", encoding)
+	}
+	r, err := decode(w.Body)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return string(body)
+}
+
+func TestCompressedResponsesDecode(t *testing.T) {
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), compression: []string{"br", "gzip", "deflate"}}
+	for encoding := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			w := serveCompressed(s, "/x.txt", "text/plain", encoding)
+			if got := w.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, encoding)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if w.Body.String() == compressionTestBody {
+				t.Error("body wasn't compressed")
+			}
+			if got := decodeBody(t, w); got != compressionTestBody {
+				t.Errorf("decoded body = %q, want %q", got, compressionTestBody)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), compression: []string{"br", "gzip"}}
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		wantEncoding   string
+		wantVary       bool
+	}{
+		{"preferred coding", "text/html", "gzip, deflate, br", "br", true},
+		{"quality wins over preference", "text/html", "br;q=0.5, gzip", "gzip", true},
+		{"refused coding", "text/html", "br;q=0, gzip;q=0.1", "gzip", true},
+		{"wildcard", "text/html", "*", "br", true},
+		{"unsupported codings only", "text/html", "deflate, zstd", "", true},
+		{"no Accept-Encoding", "text/html", "", "", true},
+		{"SVG is text", "image/svg+xml", "gzip", "gzip", true},
+		{"images are left alone", "image/png", "gzip", "", false},
+		{"archives are left alone", "application/zip", "gzip", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveCompressed(s, "/x", tt.contentType, tt.acceptEncoding)
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Vary: Accept-Encoding set = %v, want %v", got, tt.wantVary)
+			}
+			if got := decodeBody(t, w); got != compressionTestBody {
+				t.Errorf("decoded body = %q, want %q", got, compressionTestBody)
+			}
+		})
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	s := &SSRFSheriffRouter{logger: zap.NewNop()}
+	w := serveCompressed(s, "/x", "text/plain", "gzip, br")
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if got := w.Header().Get("Vary"); got != "" {
+		t.Errorf("Vary = %q, want none", got)
+	}
+	if w.Body.String() != compressionTestBody {
+		t.Errorf("body = %q, want %q", w.Body.String(), compressionTestBody)
+	}
+}
+
+func TestForcedEncodings(t *testing.T) {
+	s := &SSRFSheriffRouter{
+		logger:      zap.NewNop(),
+		compression: []string{"gzip"},
+		forcedEncodings: []forcedEncoding{
+			{Path: "/br/*", Encoding: "br"},
+			{Path: "/plain/*", Encoding: "identity"},
+		},
+	}
+
+	// Forced whatever the client accepts, even for images.
+	w := serveCompressed(s, "/br/x.png", "image/png", "")
+	if got := w.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+	if got := decodeBody(t, w); got != compressionTestBody {
+		t.Errorf("decoded body = %q, want %q", got, compressionTestBody)
+	}
+
+	w = serveCompressed(s, "/plain/x", "text/plain", "gzip")
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if w.Body.String() != compressionTestBody {
+		t.Errorf("body = %q, want %q", w.Body.String(), compressionTestBody)
+	}
+}
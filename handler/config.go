@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// DefaultConfigFile is the YAML config file read unless another one is given
+// with -config.
+const DefaultConfigFile = "config/base.yaml"
+
+// ConfigFlags are the config sources given on the command line.
+type ConfigFlags struct {
+	// File is the YAML config file. It is skipped if it is the default one
+	// and doesn't exist.
+	File string
+	// Overrides are key=value pairs given with -set, where key is a dotted
+	// config path such as http.address.
+	Overrides []string
+}
+
+// defaultConfig holds the defaults that the YAML file, environment and
+// command line are layered over. Everything else defaults in the code that
+// loads it.
+var defaultConfig = map[string]interface{}{
+	"http": map[string]interface{}{
+		"address": ":8000",
+	},
+}
+
+// envOverrides maps environment variables to the config keys they override,
+// so the sheriff can be configured in a container without a config file.
+var envOverrides = []struct {
+	env string
+	key string
+}{
+	{"SSRF_SHERIFF_TOKEN", "ssrf_token"},
+	{"SSRF_SHERIFF_HTTP_ADDRESS", "http.address"},
+	{"SSRF_SHERIFF_TLS_ADDRESS", "http.tls.address"},
+	{"SSRF_SHERIFF_TLS_CERT_FILE", "http.tls.cert_file"},
+	{"SSRF_SHERIFF_TLS_KEY_FILE", "http.tls.key_file"},
+	{"SSRF_SHERIFF_ADMIN_ADDRESS", "admin.address"},
+	{"SSRF_SHERIFF_ADMIN_TOKEN", "admin.token"},
+	{"SSRF_SHERIFF_DNS_ADDRESS", "dns.address"},
+	{"SSRF_SHERIFF_DNS_ZONE", "dns.zone"},
+	{"SSRF_SHERIFF_DNS_A", "dns.a"},
+	{"SSRF_SHERIFF_FTP_ADDRESS", "ftp.address"},
+	{"SSRF_SHERIFF_SMTP_ADDRESS", "smtp.address"},
+	{"SSRF_SHERIFF_REDIS_ADDRESS", "redis.address"},
+	{"SSRF_SHERIFF_STORAGE_DRIVER", "storage.driver"},
+	{"SSRF_SHERIFF_STORAGE_PATH", "storage.path"},
+}
+
+// NewConfigProvider returns a config.Provider layering, from lowest to
+// highest precedence, the built-in defaults, the YAML config file, the
+// SSRF_SHERIFF_* environment variables and -set flags.
+func NewConfigProvider(flags ConfigFlags) (config.Provider, error) {
+	opts := []config.YAMLOption{config.Static(defaultConfig)}
+
+	file := flags.File
+	if file == "" {
+		file = DefaultConfigFile
+	}
+	if _, err := os.Stat(file); err == nil {
+		opts = append(opts, config.File(file))
+	} else if file != DefaultConfigFile || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load config file %q: %v", file, err)
+	}
+
+	env := make(map[string]interface{})
+	for _, o := range envOverrides {
+		if value, ok := os.LookupEnv(o.env); ok {
+			setConfigKey(env, o.key, value)
+		}
+	}
+	opts = append(opts, config.Static(env))
+
+	set := make(map[string]interface{})
+	for _, override := range flags.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid -set %q: expected key=value", override)
+		}
+		setConfigKey(set, key, value)
+	}
+	opts = append(opts, config.Static(set))
+
+	return config.NewYAML(opts...)
+}
+
+// setConfigKey sets the dotted key in the nested map m to value, typed as a
+// bool or integer when it reads as one so it can populate fields of those
+// types. Integers and booleans still populate string fields as written.
+func setConfigKey(m map[string]interface{}, key string, value string) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := m[part].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[part] = child
+		}
+		m = child
+	}
+
+	var typed interface{} = value
+	if value == "true" || value == "false" {
+		typed = value == "true"
+	} else if n, err := strconv.Atoi(value); err == nil && strconv.Itoa(n) == value {
+		typed = n
+	}
+	m[parts[len(parts)-1]] = typed
+}
+
+// StringsFlag is a flag.Value collecting every occurrence of a repeated flag.
+type StringsFlag []string
+
+func (f *StringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *StringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
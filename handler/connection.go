@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// closeParam is the query parameter that asks for the connection to be closed
+// after the response.
+const closeParam = "close"
+
+// forceClose marks the response with Connection: close when the request asks
+// for it with ?close=true. net/http sees the header and closes the connection
+// once the response is written, which shows whether the SSRF client retries
+// or reconnects for its next request.
+func forceClose(w http.ResponseWriter, r *http.Request) {
+	if closeConn, _ := strconv.ParseBool(r.URL.Query().Get(closeParam)); closeConn {
+		w.Header().Set("Connection", "close")
+	}
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCSVColumns are used when no csv.columns are configured, mirroring
+// the original key/value template which only contained the token.
+var defaultCSVColumns = []string{"token"}
+
+// csvColumnValue resolves a single configured CSV column against the request.
+//
+// Supported columns are "token", "timestamp", "ip", "method", "path", "host",
+// "user_agent", and reflected values in the form "header:<Name>" or
+// "query:<name>". Unknown columns resolve to an empty value.
+func (s *SSRFSheriffRouter) csvColumnValue(column string, r *http.Request, token string) string {
+	switch {
+	case column == "token":
+		return token
+	case column == "timestamp":
+		return time.Now().UTC().Format(time.RFC3339)
+	case column == "ip":
+		return clientIP(r)
+	case column == "method":
+		return r.Method
+	case column == "path":
+		return r.URL.Path
+	case column == "host":
+		return r.Host
+	case column == "user_agent":
+		return r.UserAgent()
+	case strings.HasPrefix(column, "header:"):
+		return r.Header.Get(strings.TrimPrefix(column, "header:"))
+	case strings.HasPrefix(column, "query:"):
+		return r.URL.Query().Get(strings.TrimPrefix(column, "query:"))
+	default:
+		return ""
+	}
+}
+
+// renderCSV builds a CSV document with a header row of the configured column
+// names followed by a single row of values. encoding/csv takes care of quoting
+// so tokens containing commas, quotes or newlines stay intact.
+func (s *SSRFSheriffRouter) renderCSV(r *http.Request, token string) string {
+	columns := s.csvColumns
+	if len(columns) == 0 {
+		columns = defaultCSVColumns
+	}
+
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = s.csvColumnValue(column, r, token)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(columns)
+	w.Write(values)
+	w.Flush()
+	return buf.String()
+}
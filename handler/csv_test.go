@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVQuotesTokens(t *testing.T) {
+	s := &SSRFSheriffRouter{csvColumns: []string{"token", "ip", "header:X-Probe", "query:q"}}
+	r := httptest.NewRequest("GET", "/export.csv?q=a%22b", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Probe", "line\nbreak")
+
+	for _, token := range []string{"plain", "comma,token", `quote"token`, "=cmd|' /C calc'!A0"} {
+		body := s.renderCSV(r, token)
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatalf("%q: rendered CSV doesn't parse: %v\n%s", token, err, body)
+		}
+		want := [][]string{
+			{"token", "ip", "header:X-Probe", "query:q"},
+			{token, "192.0.2.1", "line\nbreak", `a"b`},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("%q: records = %q, want %q", token, records, want)
+		}
+	}
+}
+
+func TestCSVDefaultsToTheToken(t *testing.T) {
+	s := &SSRFSheriffRouter{}
+	body := s.renderCSV(httptest.NewRequest("GET", "/x.csv", nil), `a,"b"`)
+	if want := "token\n\"a,\"\"b\"\"\"\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// delayConfig configures artificially slowed responses, used to measure
+// SSRF timing side-channels and to test client timeouts.
+type delayConfig struct {
+	// Prefix of the /delay/<seconds>/<path> route. Empty disables it.
+	Prefix string `yaml:"prefix"`
+	// Global is added to every callback response.
+	Global time.Duration `yaml:"global"`
+	// Max is the longest delay a request may ask for.
+	Max time.Duration `yaml:"max"`
+}
+
+var defaultDelay = delayConfig{
+	Prefix: "/delay",
+	Max:    time.Minute,
+}
+
+func loadDelay(cfg config.Provider) (delayConfig, error) {
+	dc := defaultDelay
+	if err := cfg.Get("delay").Populate(&dc); err != nil {
+		return dc, fmt.Errorf("failed to load delay: %v", err)
+	}
+	if dc.Prefix != "" && (!strings.HasPrefix(dc.Prefix, "/") || dc.Prefix == "/") {
+		return dc, fmt.Errorf("invalid delay.prefix %q: must start with / and not be /", dc.Prefix)
+	}
+	dc.Prefix = strings.TrimSuffix(dc.Prefix, "/")
+	if dc.Global < 0 || dc.Max < 0 {
+		return dc, fmt.Errorf("delay.global and delay.max must not be negative")
+	}
+	return dc, nil
+}
+
+// sleep waits for d, returning early with false if the client goes away.
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// DelayHandler answers /delay/<seconds>/<path> like a callback to /<path>,
+// after waiting the given (possibly fractional) number of seconds, capped at
+// delay.max. Paths without a valid number of seconds are still callbacks,
+// answered by PathHandler without a delay.
+func (s *SSRFSheriffRouter) DelayHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, s.delay.Prefix+"/")
+	seconds, path, _ := strings.Cut(rest, "/")
+
+	secs, err := strconv.ParseFloat(seconds, 64)
+	if err != nil || secs < 0 {
+		s.PathHandler(w, r)
+		return
+	}
+	d := s.delay.Max
+	if secs < d.Seconds() {
+		d = time.Duration(secs * float64(time.Second))
+	}
+
+	s.logger.Info("Delaying response",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.Duration("Delay", d),
+	)
+	if !sleep(r, d) {
+		return
+	}
+
+	r = r.Clone(r.Context())
+	r.URL.Path = "/" + path
+	r.URL.RawPath = ""
+	s.PathHandler(w, r)
+}
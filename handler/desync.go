@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// desyncMaxFollowUp caps the bytes read and logged after a desync payload.
+const desyncMaxFollowUp = 64 << 10
+
+// desyncConfig is the research.desync section of the config.
+type desyncConfig struct {
+	// Prefix of the desync routes. Empty disables them.
+	Prefix string `yaml:"prefix"`
+	// Linger is how long the connection is kept open after the payload,
+	// logging whatever the client or a proxy sends next on it.
+	Linger time.Duration `yaml:"linger"`
+}
+
+var defaultDesync = desyncConfig{Linger: 5 * time.Second}
+
+// loadDesync reads research.desync. An empty prefix, the default, disables
+// the desync routes.
+func loadDesync(cfg config.Provider) (desyncConfig, error) {
+	dc := defaultDesync
+	if err := cfg.Get("research.desync").Populate(&dc); err != nil {
+		return dc, fmt.Errorf("failed to load research.desync: %v", err)
+	}
+	if dc.Prefix != "" && (!strings.HasPrefix(dc.Prefix, "/") || dc.Prefix == "/") {
+		return dc, fmt.Errorf("invalid research.desync.prefix %q: must start with / and not be /", dc.Prefix)
+	}
+	if dc.Linger < 0 {
+		return dc, fmt.Errorf("research.desync.linger must not be negative")
+	}
+	dc.Prefix = strings.TrimSuffix(dc.Prefix, "/")
+	return dc, nil
+}
+
+// desyncPayloads write the raw responses of the desync routes. Each is given
+// the token and the body to frame, and leaves a smuggled response after the
+// end of the body as framed by one of the ways a parser could read it.
+var desyncPayloads = map[string]func(buf *bufio.ReadWriter, token, body string){
+	// Content-Length and Transfer-Encoding both present. Parsers honoring
+	// Content-Length read the chunked framing as the body and stop short of
+	// the smuggled response; those honoring Transfer-Encoding, as RFC 7230
+	// requires, read the body and then the smuggled response.
+	"cl-te": func(buf *bufio.ReadWriter, token, body string) {
+		chunked := fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(body), body)
+		fmt.Fprintf(buf, "Content-Length: %d\r\n", len(chunked))
+		fmt.Fprintf(buf, "Transfer-Encoding: chunked\r\n\r\n")
+		fmt.Fprint(buf, chunked)
+		fmt.Fprint(buf, smuggledResponse(token))
+	},
+	// Two conflicting Content-Length headers. Parsers taking the first read
+	// only the body; those taking the last read the smuggled response as part
+	// of it. RFC 7230 says the response must be rejected.
+	"double-cl": func(buf *bufio.ReadWriter, token, body string) {
+		smuggled := smuggledResponse(token)
+		fmt.Fprintf(buf, "Content-Length: %d\r\n", len(body))
+		fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(body)+len(smuggled))
+		fmt.Fprint(buf, body)
+		fmt.Fprint(buf, smuggled)
+	},
+	// Like cl-te, but with the Transfer-Encoding value obfuscated by a tab.
+	// Lenient parsers still recognize chunked and read the smuggled response,
+	// strict ones fall back to Content-Length.
+	"te-obfuscated": func(buf *bufio.ReadWriter, token, body string) {
+		chunked := fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(body), body)
+		fmt.Fprintf(buf, "Content-Length: %d\r\n", len(chunked))
+		fmt.Fprintf(buf, "Transfer-Encoding: \tchunked\r\n\r\n")
+		fmt.Fprint(buf, chunked)
+		fmt.Fprint(buf, smuggledResponse(token))
+	},
+	// A complete response followed by another one nobody asked for. Clients
+	// that reuse the connection hand the extra response to their next
+	// request.
+	"extra": func(buf *bufio.ReadWriter, token, body string) {
+		fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(body))
+		fmt.Fprint(buf, body)
+		fmt.Fprint(buf, smuggledResponse(token))
+	},
+	// The response is sent before the request body has been read, and the
+	// body is left unread. Proxies may then parse the rest of the request
+	// body on the connection as a new request or response.
+	"early": func(buf *bufio.ReadWriter, token, body string) {
+		fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(body))
+		fmt.Fprint(buf, body)
+	},
+}
+
+// smuggledResponse is the response left on the connection after the framed
+// body. Its token shows up wherever the client or a proxy mistook it for the
+// answer to a request.
+func smuggledResponse(token string) string {
+	body := "desync-token=" + token
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nX-Desync-Token: %s\r\nContent-Length: %d\r\n\r\n%s",
+		token, len(body), body)
+}
+
+// DesyncHandler answers <prefix>/<variant>[/<path>] with a response with
+// deliberately malformed framing, written straight to the hijacked
+// connection, to test the client and any proxies in between for response
+// smuggling and desync issues. See desyncPayloads for the variants.
+//
+// The connection is then kept open for research.desync.linger, and whatever
+// arrives on it is logged: a reused connection's next request, or the rest of
+// a request body left unread. Closing it straight away would lose those, and
+// with unread data make the kernel reset the connection, which clients
+// report as an error instead of parsing the response.
+func (s *SSRFSheriffRouter) DesyncHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, s.desync.Prefix+"/")
+	variant, _, _ := strings.Cut(rest, "/")
+	payload, ok := desyncPayloads[variant]
+	if !ok {
+		variants := make([]string, 0, len(desyncPayloads))
+		for name := range desyncPayloads {
+			variants = append(variants, name)
+		}
+		sort.Strings(variants)
+		http.Error(w, "usage: "+s.desync.Prefix+"/<"+strings.Join(variants, "|")+">[/<path>]", http.StatusBadRequest)
+		return
+	}
+
+	defer s.callbacks.record()
+	profile := s.acceptCallback(r)
+
+	// The token ends up verbatim in the raw response, so refuse anything that
+	// could break the framing in ways we didn't intend.
+	if strings.ContainsAny(profile.Token, "\r\n") {
+		s.logger.Warn("Refusing to serve desync payload for token containing CR/LF")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "desync payloads need an HTTP/1.x connection", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Warn("Failed to hijack connection for desync payload", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain\r\n")
+	for _, name := range s.tokenHeaders {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, profile.Token)
+	}
+	payload(buf, profile.Token, "token="+profile.Token)
+
+	if err := buf.Flush(); err != nil {
+		s.logger.Warn("Failed to write desync payload", zap.Error(err))
+		return
+	}
+
+	s.logger.Info("Served desync payload",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Variant", variant),
+	)
+
+	conn.SetReadDeadline(time.Now().Add(s.desync.Linger))
+	chunk := make([]byte, 4096)
+	for read := 0; read < desyncMaxFollowUp; {
+		n, err := buf.Read(chunk[:min(len(chunk), desyncMaxFollowUp-read)])
+		if n > 0 {
+			read += n
+			data, encoding := textOrBase64(chunk[:n])
+			s.logger.Info("Received data after desync payload",
+				zap.String("IP", r.RemoteAddr),
+				zap.String("Variant", variant),
+				zap.Int("Bytes", n),
+				zap.String("Data", data),
+				zap.String("Data Encoding", encoding),
+			)
+		}
+		if err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/dnsserver"
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// defaultDNSTTL is the TTL of DNS answers unless dns.ttl is configured. It is
+// kept short so repeated lookups reach the sheriff instead of a cache.
+const defaultDNSTTL = 60
+
+// defaultRebindWindow is how long a rebinding name's query count is kept
+// unless dns.rebind.window is configured.
+const defaultRebindWindow = 10 * time.Minute
+
+// defaultRebindMaxNames bounds how many rebinding names are tracked unless
+// dns.rebind.max_names is configured.
+const defaultRebindMaxNames = 100000
+
+// NewDNSServer builds the DNS server configured in the dns section, which
+// answers TXT lookups with the secret token, and A and AAAA lookups under
+// dns.rebind.label with rebinding addresses. Data exfiltrated in names under
+// dns.exfil.label is decoded and recorded as a hit, and so are lookups of
+// names carrying a minted token or target ID. Sources outside
+// security.allowed_source_cidrs get the decoy token. It returns nil if
+// dns.address isn't set.
+func NewDNSServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*dnsserver.Server, error) {
+	raw := struct {
+		Address string `yaml:"address"`
+		Zone    string `yaml:"zone"`
+		A       string `yaml:"a"`
+		AAAA    string `yaml:"aaaa"`
+		TTL     uint32 `yaml:"ttl"`
+		Rebind  struct {
+			Label         string        `yaml:"label"`
+			Internal      string        `yaml:"internal"`
+			InternalFirst bool          `yaml:"internal_first"`
+			After         int           `yaml:"after"`
+			Window        time.Duration `yaml:"window"`
+			MaxNames      int           `yaml:"max_names"`
+		} `yaml:"rebind"`
+		Exfil struct {
+			Label string `yaml:"label"`
+		} `yaml:"exfil"`
+	}{TTL: defaultDNSTTL}
+	raw.Rebind.After = 1
+	raw.Rebind.Window = defaultRebindWindow
+	raw.Rebind.MaxNames = defaultRebindMaxNames
+	if err := cfg.Get("dns").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load dns: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+	if raw.Zone == "" {
+		return nil, fmt.Errorf("dns.zone is required when dns.address is set")
+	}
+
+	dnsCfg := dnsserver.Config{
+		Addr: raw.Address,
+		Zone: raw.Zone,
+		TXT:  s.sourceToken("DNS"),
+		TTL:  raw.TTL,
+
+		OnLookup: s.recordLookup,
+	}
+	if raw.A != "" {
+		if dnsCfg.A = net.ParseIP(raw.A).To4(); dnsCfg.A == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q in dns.a", raw.A)
+		}
+	}
+	if raw.AAAA != "" {
+		if dnsCfg.AAAA = net.ParseIP(raw.AAAA); dnsCfg.AAAA == nil || dnsCfg.AAAA.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address %q in dns.aaaa", raw.AAAA)
+		}
+	}
+	if rebind := raw.Rebind; rebind.Label != "" {
+		if !validDNSLabel(rebind.Label) {
+			return nil, fmt.Errorf("invalid dns.rebind.label %q", rebind.Label)
+		}
+		internal := net.ParseIP(rebind.Internal)
+		if internal == nil {
+			return nil, fmt.Errorf("invalid address %q in dns.rebind.internal", rebind.Internal)
+		}
+		if rebind.After < 1 || rebind.Window <= 0 || rebind.MaxNames < 1 {
+			return nil, fmt.Errorf("dns.rebind.after, dns.rebind.window and dns.rebind.max_names must be positive")
+		}
+		dnsCfg.Rebind = &dnsserver.RebindConfig{
+			Label:         rebind.Label,
+			Internal:      internal,
+			InternalFirst: rebind.InternalFirst,
+			After:         rebind.After,
+			Window:        rebind.Window,
+			MaxNames:      rebind.MaxNames,
+		}
+	}
+	if label := raw.Exfil.Label; label != "" {
+		if !validDNSLabel(label) {
+			return nil, fmt.Errorf("invalid dns.exfil.label %q", label)
+		}
+		dnsCfg.ExfilLabel = label
+		dnsCfg.OnExfil = s.recordExfil
+	}
+	return dnsserver.New(dnsCfg, logger), nil
+}
+
+// validDNSLabel reports whether label can be put in front of the zone: one or
+// more non-empty labels separated by dots.
+func validDNSLabel(label string) bool {
+	return !strings.Contains(label, "..") && !strings.HasPrefix(label, ".") && !strings.HasSuffix(label, ".")
+}
+
+// recordExfil records data exfiltrated through a DNS lookup as a hit with
+// method DNS, so it shows up in the hits API and live streams, and notifies
+// it. Names that couldn't be decoded are recorded without data.
+func (s *SSRFSheriffRouter) recordExfil(exfil dnsserver.Exfil) {
+	hit := storage.Hit{
+		Time:   time.Now().UTC(),
+		IP:     exfil.IP,
+		Method: "DNS",
+		Host:   strings.TrimSuffix(exfil.Name, "."),
+	}
+	if exfil.Err == nil {
+		hit.Data, hit.DataEncoding = textOrBase64(exfil.Data)
+	}
+	s.storeHit(hit)
+	s.notifyHit(hit)
+}
+
+// recordLookup records a DNS lookup of a name carrying a minted token or a
+// target ID as one of its labels left of the zone, e.g. <id>.<zone>, as a hit
+// with method DNS, and notifies it. The lookup can then be correlated with the
+// HTTP callbacks carrying the same token or target, see InteractionsHandler.
+func (s *SSRFSheriffRouter) recordLookup(lookup dnsserver.Lookup) {
+	token, _ := s.tokens.matchLabels(zoneLabels(lookup.Name, s.targets.zone))
+	target, _ := s.targets.matchName(lookup.Name)
+	if token == "" && target.ID == "" {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("IP", lookup.IP),
+		zap.String("Query Name", lookup.Name),
+		zap.String("Query Type", lookup.Type),
+	}
+	if token != "" {
+		fields = append(fields, zap.String("Token", token))
+	}
+	if target.ID != "" {
+		fields = append(fields, zap.String("Target", target.ID), zap.String("Target Label", target.Label))
+	}
+	s.logger.Info("DNS lookup matched token or target", fields...)
+
+	hit := storage.Hit{
+		Time:   time.Now().UTC(),
+		IP:     lookup.IP,
+		Method: "DNS",
+		Host:   strings.TrimSuffix(lookup.Name, "."),
+		Token:  token,
+		Target: target.ID,
+	}
+	s.storeHit(hit)
+	s.notifyHit(hit)
+}
+
+// zoneLabels returns the labels of name, a hostname or DNS name, left of
+// zone: ["a", "b"] for a.b.<zone>. It returns nil if name isn't under zone.
+func zoneLabels(name, zone string) []string {
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if zone == "" {
+		return nil
+	}
+	sub, ok := strings.CutSuffix(name, "."+zone)
+	if !ok || sub == "" {
+		return nil
+	}
+	return strings.Split(sub, ".")
+}
+
+// StartDNSServer starts the DNS server, if one is configured.
+func StartDNSServer(srv *dnsserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
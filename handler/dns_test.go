@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/dnsserver"
+	"github.com/teknogeek/ssrf-sheriff/notifier"
+	"go.uber.org/zap"
+)
+
+func TestRecordExfilNotifiesWebhooks(t *testing.T) {
+	events := make(chan notifier.Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notifier.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("bad event: %v", err)
+		}
+		events <- event
+	}))
+	defer receiver.Close()
+
+	webhooks, err := notifier.NewWebhooks([]string{receiver.URL}, notifier.Options{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhooks.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer webhooks.Stop(context.Background())
+
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), feed: newHitFeed(), webhooks: webhooks}
+	s.recordExfil(dnsserver.Exfil{
+		IP:   "192.0.2.1",
+		Name: "nbswy3dp.exfil.sheriff.example.com.",
+		Data: []byte("hello"),
+	})
+
+	select {
+	case event := <-events:
+		if event.Method != "DNS" || event.IP != "192.0.2.1" || event.Data != "hello" || event.DataEncoding != "utf-8" {
+			t.Errorf("event = %+v, want the exfiltrated data from 192.0.2.1", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook sent for exfiltrated data")
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// echoHeadersParam is the query parameter that turns on header echoing for a
+// request.
+const echoHeadersParam = "echoheaders"
+
+// echoHeaderPrefix is prepended to the name of each echoed request header.
+const echoHeaderPrefix = "X-Echo-"
+
+// loadEchoHeaders reads http.echo_headers, the allowlist of request headers
+// that may be echoed back, and returns their canonical names.
+func loadEchoHeaders(cfg config.Provider) ([]string, error) {
+	var names []string
+	if err := cfg.Get("http.echo_headers").Populate(&names); err != nil {
+		return nil, fmt.Errorf("failed to load http.echo_headers: %v", err)
+	}
+	for i, name := range names {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q in http.echo_headers", name)
+		}
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	return names, nil
+}
+
+// echoHeaders copies the allowlisted request headers into the response as
+// X-Echo-<Name> when the request asks for it with ?echoheaders=true, which
+// shows what the SSRF client and any proxies in between added or changed.
+func (s *SSRFSheriffRouter) echoHeaders(w http.ResponseWriter, r *http.Request) {
+	if len(s.echoHeaderNames) == 0 {
+		return
+	}
+	if echo, _ := strconv.ParseBool(r.URL.Query().Get(echoHeadersParam)); !echo {
+		return
+	}
+
+	for _, name := range s.echoHeaderNames {
+		values := r.Header.Values(name)
+		if name == "Host" && r.Host != "" {
+			// net/http moves the Host header out of r.Header.
+			values = []string{r.Host}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		w.Header().Set(echoHeaderPrefix+name, sanitizeHeaderValue(strings.Join(values, ", ")))
+	}
+}
+
+// sanitizeHeaderValue replaces control characters, including CR and LF, so a
+// reflected value can't inject headers or split the response.
+func sanitizeHeaderValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name, i.e.
+// a non-empty RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultReverseDNSTimeout bounds reverse DNS lookups unless
+	// enrichment.reverse_dns_timeout is configured.
+	defaultReverseDNSTimeout = time.Second
+
+	// maxReverseDNSCache is the number of reverse DNS answers kept before
+	// the cache is emptied.
+	maxReverseDNSCache = 4096
+)
+
+// Enricher adds reverse DNS, ASN and GeoIP information about a callback's
+// source address to its log entry, so callbacks can be grouped by the network
+// or cloud provider they come from.
+type Enricher struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+
+	reverseDNS bool
+	timeout    time.Duration
+	resolver   *net.Resolver
+
+	mu    sync.Mutex
+	names map[string]string
+}
+
+// NewEnricher opens the MaxMind databases configured in the enrichment
+// section. It returns nil if no enrichment is configured.
+func NewEnricher(cfg config.Provider, lc fx.Lifecycle) (*Enricher, error) {
+	raw := struct {
+		ReverseDNS        bool          `yaml:"reverse_dns"`
+		ReverseDNSTimeout time.Duration `yaml:"reverse_dns_timeout"`
+		CityDB            string        `yaml:"geoip_db"`
+		ASNDB             string        `yaml:"asn_db"`
+	}{
+		ReverseDNSTimeout: defaultReverseDNSTimeout,
+	}
+	if err := cfg.Get("enrichment").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load enrichment: %v", err)
+	}
+	if !raw.ReverseDNS && raw.CityDB == "" && raw.ASNDB == "" {
+		return nil, nil
+	}
+
+	e := &Enricher{
+		reverseDNS: raw.ReverseDNS,
+		timeout:    raw.ReverseDNSTimeout,
+		resolver:   net.DefaultResolver,
+		names:      make(map[string]string),
+	}
+	var err error
+	if raw.CityDB != "" {
+		if e.city, err = geoip2.Open(raw.CityDB); err != nil {
+			return nil, fmt.Errorf("failed to open enrichment.geoip_db: %v", err)
+		}
+	}
+	if raw.ASNDB != "" {
+		if e.asn, err = geoip2.Open(raw.ASNDB); err != nil {
+			e.Close()
+			return nil, fmt.Errorf("failed to open enrichment.asn_db: %v", err)
+		}
+	}
+
+	lc.Append(fx.Hook{OnStop: func(context.Context) error { return e.Close() }})
+	return e, nil
+}
+
+// Fields returns the log fields describing ip. Lookups that fail or find
+// nothing are left out.
+func (e *Enricher) Fields(ip string) []zap.Field {
+	if e == nil {
+		return nil
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	var fields []zap.Field
+	if e.reverseDNS {
+		if name := e.lookupAddr(ip); name != "" {
+			fields = append(fields, zap.String("Reverse DNS", name))
+		}
+	}
+	if e.asn != nil {
+		if rec, err := e.asn.ASN(parsed); err == nil && rec.AutonomousSystemNumber != 0 {
+			fields = append(fields,
+				zap.Uint("ASN", rec.AutonomousSystemNumber),
+				zap.String("AS Organization", rec.AutonomousSystemOrganization),
+			)
+		}
+	}
+	if e.city != nil {
+		if rec, err := e.city.City(parsed); err == nil && rec.Country.IsoCode != "" {
+			fields = append(fields, zap.String("Country", rec.Country.IsoCode))
+			if city := rec.City.Names["en"]; city != "" {
+				fields = append(fields, zap.String("City", city))
+			}
+		}
+	}
+	return fields
+}
+
+// ASNLabel returns the autonomous system of ip as a metric label, such as
+// "AS15169", or "unknown" if it isn't in the database. It returns "" if no
+// ASN database is configured.
+func (e *Enricher) ASNLabel(ip string) string {
+	if e == nil || e.asn == nil {
+		return ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "unknown"
+	}
+	rec, err := e.asn.ASN(parsed)
+	if err != nil || rec.AutonomousSystemNumber == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("AS%d", rec.AutonomousSystemNumber)
+}
+
+// lookupAddr returns the first name ip resolves to, or "" if it has none.
+// Answers, including the lack of one, are cached.
+func (e *Enricher) lookupAddr(ip string) string {
+	e.mu.Lock()
+	name, ok := e.names[ip]
+	e.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	if names, err := e.resolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	e.mu.Lock()
+	if len(e.names) >= maxReverseDNSCache {
+		e.names = make(map[string]string)
+	}
+	e.names[ip] = name
+	e.mu.Unlock()
+	return name
+}
+
+// Close closes the MaxMind databases.
+func (e *Enricher) Close() error {
+	var err error
+	if e.city != nil {
+		err = e.city.Close()
+	}
+	if e.asn != nil {
+		if asnErr := e.asn.Close(); err == nil {
+			err = asnErr
+		}
+	}
+	return err
+}
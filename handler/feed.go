@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// feedBuffer is how many hits a slow stream subscriber can fall behind
+	// before hits are dropped for it.
+	feedBuffer = 64
+
+	// feedKeepAlive is how often an idle hit stream sends a comment, so that
+	// proxies in front of the admin listener don't close it.
+	feedKeepAlive = 15 * time.Second
+)
+
+// hitFeed fans callbacks out to the clients streaming them live.
+type hitFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan storage.Hit]struct{}
+
+	// done is closed when the admin listener shuts down, ending the
+	// streams so they don't hold up its graceful shutdown.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHitFeed() *hitFeed {
+	return &hitFeed{
+		subscribers: make(map[chan storage.Hit]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// close ends every stream.
+func (f *hitFeed) close() {
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+// subscribe returns a channel receiving every hit published from now on.
+// Hits are dropped rather than blocking callbacks if the channel is full.
+func (f *hitFeed) subscribe() chan storage.Hit {
+	ch := make(chan storage.Hit, feedBuffer)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *hitFeed) unsubscribe(ch chan storage.Hit) {
+	f.mu.Lock()
+	delete(f.subscribers, ch)
+	f.mu.Unlock()
+}
+
+func (f *hitFeed) publish(hit storage.Hit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- hit:
+		default:
+		}
+	}
+}
+
+// HitStreamHandler streams callbacks as they arrive as server-sent events,
+// one "hit" event carrying the hit as JSON per callback. It can be filtered
+// with the token and ip query parameters. Streamed hits have no ID, since they
+// are sent before they are stored.
+func (s *SSRFSheriffRouter) HitStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	token, ip := r.URL.Query().Get("token"), r.URL.Query().Get("ip")
+	ch := s.feed.subscribe()
+	defer s.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.logger.Info("Hit stream opened", zap.String("IP", r.RemoteAddr))
+	defer s.logger.Info("Hit stream closed", zap.String("IP", r.RemoteAddr))
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.feed.done:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case hit := <-ch:
+			if (token != "" && hit.Token != token) || (ip != "" && hit.IP != ip) {
+				continue
+			}
+			data, err := json.Marshal(hit)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: hit\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
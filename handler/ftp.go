@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/teknogeek/ssrf-sheriff/ftpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewFTPServer builds the FTP server configured in the ftp section, which
+// serves the secret token as every file, or the decoy token to sources
+// outside security.allowed_source_cidrs. It returns nil if ftp.address isn't
+// set.
+func NewFTPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*ftpserver.Server, error) {
+	var raw struct {
+		Address  string `yaml:"address"`
+		PublicIP string `yaml:"public_ip"`
+	}
+	if err := cfg.Get("ftp").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load ftp: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	token := s.sourceToken("FTP")
+	ftpCfg := ftpserver.Config{
+		Addr: raw.Address,
+		Content: func(remote net.Addr) []byte {
+			return []byte("token=" + token(remote))
+		},
+		Proxy: proxy,
+	}
+	if raw.PublicIP != "" {
+		if ftpCfg.PublicIP = net.ParseIP(raw.PublicIP).To4(); ftpCfg.PublicIP == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q in ftp.public_ip", raw.PublicIP)
+		}
+	}
+	return ftpserver.New(ftpCfg, logger), nil
+}
+
+// StartFTPServer starts the FTP server, if one is configured.
+func StartFTPServer(srv *ftpserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// tokenGeneration is one of the secret tokens the sheriff has served, along
+// with the window it is served in. ssrf_token is generation 1, served in the
+// window set by tokens.validity; every rotation adds the next generation.
+type tokenGeneration struct {
+	Generation int
+	Token      string
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// MarshalJSON leaves out the ends of the window that aren't set.
+func (g tokenGeneration) MarshalJSON() ([]byte, error) {
+	view := struct {
+		Generation int        `json:"generation"`
+		Token      string     `json:"token"`
+		NotBefore  *time.Time `json:"not_before,omitempty"`
+		NotAfter   *time.Time `json:"not_after,omitempty"`
+	}{Generation: g.Generation, Token: g.Token}
+	if !g.NotBefore.IsZero() {
+		t := g.NotBefore.UTC()
+		view.NotBefore = &t
+	}
+	if !g.NotAfter.IsZero() {
+		t := g.NotAfter.UTC()
+		view.NotAfter = &t
+	}
+	return json.Marshal(view)
+}
+
+// activeAt reports whether the generation is served at t.
+func (g tokenGeneration) activeAt(t time.Time) bool {
+	return !t.Before(g.NotBefore) && (g.NotAfter.IsZero() || t.Before(g.NotAfter))
+}
+
+// tokenGenerations holds every generation of the secret token. The current
+// token is the newest generation whose window contains the current time, or
+// the decoy token if every generation has expired or is yet to start.
+type tokenGenerations struct {
+	mu   sync.RWMutex
+	gens []tokenGeneration
+}
+
+// loadTokenGenerations returns ssrf_token as generation 1, served in the
+// tokens.validity window, followed by the generations configured in
+// tokens.rotation, in order.
+func loadTokenGenerations(cfg config.Provider) (*tokenGenerations, error) {
+	var validity struct {
+		NotBefore string `yaml:"not_before"`
+		NotAfter  string `yaml:"not_after"`
+	}
+	if err := cfg.Get("tokens.validity").Populate(&validity); err != nil {
+		return nil, fmt.Errorf("failed to load tokens.validity: %v", err)
+	}
+	notBefore, err := parseWindowTime(validity.NotBefore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokens.validity.not_before: %v", err)
+	}
+	notAfter, err := parseWindowTime(validity.NotAfter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokens.validity.not_after: %v", err)
+	}
+	if !notAfter.IsZero() && !notAfter.After(notBefore) {
+		return nil, fmt.Errorf("tokens.validity ends before it starts")
+	}
+
+	var raw []struct {
+		Token     string `yaml:"token"`
+		NotBefore string `yaml:"not_before"`
+		NotAfter  string `yaml:"not_after"`
+	}
+	if err := cfg.Get("tokens.rotation").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load tokens.rotation: %v", err)
+	}
+
+	tg := &tokenGenerations{}
+	tg.add(cfg.Get("ssrf_token").String(), notBefore, notAfter)
+	for i, gen := range raw {
+		if gen.Token == "" {
+			return nil, fmt.Errorf("tokens.rotation[%d] has no token", i)
+		}
+		notBefore, err := parseWindowTime(gen.NotBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tokens.rotation[%d].not_before: %v", i, err)
+		}
+		notAfter, err := parseWindowTime(gen.NotAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tokens.rotation[%d].not_after: %v", i, err)
+		}
+		if !notAfter.IsZero() && !notAfter.After(notBefore) {
+			return nil, fmt.Errorf("tokens.rotation[%d] ends before it starts", i)
+		}
+		tg.add(gen.Token, notBefore, notAfter)
+	}
+	return tg, nil
+}
+
+// parseWindowTime parses an RFC 3339 time, returning the zero time for an
+// empty string.
+func parseWindowTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// add registers token as the next generation and returns it.
+func (tg *tokenGenerations) add(token string, notBefore, notAfter time.Time) tokenGeneration {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	gen := tokenGeneration{
+		Generation: len(tg.gens) + 1,
+		Token:      token,
+		NotBefore:  notBefore,
+		NotAfter:   notAfter,
+	}
+	tg.gens = append(tg.gens, gen)
+	return gen
+}
+
+// current returns the newest generation active now. If there is none, it
+// returns generation 0 holding the decoy token, so an expired token is never
+// served.
+func (tg *tokenGenerations) current() tokenGeneration {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+
+	now := time.Now()
+	for i := len(tg.gens) - 1; i >= 0; i-- {
+		if tg.gens[i].activeAt(now) {
+			return tg.gens[i]
+		}
+	}
+	return tokenGeneration{Token: decoyProfile.Token}
+}
+
+// list returns every generation, newest first.
+func (tg *tokenGenerations) list() []tokenGeneration {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+
+	gens := append([]tokenGeneration(nil), tg.gens...)
+	sort.Slice(gens, func(i, j int) bool { return gens[i].Generation > gens[j].Generation })
+	return gens
+}
+
+// lookup returns the generation of token, if it is one.
+func (tg *tokenGenerations) lookup(token string) (tokenGeneration, bool) {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+
+	for i := len(tg.gens) - 1; i >= 0; i-- {
+		if tg.gens[i].Token == token {
+			return tg.gens[i], true
+		}
+	}
+	return tokenGeneration{}, false
+}
+
+// carriedBy returns the newest generation whose token appears in the
+// request's path or query, such as a callback URL built around an older
+// token.
+func (tg *tokenGenerations) carriedBy(r *http.Request) (tokenGeneration, bool) {
+	target := r.URL.Path + "?" + r.URL.RawQuery
+	if unescaped, err := url.QueryUnescape(target); err == nil {
+		target = unescaped
+	}
+
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+
+	for i := len(tg.gens) - 1; i >= 0; i-- {
+		if tg.gens[i].Token != "" && strings.Contains(target, tg.gens[i].Token) {
+			return tg.gens[i], true
+		}
+	}
+	return tokenGeneration{}, false
+}
+
+// generationFields describes which generation answered a callback with token
+// and, if the callback URL carries a token of its own, whether that one is
+// still being served. Tokens that aren't generations, such as minted or
+// per-request ones, get no fields.
+func (s *SSRFSheriffRouter) generationFields(r *http.Request, token string) []zap.Field {
+	var fields []zap.Field
+	if gen, ok := s.generations.lookup(token); ok {
+		fields = append(fields, zap.Int("Token Generation", gen.Generation))
+	}
+	if gen, ok := s.generations.carriedBy(r); ok {
+		fields = append(fields,
+			zap.Int("Requested Token Generation", gen.Generation),
+			zap.Bool("Requested Token Stale", gen.Generation != s.generations.current().Generation),
+		)
+	}
+	return fields
+}
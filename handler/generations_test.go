@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCurrentGenerationExpires(t *testing.T) {
+	now := time.Now()
+	tg := &tokenGenerations{}
+	tg.add("first", time.Time{}, now.Add(-time.Hour))
+	tg.add("second", now.Add(-time.Hour), now.Add(time.Hour))
+	tg.add("third", now.Add(time.Hour), time.Time{})
+
+	if got := tg.current(); got.Generation != 2 || got.Token != "second" {
+		t.Errorf("current = %+v, want generation 2", got)
+	}
+
+	expired := &tokenGenerations{}
+	expired.add("first", time.Time{}, now.Add(-time.Minute))
+	if got := expired.current(); got.Generation != 0 || got.Token != decoyProfile.Token {
+		t.Errorf("current after ssrf_token expired = %+v, want the decoy token", got)
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/grpcserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewGRPCServer builds the gRPC server configured in the grpc section, which
+// offers server reflection and a method returning the secret token, and logs
+// the metadata of every call. Sources outside security.allowed_source_cidrs
+// get the decoy token. It returns nil if grpc.address isn't set.
+func NewGRPCServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*grpcserver.Server, error) {
+	var raw struct {
+		Address string `yaml:"address"`
+	}
+	if err := cfg.Get("grpc").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load grpc: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpcserver.New(grpcserver.Config{
+		Addr:  raw.Address,
+		Token: s.sourceToken("gRPC"),
+		Proxy: proxy,
+	}, logger)
+}
+
+// StartGRPCServer starts the gRPC server, if one is configured.
+func StartGRPCServer(srv *grpcserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
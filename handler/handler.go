@@ -1,18 +1,34 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"mime"
+	"net"
 	"net/http"
-	"path"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/teknogeek/ssrf-sheriff/dnsserver"
+	"github.com/teknogeek/ssrf-sheriff/ftpserver"
 	"github.com/teknogeek/ssrf-sheriff/generators"
+	"github.com/teknogeek/ssrf-sheriff/grpcserver"
 	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"github.com/teknogeek/ssrf-sheriff/ldapserver"
+	"github.com/teknogeek/ssrf-sheriff/notifier"
+	"github.com/teknogeek/ssrf-sheriff/redisserver"
+	"github.com/teknogeek/ssrf-sheriff/smtpserver"
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"github.com/teknogeek/ssrf-sheriff/tcpserver"
+	"github.com/teknogeek/ssrf-sheriff/templates"
+	"github.com/teknogeek/ssrf-sheriff/tftpserver"
+	"github.com/teknogeek/ssrf-sheriff/udpserver"
 	"go.uber.org/config"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
@@ -26,104 +42,917 @@ type SerializableResponse struct {
 
 // SSRFSheriffRouter is a wrapper around mux.Router to handle HTTP requests to the sheriff, with logging
 type SSRFSheriffRouter struct {
-	logger    *zap.Logger
-	ssrfToken string
+	logger     *zap.Logger
+	csvColumns []string
+	hostRules  []hostRule
+
+	// generations holds every generation of the secret token, which can be
+	// rotated at runtime.
+	generations *tokenGenerations
+	// modes holds the response modes, which can be changed at runtime.
+	modes atomic.Pointer[responseModes]
+
+	allowedSources    []*net.IPNet
+	trustedProxies    []*net.IPNet
+	metaRedirect      metaRedirectConfig
+	metadataEmulation metadataEmulationConfig
+	redirect          redirectConfig
+	delay             delayConfig
+	slow              slowConfig
+	headerStress      headerStressConfig
+	websocket         websocketConfig
+	pathRules         []*pathRule
+
+	// authChallengePrefix is where the auth challenge routes live, or "" if
+	// they are disabled.
+	authChallengePrefix string
+	// statusPrefix is where the status routes live, or "" if they are
+	// disabled.
+	statusPrefix string
+	// desync.Prefix is where the desync routes live, or "" if they are
+	// disabled.
+	desync desyncConfig
+
+	tokenHeaders    []string
+	linkFormats     []string
+	echoHeaderNames []string
+	compression     []string
+	forcedEncodings []forcedEncoding
+
+	// bodyCaptureBytes is how much of each request body is logged, or 0 if
+	// none is.
+	bodyCaptureBytes int
+	canary           canaryConfig
+	archives         archiveOptions
+
+	internalPaths internalPaths
+
+	// media generates media carrying the token on demand, and is nil when
+	// generators.skip is set.
+	media *generators.Cache
+
+	adminToken  string
+	rawRequests *rawRequestStore
+	tokens      *tokenRegistry
+	targets     *targetRegistry
+	sessions    *sessionTracker
+
+	// interactionWindow is how far apart hits sharing a token or target may
+	// be to belong to one interaction.
+	interactionWindow time.Duration
+	callbacks         *callbackCounter
+	feed              *hitFeed
+	userAgents        *userAgentStats
+
+	// requestTokens is nil unless per-request tokens are enabled.
+	requestTokens *requestTokens
+	signingKeys   *signingKeys
+
+	responseLimits responseLimits
+	transforms     *TransformPipeline
+
+	// webhooks is nil unless notifications.webhooks are configured.
+	webhooks *notifier.Webhooks
+	// hits is nil unless storage is configured.
+	hits storage.Store
+	// enricher is nil unless enrichment is configured.
+	enricher *Enricher
+	// hitLog is nil unless hit_log.path is configured.
+	hitLog *HitLog
+
+	metrics *Metrics
 }
 
 // NewHTTPServer provides a new HTTP server listener
 func NewHTTPServer(
 	mux *mux.Router,
 	cfg config.Provider,
-) *http.Server {
+) (*http.Server, error) {
+	addr, err := httpserver.NormalizeAddr(cfg.Get("http.address").String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.address: %v", err)
+	}
+	return newServer(addr, mux, cfg)
+}
 
-	return &http.Server{
-		Addr:    cfg.Get("http.address").String(),
+// newServer builds an http.Server for the router listening on addr.
+func newServer(addr string, mux *mux.Router, cfg config.Provider) (*http.Server, error) {
+	keepAlives := true
+	if err := cfg.Get("http.keep_alives").Populate(&keepAlives); err != nil {
+		return nil, fmt.Errorf("failed to load http.keep_alives: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:    addr,
 		Handler: mux,
 	}
+	// With keep-alives disabled every response carries Connection: close
+	// and the connection is closed after it.
+	server.SetKeepAlivesEnabled(keepAlives)
+	return server, nil
 }
 
 // NewSSRFSheriffRouter returns a new SSRFSheriffRouter which is used to route and handle all HTTP requests
 func NewSSRFSheriffRouter(
 	logger *zap.Logger,
 	cfg config.Provider,
-) *SSRFSheriffRouter {
-	return &SSRFSheriffRouter{
-		logger:    logger,
-		ssrfToken: cfg.Get("ssrf_token").String(),
+	transforms *TransformPipeline,
+	webhooks *notifier.Webhooks,
+	hits storage.Store,
+	metrics *Metrics,
+	media *generators.Cache,
+	enricher *Enricher,
+	hitLog *HitLog,
+) (*SSRFSheriffRouter, error) {
+	var csvColumns []string
+	if err := cfg.Get("csv.columns").Populate(&csvColumns); err != nil {
+		return nil, fmt.Errorf("failed to load csv.columns: %v", err)
+	}
+
+	var ntlmCapture bool
+	if err := cfg.Get("auth.ntlm_capture").Populate(&ntlmCapture); err != nil {
+		return nil, fmt.Errorf("failed to load auth.ntlm_capture: %v", err)
+	}
+
+	archives, err := loadArchiveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := loadCanary(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyCaptureBytes, err := loadBodyCaptureBytes(cfg)
+	if err != nil {
+		return nil, err
 	}
+
+	trustedProxies, err := loadTrustedProxies(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	authChallengePrefix, err := loadAuthChallengePrefix(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	statusPrefix, err := loadStatusPrefix(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	desync, err := loadDesync(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostRules, err := loadHostRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenHeaders, err := loadTokenHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var randomize bool
+	if err := cfg.Get("http.randomize_responses").Populate(&randomize); err != nil {
+		return nil, fmt.Errorf("failed to load http.randomize_responses: %v", err)
+	}
+
+	var linkFormats []string
+	if err := cfg.Get("http.link_formats").Populate(&linkFormats); err != nil {
+		return nil, fmt.Errorf("failed to load http.link_formats: %v", err)
+	}
+
+	unknownPathStatus, err := loadUnknownPathStatus(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlVariant, err := loadXMLVariant(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	echoHeaderNames, err := loadEchoHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, err := loadCompression(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	forcedEncodings, err := loadForcedEncodings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var splitCanary bool
+	if err := cfg.Get("research.split_canary").Populate(&splitCanary); err != nil {
+		return nil, fmt.Errorf("failed to load research.split_canary: %v", err)
+	}
+
+	var limits responseLimits
+	if err := cfg.Get("responses").Populate(&limits); err != nil {
+		return nil, fmt.Errorf("failed to load responses: %v", err)
+	}
+
+	paths, err := loadInternalPaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedSources, err := loadAllowedSources(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metaRedirect, err := loadMetaRedirect(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataEmulation, err := loadMetadataEmulation(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := loadRedirect(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	delay, err := loadDelay(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	slow, err := loadSlow(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	headerStress, err := loadHeaderStress(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	websocket, err := loadWebSocket(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pathRules, err := loadPathRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	generations, err := loadTokenGenerations(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenTTL, err := loadTokenTTL(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	requestTokens, err := loadRequestTokens(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := loadTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	interactionWindow, err := loadInteractionWindow(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys, err := newSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionIdle, err := loadSessionIdleWindow(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawCapture bool
+	if err := cfg.Get("raw_capture.enabled").Populate(&rawCapture); err != nil {
+		return nil, fmt.Errorf("failed to load raw_capture.enabled: %v", err)
+	}
+	if !rawCapture {
+		// The raw request export only exists with raw capture on;
+		// otherwise its path is an ordinary callback.
+		paths.Raw = ""
+	}
+
+	s := &SSRFSheriffRouter{
+		logger:     logger,
+		csvColumns: csvColumns,
+		hostRules:  hostRules,
+
+		generations: generations,
+
+		allowedSources:    allowedSources,
+		trustedProxies:    trustedProxies,
+		metaRedirect:      metaRedirect,
+		metadataEmulation: metadataEmulation,
+		redirect:          redirect,
+		delay:             delay,
+		slow:              slow,
+		headerStress:      headerStress,
+		websocket:         websocket,
+		pathRules:         pathRules,
+
+		authChallengePrefix: authChallengePrefix,
+		statusPrefix:        statusPrefix,
+		desync:              desync,
+
+		tokenHeaders:      tokenHeaders,
+		linkFormats:       linkFormats,
+		echoHeaderNames:   echoHeaderNames,
+		compression:       compression,
+		forcedEncodings:   forcedEncodings,
+		adminToken:        cfg.Get("admin.token").String(),
+		userAgents:        newUserAgentStats(),
+		tokens:            newTokenRegistry(tokenTTL),
+		targets:           targets,
+		interactionWindow: interactionWindow,
+		requestTokens:     requestTokens,
+		signingKeys:       signingKeys,
+		sessions:          newSessionTracker(sessionIdle),
+		callbacks:         newCallbackCounter(),
+		feed:              newHitFeed(),
+
+		bodyCaptureBytes: bodyCaptureBytes,
+		canary:           canary,
+		archives:         archives,
+
+		internalPaths:  paths,
+		responseLimits: limits,
+		transforms:     transforms,
+		webhooks:       webhooks,
+		hits:           hits,
+		metrics:        metrics,
+		media:          media,
+		enricher:       enricher,
+		hitLog:         hitLog,
+	}
+	s.modes.Store(&responseModes{
+		Randomize:         randomize,
+		SplitCanary:       splitCanary,
+		NTLMCapture:       ntlmCapture,
+		UnknownPathStatus: unknownPathStatus,
+		XMLVariant:        xmlVariant,
+	})
+	if rawCapture {
+		s.rawRequests = newRawRequestStore()
+	}
+	return s, nil
+}
+
+// NewMediaCache returns the cache generating media with the token rendered
+// in it, or nil if generators.skip is set.
+func NewMediaCache(cfg config.Provider) (*generators.Cache, error) {
+	var opts generators.Options
+	if err := cfg.Get("generators").Populate(&opts); err != nil {
+		return nil, fmt.Errorf("failed to load generators: %v", err)
+	}
+	if opts.Skip {
+		return nil, nil
+	}
+	return generators.NewCache(opts), nil
+}
+
+// StartFilesGenerator generates the media for the configured tokens up front,
+// unless generation is skipped or deferred with generators.lazy. Every host
+// profile gets media rendered with its own token.
+func StartFilesGenerator(cfg config.Provider, logger *zap.Logger, metrics *Metrics, media *generators.Cache) error {
+	var opts generators.Options
+	if err := cfg.Get("generators").Populate(&opts); err != nil {
+		return fmt.Errorf("failed to load generators: %v", err)
+	}
+
+	hostRules, err := loadHostRules(cfg)
+	if err != nil {
+		return err
+	}
+
+	allowedSources, err := loadAllowedSources(cfg)
+	if err != nil {
+		return err
+	}
+
+	profiles := []hostProfile{{Token: cfg.Get("ssrf_token").String()}}
+	for _, rule := range hostRules {
+		profiles = append(profiles, rule.profile)
+	}
+	if len(allowedSources) > 0 {
+		profiles = append(profiles, decoyProfile)
+	}
+
+	if media == nil {
+		for _, profile := range profiles {
+			logTemplateAvailability(logger, profile.Templates)
+		}
+		return nil
+	}
+	if opts.Lazy {
+		logger.Info("Deferring media generation until first request")
+		return nil
+	}
+
+	tokens := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		tokens = append(tokens, profile.Token)
+	}
+
+	start := time.Now()
+	if err := media.Warm(tokens); err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			metrics.generatorFailed(len(joined.Unwrap()))
+		}
+		return fmt.Errorf("failed to generate media: %v", err)
+	}
+	logger.Info("Generated media",
+		zap.Int("Tokens", len(tokens)),
+		zap.Duration("Duration", time.Since(start)),
+	)
+	return nil
+}
+
+// NewHTTPHandle returns the httpserver.Handle used to run the HTTP server
+func NewHTTPHandle(server *http.Server, cfg config.Provider) (*httpserver.Handle, error) {
+	opts, err := httpHandleOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return httpserver.NewHandle(server, opts...), nil
+}
+
+// listenFunc opens the sockets of the HTTP, HTTPS and admin listeners: those
+// handed off by the process being replaced, then those passed by systemd
+// socket activation ("systemd:<name>"), unix sockets ("unix:<path>") and
+// plain TCP addresses.
+func listenFunc() httpserver.HandleOption {
+	return httpserver.ListenFunc(
+		httpserver.InheritedListenFunc(
+			httpserver.SystemdListenFunc(
+				httpserver.UnixListenFunc(httpserver.DefaultListenFunc),
+			),
+		),
+	)
+}
+
+// httpHandleOptions returns the options of the plain HTTP listeners.
+func httpHandleOptions(cfg config.Provider) ([]httpserver.HandleOption, error) {
+	opts := []httpserver.HandleOption{
+		listenFunc(),
+	}
+
+	var rawCapture struct {
+		Enabled  bool `yaml:"enabled"`
+		MaxBytes int  `yaml:"max_bytes"`
+	}
+	if err := cfg.Get("raw_capture").Populate(&rawCapture); err != nil {
+		return nil, fmt.Errorf("failed to load raw_capture: %v", err)
+	}
+	if rawCapture.Enabled {
+		if rawCapture.MaxBytes <= 0 {
+			rawCapture.MaxBytes = defaultRawCaptureBytes
+		}
+		opts = append(opts, httpserver.CaptureRaw(rawCapture.MaxBytes))
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		opts = append(opts, httpserver.ProxyProtocol(*proxy))
+	}
+	return opts, nil
+}
+
+// StartServerParams are the dependencies of StartServer. The servers other
+// than HTTP are only needed to know whether a graceful reload is possible, and
+// are nil when disabled.
+type StartServerParams struct {
+	fx.In
+
+	HTTP       *httpserver.Handle
+	TLS        TLSHandle
+	Extra      ExtraHandles
+	Admin      AdminHandle
+	Lifecycle  fx.Lifecycle
+	Logger     *zap.Logger
+	Shutdowner fx.Shutdowner
+	Reload     ReloadTrigger
+
+	DNS   *dnsserver.Server
+	FTP   *ftpserver.Server
+	TFTP  *tftpserver.Server
+	TCP   *tcpserver.Server
+	UDP   *udpserver.Server
+	SMTP  *smtpserver.Server
+	Redis *redisserver.Server
+	LDAP  *ldapserver.Server
+	GRPC  *grpcserver.Server
 }
 
-// StartFilesGenerator starts the function which is dynamically generating JPG/PNG formats
-// with the secret token rendered in the media
-func StartFilesGenerator(cfg config.Provider) {
-	generators.InitMediaGenerators(cfg.Get("ssrf_token").String())
+// unhandedServers names the enabled servers whose sockets can't be handed off
+// in a graceful reload.
+func (p StartServerParams) unhandedServers() []string {
+	var names []string
+	for _, server := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"DNS", p.DNS != nil},
+		{"FTP", p.FTP != nil},
+		{"TFTP", p.TFTP != nil},
+		{"TCP", p.TCP != nil},
+		{"UDP", p.UDP != nil},
+		{"SMTP", p.SMTP != nil},
+		{"Redis", p.Redis != nil},
+		{"LDAP", p.LDAP != nil},
+		{"gRPC", p.GRPC != nil},
+	} {
+		if server.enabled {
+			names = append(names, server.name)
+		}
+	}
+	return names
 }
 
-// StartServer starts the HTTP server
-func StartServer(server *http.Server, lc fx.Lifecycle) {
-	h := httpserver.NewHandle(server)
-	lc.Append(fx.Hook{
-		OnStart: h.Start,
-		OnStop:  h.Shutdown,
+// StartServer starts the HTTP server, and the HTTPS, additional and admin ones
+// if configured.
+// Sending SIGUSR2 to the process hands the listening sockets off to a new
+// sheriff process and drains this one, so config changes can be picked up
+// without dropping connections. This is refused while any server other than
+// HTTP is enabled, since their sockets can't be handed off.
+func StartServer(p StartServerParams) {
+	handles := []*httpserver.Handle{p.HTTP}
+	if p.TLS.Handle != nil {
+		handles = append(handles, p.TLS.Handle)
+	}
+	handles = append(handles, p.Extra...)
+	if p.Admin.Handle != nil {
+		handles = append(handles, p.Admin.Handle)
+	}
+
+	stopReload := func() {}
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			for i, handle := range handles {
+				if err := handle.Start(ctx); err != nil {
+					for _, started := range handles[:i] {
+						started.Shutdown(ctx)
+					}
+					return err
+				}
+			}
+			stopReload = watchReload(p.Logger, p.Shutdowner, p.Reload, p.unhandedServers(), handles...)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			stopReload()
+			var errs []error
+			for _, handle := range handles {
+				if err := handle.Shutdown(ctx); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		},
 	})
 }
 
+// acceptCallback records a callback and returns the profile to answer it
+// with, carrying the token picked for it: a minted token found in its path, a
+// per-request token, or the token for its Host. Callbacks carrying a target ID
+// in their path or Host are attributed to that target.
+func (s *SSRFSheriffRouter) acceptCallback(r *http.Request) hostProfile {
+	s.userAgents.record(r.UserAgent())
+	s.logSmugglingIndicators(r)
+	s.logReferrers(r)
+
+	profile := s.restrictProfile(r, s.profileFor(r))
+	minted, ok := s.tokens.match(r)
+	if !ok {
+		minted, ok = s.tokens.matchLabels(zoneLabels(r.Host, s.targets.zone))
+	}
+	if ok && profile != decoyProfile {
+		s.logger.Info("Callback matched minted token",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.String("Token", minted),
+		)
+		profile.Token = minted
+	} else if s.requestTokens != nil && profile != decoyProfile {
+		profile.mediaToken = profile.Token
+		profile.Token = s.requestTokens.issue(r)
+	}
+	addLogFields(r, s.generationFields(r, profile.Token)...)
+	target, _ := s.targets.match(r)
+	if target.ID != "" {
+		addLogFields(r, zap.String("Target", target.ID), zap.String("Target Label", target.Label))
+	}
+	s.notify(r, profile.Token, target.ID)
+	s.recordHit(r, profile.Token, target.ID)
+	return profile
+}
+
+// FileHandler returns a handler answering with the named template file for
+// the callback's token, regardless of path rules and response modes. It
+// serves /favicon.ico, which browsers and link preview fetchers request on
+// their own, making it a quiet probe, and /qr.png for QR decoding services.
+func (s *SSRFSheriffRouter) FileHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer s.callbacks.record()
+
+		profile := s.acceptCallback(r)
+		w.Header().Set("Content-Type", contentTypeFor(filepath.Ext(name)))
+		for _, header := range s.tokenHeaders {
+			w.Header().Set(header, profile.Token)
+		}
+		s.writeResponse(w, r, http.StatusOK, []byte(s.templateFile(profile, name)))
+	}
+}
+
+// documentPaths maps the paths automated fetchers often special-case to the
+// text templates answering them.
+var documentPaths = map[string]string{
+	"/.well-known/security.txt": "security.txt",
+	"/security.txt":             "security.txt",
+	"/robots.txt":               "robots.txt",
+	"/sitemap.xml":              "sitemap.xml",
+	"/openapi.json":             "openapi.json",
+	"/swagger.json":             "swagger.json",
+	// OAuth and OpenID Connect discovery, with the issuer and every endpoint
+	// pointing back at the sheriff
+	"/.well-known/openid-configuration":       "openid-configuration.json",
+	"/.well-known/oauth-authorization-server": "oauth-authorization-server.json",
+}
+
+// DocumentHandler answers with the named text template, rendered with the
+// profile's token whatever the path rules say.
+func (s *SSRFSheriffRouter) DocumentHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer s.callbacks.record()
+
+		profile := s.acceptCallback(r)
+		body, ok := s.renderTemplate(r, profile, name)
+		if !ok {
+			s.logger.Error("Missing template",
+				zap.String("Directory", profile.Templates),
+				zap.String("File", name),
+			)
+			body = profile.Token
+		}
+
+		w.Header().Set("Content-Type", contentTypeFor(filepath.Ext(name)))
+		for _, header := range s.tokenHeaders {
+			w.Header().Set(header, profile.Token)
+		}
+		s.writeResponse(w, r, http.StatusOK, []byte(body))
+	}
+}
+
 // PathHandler is the main handler for all inbound requests
 func (s *SSRFSheriffRouter) PathHandler(w http.ResponseWriter, r *http.Request) {
-	fileExtension := filepath.Ext(r.URL.Path)
-	contentType := mime.TypeByExtension(fileExtension)
+	defer s.callbacks.record()
+
+	modes := s.responseModes()
+	if modes.NTLMCapture && s.handleNTLMCapture(w, r) {
+		return
+	}
+
+	profile := s.acceptCallback(r)
+	token := profile.Token
+
+	if !sleep(r, s.delay.Global) {
+		return
+	}
+
+	if modes.SplitCanary && r.URL.Query().Get(splitCanaryParam) != "" && s.serveSplitCanary(w, r, token) {
+		return
+	}
+
+	forceClose(w, r)
+
+	if s.servePathRule(w, r, token) {
+		return
+	}
+
+	fileExtension := archiveExtension(r.URL.Path, filepath.Ext(r.URL.Path))
+	contentType := contentTypeFor(fileExtension)
 	var response string
 
 	switch fileExtension {
 	case ".json":
-		res, _ := json.Marshal(SerializableResponse{SecretToken: s.ssrfToken})
+		if modes.Randomize {
+			response = randomizedJSON(token)
+			break
+		}
+		res, _ := json.Marshal(SerializableResponse{SecretToken: token})
 		response = string(res)
 	case ".xml":
-		res, _ := xml.Marshal(SerializableResponse{SecretToken: s.ssrfToken})
+		if variant, ok := s.xmlVariantResponse(r, token, modes.XMLVariant); ok {
+			response = variant
+			break
+		}
+		if modes.Randomize {
+			response = randomizedXML(token)
+			break
+		}
+		res, _ := xml.Marshal(SerializableResponse{SecretToken: token})
 		response = string(res)
-	case ".html":
-		tmpl := readTemplateFile("html.html")
-		response = fmt.Sprintf(tmpl, s.ssrfToken, s.ssrfToken)
 	case ".csv":
-		tmpl := readTemplateFile("csv.csv")
-		response = fmt.Sprintf(tmpl, s.ssrfToken)
-	case ".txt":
-		response = fmt.Sprintf("token=%s", s.ssrfToken)
+		response = s.renderCSV(r, token)
 	case ".png":
-		response = readTemplateFile("png.png")
+		response = s.templateFile(profile, "png.png")
 	case ".jpg", ".jpeg":
-		response = readTemplateFile("jpeg.jpg")
-	// TODO: dynamically generate these formats with the secret token rendered in the media
-	case ".gif":
-		response = readTemplateFile("gif.gif")
+		response = s.templateFile(profile, "jpeg.jpg")
+	case ".webp":
+		response = s.templateFile(profile, "webp.webp")
 	case ".mp3":
-		response = readTemplateFile("mp3.mp3")
+		response = s.templateFile(profile, "mp3.mp3")
 	case ".mp4":
-		response = readTemplateFile("mp4.mp4")
+		response = s.templateFile(profile, "mp4.mp4")
+	// TODO: dynamically generate these formats with the secret token rendered in the media
+	case ".gif":
+		response = s.templateFile(profile, "gif.gif")
+	case ".docx":
+		response = s.templateFile(profile, "docx.docx")
+	case ".xlsx":
+		response = s.templateFile(profile, "xlsx.xlsx")
+	case ".pdf":
+		response = s.templateFile(profile, "pdf.pdf")
+	case ".svg":
+		response = s.templateFile(profile, "svg.svg")
+	case ".ico":
+		response = s.templateFile(profile, "favicon.ico")
+	case ".zip", ".tar.gz", ".tgz":
+		build := s.archives.buildZIP
+		if fileExtension != ".zip" {
+			build = s.archives.buildTarGz
+		}
+		data, err := build(token)
+		if err != nil {
+			s.logger.Error("Failed to build archive", zap.String("Path", r.URL.Path), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		response = string(data)
 	default:
-		response = s.ssrfToken
+		// HTML, TXT and any format with a template named after its
+		// extension are rendered from text templates.
+		if body, ok := s.renderTemplate(r, profile, customTemplateName(fileExtension)); ok && fileExtension != "" {
+			response = body
+			break
+		}
+		if modes.UnknownPathStatus != 0 && !s.handedOut(r) {
+			s.serveUnknownPath(w, r, profile, modes.UnknownPathStatus)
+			return
+		}
+		response = token
 	}
 
-	if contentType == "" {
-		contentType = "text/plain"
+	w.Header().Set("Content-Type", contentType)
+	s.linkAlternates(w, r, fileExtension)
+	s.echoHeaders(w, r)
+	for _, name := range s.tokenHeaders {
+		w.Header().Set(name, token)
 	}
+	if modes.Randomize {
+		setRandomizedHeaders(w)
+	}
+	if rangeExtensions[fileExtension] {
+		s.writeRangeResponse(w, r, []byte(response))
+		return
+	}
+	s.writeResponse(w, r, http.StatusOK, []byte(response))
+}
 
-	s.logger.Info("New inbound HTTP request",
-		zap.String("IP", r.RemoteAddr),
-		zap.String("Path", r.URL.Path),
-		zap.String("Response Content-Type", contentType),
-		zap.Any("Request Headers", r.Header),
-	)
+// contentTypeOverrides are Content-Types for extensions that the system MIME
+// table often doesn't know about.
+var contentTypeOverrides = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".svg":  "image/svg+xml",
+	".ico":  "image/x-icon",
+	".webp": "image/webp",
+	// .tar.gz and .tgz are served as gzip, like most servers do, so clients
+	// don't undo the compression because of a Content-Encoding.
+	".zip":    "application/zip",
+	".tar.gz": "application/gzip",
+	".tgz":    "application/gzip",
+}
 
-	responseBytes := []byte(response)
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("X-Secret-Token", s.ssrfToken)
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBytes)
+// contentTypeFor returns the Content-Type served for a file extension,
+// defaulting to text/plain.
+func contentTypeFor(fileExtension string) string {
+	if contentType, ok := contentTypeOverrides[fileExtension]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(fileExtension); contentType != "" {
+		return contentType
+	}
+	return "text/plain"
 }
 
-func readTemplateFile(templateFileName string) string {
-	data, err := ioutil.ReadFile(path.Join("templates", path.Clean(templateFileName)))
+// templateFile returns the named template for the profile, generating it
+// with the profile's media token first if a generator produces it. Media is
+// never generated for a per-request token, which would miss the cache on
+// every request.
+func (s *SSRFSheriffRouter) templateFile(profile hostProfile, name string) string {
+	if s.media != nil {
+		data, ok, err := s.media.Get(profile.tokenForMedia(), name)
+		if err != nil {
+			s.metrics.generatorFailed(1)
+			s.logger.Error("Failed to generate media", zap.String("File", name), zap.Error(err))
+		}
+		if ok && err == nil {
+			return string(data)
+		}
+	}
+
+	data := readTemplateFile(profile.Templates, name)
+	if data == "" {
+		s.logger.Error("Missing template",
+			zap.String("Directory", profile.Templates),
+			zap.String("File", name),
+		)
+	}
+	return data
+}
+
+// clientIP returns the IP address of the client, without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// templateFiles lists the template files served by PathHandler.
+var templateFiles = []string{
+	"html.html",
+	"txt.txt",
+	"png.png",
+	"jpeg.jpg",
+	"webp.webp",
+	"gif.gif",
+	"mp3.mp3",
+	"mp4.mp4",
+	"docx.docx",
+	"xlsx.xlsx",
+	"pdf.pdf",
+	"svg.svg",
+	"favicon.ico",
+	"qr.png",
+}
+
+// logTemplateAvailability logs which template files can be served from the
+// given directory, or the embedded templates, when media generation is
+// skipped.
+func logTemplateAvailability(logger *zap.Logger, templatesDir string) {
+	var available, missing []string
+	for _, name := range templateFiles {
+		if readTemplateFile(templatesDir, name) == "" {
+			missing = append(missing, name)
+		} else {
+			available = append(available, name)
+		}
+	}
+
+	logger.Info("Skipping media generation, serving static templates",
+		zap.String("Directory", templatesDir),
+		zap.Strings("Available", available),
+		zap.Strings("Missing", missing),
+	)
+}
+
+// readTemplateFile reads a template from the given directory, falling back to
+// the templates embedded in the binary when the file isn't there. It returns
+// "" if neither has the template.
+func readTemplateFile(templatesDir, templateFileName string) string {
+	data, err := fs.ReadFile(templates.Dir(templatesDir), templateFileName)
 	if err != nil {
 		return ""
 	}
@@ -133,15 +962,70 @@ func readTemplateFile(templateFileName string) string {
 // NewServerRouter returns a new mux.Router for handling any HTTP request to /.*
 func NewServerRouter(s *SSRFSheriffRouter) *mux.Router {
 	router := mux.NewRouter()
+	if len(s.trustedProxies) > 0 {
+		router.Use(s.realIPMiddleware)
+	}
+	router.Use(s.loggingMiddleware)
+	router.Path(s.internalPaths.Health).HandlerFunc(s.HealthHandler)
+	router.Path(s.internalPaths.Version).HandlerFunc(s.VersionHandler)
+	if s.rawRequests != nil {
+		router.Use(s.rawCaptureMiddleware)
+		router.Path(s.internalPaths.Raw).HandlerFunc(s.RawHandler)
+	}
+	router.Path(s.internalPaths.UserAgents).HandlerFunc(s.UserAgentsHandler)
+	router.Path(s.internalPaths.NewToken).HandlerFunc(s.NewTokenHandler)
+	router.Path(s.internalPaths.Sessions).HandlerFunc(s.SessionsHandler)
+	router.Path(s.internalPaths.VerifyToken).HandlerFunc(s.VerifyTokenHandler)
+	router.Path(s.internalPaths.Hits).HandlerFunc(s.HitsHandler)
+	router.Path(s.internalPaths.HAR).HandlerFunc(s.HARHandler)
+	router.Path(s.internalPaths.Interactions).HandlerFunc(s.InteractionsHandler)
+	if s.metaRedirect.Enabled {
+		router.Path(s.metaRedirect.Path).HandlerFunc(s.MetaRedirectHandler)
+	}
+	if s.redirect.Enabled {
+		// Match on the escaped path so percent-encoded redirect targets
+		// aren't decoded and then "cleaned" into a different path.
+		router.UseEncodedPath()
+		router.PathPrefix(s.redirect.Prefix + "/").HandlerFunc(s.RedirectHandler)
+	}
+	if s.delay.Prefix != "" {
+		router.PathPrefix(s.delay.Prefix + "/").HandlerFunc(s.DelayHandler)
+	}
+	if s.slow.Prefix != "" {
+		router.PathPrefix(s.slow.Prefix + "/").HandlerFunc(s.SlowHandler)
+	}
+	if s.authChallengePrefix != "" {
+		router.PathPrefix(s.authChallengePrefix + "/").HandlerFunc(s.AuthChallengeHandler)
+	}
+	if s.headerStress.Prefix != "" {
+		router.PathPrefix(s.headerStress.Prefix + "/").HandlerFunc(s.HeaderStressHandler)
+	}
+	if s.desync.Prefix != "" {
+		router.PathPrefix(s.desync.Prefix + "/").HandlerFunc(s.DesyncHandler)
+	}
+	if s.statusPrefix != "" {
+		router.PathPrefix(s.statusPrefix + "/").HandlerFunc(s.StatusHandler)
+	}
+	if s.metadataEmulation.Enabled {
+		for _, provider := range metadataProviders {
+			router.PathPrefix(provider.prefix).HandlerFunc(s.CloudMetadataHandler)
+		}
+	}
+	if s.websocket.Path != "" {
+		router.Path(s.websocket.Path).HandlerFunc(s.WebSocketHandler)
+	}
+	router.Path("/favicon.ico").HandlerFunc(s.FileHandler("favicon.ico"))
+	router.Path("/qr.png").HandlerFunc(s.FileHandler("qr.png"))
+	for _, path := range jwksPaths {
+		router.Path(path).HandlerFunc(s.JWKSHandler)
+	}
+	for path, name := range documentPaths {
+		router.Path(path).HandlerFunc(s.DocumentHandler(name))
+	}
 	router.PathPrefix("/").HandlerFunc(s.PathHandler)
 	return router
 }
 
-// NewConfigProvider returns a config.Provider for YAML configuration
-func NewConfigProvider() (config.Provider, error) {
-	return config.NewYAMLProviderFromFiles("config/base.yaml")
-}
-
 // NewLogger returns a new *zap.Logger
 func NewLogger() (*zap.Logger, error) {
 	zapConfig := zap.NewProductionConfig()
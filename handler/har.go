@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/zap"
+)
+
+// The types below are the subset of the HAR 1.2 format
+// (http://www.softwareishard.com/blog/har-12-spec/) needed to describe a
+// recorded callback.
+
+type harLog struct {
+	Log harContent `json:"log"`
+}
+
+type harContent struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// harResponse is left empty: only the request is recorded, so entries carry
+// the "no response" values the spec allows.
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harBody        `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harBody struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harEntryFor describes a stored HTTP callback as a HAR entry. Hits don't
+// record the scheme, query string, protocol version or body, so the URL is
+// rebuilt as http://<host><path> and the version is left empty.
+func harEntryFor(hit storage.Hit) harEntry {
+	request := harRequest{
+		Method:      hit.Method,
+		URL:         "http://" + hit.Host + hit.Path,
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{},
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if hit.Host != "" {
+		request.Headers = append(request.Headers, harNameValue{"Host", hit.Host})
+	}
+	names := make([]string, 0, len(hit.Headers))
+	for name := range hit.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range hit.Headers[name] {
+			request.Headers = append(request.Headers, harNameValue{name, value})
+		}
+	}
+	for _, c := range (&http.Request{Header: hit.Headers}).Cookies() {
+		request.Cookies = append(request.Cookies, harNameValue{c.Name, c.Value})
+	}
+
+	comment := "callback from " + hit.IP
+	if hit.Token != "" {
+		comment += " with token " + hit.Token
+	}
+	if hit.Target != "" {
+		comment += " for target " + hit.Target
+	}
+	return harEntry{
+		StartedDateTime: hit.Time.UTC().Format(time.RFC3339Nano),
+		Request:         request,
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Comment: comment,
+	}
+}
+
+// HARHandler exports the stored HTTP callbacks as a HAR (HTTP Archive) log,
+// oldest first, which can be loaded into browser devtools and other HAR
+// viewers. They are selected with the same query parameters as HitsHandler.
+func (s *SSRFSheriffRouter) HARHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.hits == nil {
+		http.Error(w, "hit storage is disabled", http.StatusNotFound)
+		return
+	}
+
+	q, err := hitsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hits, err := s.hits.Query(r.Context(), q)
+	if err != nil {
+		s.logger.Error("Failed to query hits", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	har := harLog{Log: harContent{
+		Version: "1.2",
+		Creator: harCreator{Name: "ssrf-sheriff", Version: ReadBuildInfo().Version},
+		Entries: []harEntry{},
+	}}
+	for i := len(hits) - 1; i >= 0; i-- {
+		if hits[i].Method == "DNS" {
+			continue
+		}
+		har.Log.Entries = append(har.Log.Entries, harEntryFor(hits[i]))
+	}
+
+	res, _ := json.Marshal(har)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="ssrf-sheriff.har"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
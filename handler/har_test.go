@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/zap"
+)
+
+func TestHARExportsStoredHits(t *testing.T) {
+	store := storage.NewMemory(10)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	// Two callbacks from the same IP must both be exported, and DNS lookups
+	// left out.
+	for i, hit := range []storage.Hit{
+		{IP: "192.0.2.1", Method: "GET", Host: "sheriff.example.com", Path: "/first", Token: "tok",
+			Headers: http.Header{"Cookie": {"session=abc"}, "User-Agent": {"curl/8.0"}}},
+		{IP: "192.0.2.1", Method: "DNS", Host: "x.sheriff.example.com."},
+		{IP: "192.0.2.1", Method: "POST", Host: "sheriff.example.com", Path: "/second", Target: "t1"},
+	} {
+		hit.Time = start.Add(time.Duration(i) * time.Second)
+		if err := store.Record(context.Background(), hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), hits: store, adminToken: "adm"}
+
+	r := httptest.NewRequest("GET", "/api/har", nil)
+	r.Header.Set("Authorization", "Bearer adm")
+	w := httptest.NewRecorder()
+	s.HARHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var har harLog
+	if err := json.Unmarshal(w.Body.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	entries := har.Log.Entries
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if first.Request.URL != "http://sheriff.example.com/first" || second.Request.URL != "http://sheriff.example.com/second" {
+		t.Errorf("URLs = %q, %q, want the callbacks oldest first", first.Request.URL, second.Request.URL)
+	}
+	if first.StartedDateTime != start.Format(time.RFC3339Nano) {
+		t.Errorf("startedDateTime = %q, want %q", first.StartedDateTime, start.Format(time.RFC3339Nano))
+	}
+	if len(first.Request.Cookies) != 1 || first.Request.Cookies[0] != (harNameValue{"session", "abc"}) {
+		t.Errorf("cookies = %v, want session=abc", first.Request.Cookies)
+	}
+	if second.Request.Method != "POST" || second.Comment != "callback from 192.0.2.1 for target t1" {
+		t.Errorf("second entry = %s %q", second.Request.Method, second.Comment)
+	}
+}
+
+func TestHARRequiresHitStorage(t *testing.T) {
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), adminToken: "adm"}
+	r := httptest.NewRequest("GET", "/api/har", nil)
+	r.Header.Set("Authorization", "Bearer adm")
+	w := httptest.NewRecorder()
+	s.HARHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
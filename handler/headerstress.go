@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// headerStressConfig configures the header stress routes, which answer with
+// unusual response headers to probe the SSRF client's header parser.
+type headerStressConfig struct {
+	// Prefix of the /<prefix>/<kind>[/<path>] routes. Empty disables them.
+	Prefix string `yaml:"prefix"`
+	// MaxCount is the most headers a request may ask for.
+	MaxCount int `yaml:"max_count"`
+	// MaxBytes caps count times size, the header bytes a request may ask for.
+	MaxBytes int `yaml:"max_bytes"`
+}
+
+var defaultHeaderStress = headerStressConfig{
+	MaxCount: 1000,
+	MaxBytes: 1 << 20,
+}
+
+func loadHeaderStress(cfg config.Provider) (headerStressConfig, error) {
+	hc := defaultHeaderStress
+	if err := cfg.Get("research.header_stress").Populate(&hc); err != nil {
+		return hc, fmt.Errorf("failed to load research.header_stress: %v", err)
+	}
+	if hc.Prefix != "" && (!strings.HasPrefix(hc.Prefix, "/") || hc.Prefix == "/") {
+		return hc, fmt.Errorf("invalid research.header_stress.prefix %q: must start with / and not be /", hc.Prefix)
+	}
+	hc.Prefix = strings.TrimSuffix(hc.Prefix, "/")
+	if hc.MaxCount <= 0 || hc.MaxBytes <= 0 {
+		return hc, fmt.Errorf("research.header_stress.max_count and max_bytes must be positive")
+	}
+	return hc, nil
+}
+
+// headerStressKinds are the kinds of header stress responses, with the
+// default count and size of each.
+var headerStressKinds = map[string]struct{ count, size int }{
+	// count headers of size bytes each, ending in the token
+	"large": {1, 64 << 10},
+	// count copies of each token header, numbered so the log of a later
+	// request shows which one the client kept
+	"duplicate": {2, 0},
+	// the token split across count obs-fold continuation lines
+	"folded": {2, 0},
+	// the token in headers clients commonly parse: cookies, links, filenames
+	"token": {1, 0},
+	// every request header reflected back as X-Echo-<Name>
+	"reflect": {1, 0},
+}
+
+// HeaderStressHandler answers <prefix>/<kind>[/<path>] with the token in the
+// body and headers of the given kind, sized by the count and size query
+// parameters, to stress the client's header parser and look for response
+// smuggling primitives.
+func (s *SSRFSheriffRouter) HeaderStressHandler(w http.ResponseWriter, r *http.Request) {
+	usage := func(msg string) {
+		http.Error(w, msg+"\nusage: "+s.headerStress.Prefix+"/<large|duplicate|folded|token|reflect>[/<path>]?count=<n>&size=<bytes>", http.StatusBadRequest)
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, s.headerStress.Prefix+"/")
+	kind, _, _ := strings.Cut(rest, "/")
+	defaults, ok := headerStressKinds[kind]
+	if !ok {
+		usage(fmt.Sprintf("unknown kind %q", kind))
+		return
+	}
+
+	count, size := defaults.count, defaults.size
+	query := r.URL.Query()
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			usage("count must be a positive number")
+			return
+		}
+		count = n
+	}
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			usage("size must be a positive number")
+			return
+		}
+		size = n
+	}
+	if count > s.headerStress.MaxCount {
+		usage(fmt.Sprintf("count must not exceed %d", s.headerStress.MaxCount))
+		return
+	}
+	if count*size > s.headerStress.MaxBytes {
+		usage(fmt.Sprintf("count times size must not exceed %d", s.headerStress.MaxBytes))
+		return
+	}
+
+	defer s.callbacks.record()
+	profile := s.acceptCallback(r)
+	token := sanitizeHeaderValue(profile.Token)
+
+	addLogFields(r,
+		zap.String("Header Stress", kind),
+		zap.Int("Header Count", count),
+		zap.Int("Header Size", size),
+	)
+
+	if kind == "folded" {
+		if !s.serveFoldedHeaders(w, r, token, count) {
+			http.Error(w, "folded headers need an HTTP/1.x connection", http.StatusHTTPVersionNotSupported)
+		}
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/plain")
+	switch kind {
+	case "large":
+		for i := 1; i <= count; i++ {
+			value := token
+			if pad := size - len(token); pad > 0 {
+				value = strings.Repeat("A", pad) + token
+			}
+			h.Set(fmt.Sprintf("X-Large-%d", i), value)
+		}
+	case "duplicate":
+		for _, name := range s.tokenHeaders {
+			for i := 1; i <= count; i++ {
+				h.Add(name, fmt.Sprintf("%d:%s", i, token))
+			}
+		}
+	case "token":
+		for _, name := range s.tokenHeaders {
+			h.Set(name, token)
+		}
+		h.Add("Set-Cookie", fmt.Sprintf("ssrf_token=%s; Path=/", token))
+		h.Set("Link", fmt.Sprintf("</%s>; rel=\"canonical\"", token))
+		h.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", token+".txt"))
+		h.Set("Server", "ssrf-sheriff/"+token)
+		h.Set("Warning", fmt.Sprintf("199 - %q", token))
+		if validHeaderName(token) {
+			h.Set("X-"+token, "1")
+		}
+	case "reflect":
+		for _, name := range s.tokenHeaders {
+			h.Set(name, token)
+		}
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			h.Set(echoHeaderPrefix+name, sanitizeHeaderValue(strings.Join(r.Header.Values(name), ", ")))
+		}
+		h.Set(echoHeaderPrefix+"Host", sanitizeHeaderValue(r.Host))
+	}
+
+	s.writeResponse(w, r, http.StatusOK, []byte(profile.Token))
+}
+
+// serveFoldedHeaders hijacks the connection and writes a response whose token
+// headers are split across count obs-fold continuation lines, which net/http
+// can't produce. RFC 7230 deprecates line folding, so clients either join the
+// lines, reject the response or take the continuation lines for headers of
+// their own. It returns false if the connection can't be hijacked.
+func (s *SSRFSheriffRouter) serveFoldedHeaders(w http.ResponseWriter, r *http.Request, token string, count int) bool {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Warn("Failed to hijack connection for folded headers", zap.Error(err))
+		return false
+	}
+	defer conn.Close()
+
+	// Split the token into count roughly even pieces, one per line.
+	var pieces []string
+	for i, rest := 0, token; i < count; i++ {
+		n := (len(rest) + count - i - 1) / (count - i)
+		pieces = append(pieces, rest[:n])
+		rest = rest[n:]
+	}
+	folded := strings.Join(pieces, "\r\n ")
+
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain\r\n")
+	fmt.Fprintf(buf, "X-Folded-Token: %s\r\n", folded)
+	for _, name := range s.tokenHeaders {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, folded)
+	}
+	fmt.Fprintf(buf, "Content-Length: %d\r\n", len(token))
+	fmt.Fprintf(buf, "Connection: close\r\n\r\n")
+	fmt.Fprint(buf, token)
+
+	if err := buf.Flush(); err != nil {
+		s.logger.Warn("Failed to write folded headers", zap.Error(err))
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/logfile"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// defaultHitLogMaxSizeMB and defaultHitLogMaxBackups control rotation of
+	// the hit log unless hit_log.max_size_mb and hit_log.max_backups are
+	// configured.
+	defaultHitLogMaxSizeMB  = 100
+	defaultHitLogMaxBackups = 5
+)
+
+// HitLog writes every callback as one JSON object per line to its own file,
+// separate from the console logs, so it can be fed to jq or a log pipeline.
+type HitLog struct {
+	logger *zap.Logger
+}
+
+// NewHitLog opens the file configured in the hit_log section. It returns nil
+// if hit_log.path is empty.
+func NewHitLog(cfg config.Provider, lc fx.Lifecycle) (*HitLog, error) {
+	raw := struct {
+		Path       string `yaml:"path"`
+		MaxSizeMB  int64  `yaml:"max_size_mb"`
+		MaxBackups int    `yaml:"max_backups"`
+	}{
+		MaxSizeMB:  defaultHitLogMaxSizeMB,
+		MaxBackups: defaultHitLogMaxBackups,
+	}
+	if err := cfg.Get("hit_log").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load hit_log: %v", err)
+	}
+	if raw.Path == "" {
+		return nil, nil
+	}
+	if raw.MaxSizeMB < 0 || raw.MaxBackups < 0 {
+		return nil, fmt.Errorf("hit_log.max_size_mb and hit_log.max_backups can't be negative")
+	}
+
+	file, err := logfile.Open(raw.Path, raw.MaxSizeMB<<20, raw.MaxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hit_log.path: %v", err)
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "Time"
+	encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	encoderConfig.LevelKey = zapcore.OmitKey
+	encoderConfig.CallerKey = zapcore.OmitKey
+	encoderConfig.MessageKey = zapcore.OmitKey
+	encoderConfig.StacktraceKey = zapcore.OmitKey
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), file, zapcore.InfoLevel)
+
+	lc.Append(fx.Hook{OnStop: func(context.Context) error { return file.Close() }})
+	return &HitLog{logger: zap.New(core)}, nil
+}
+
+// Write appends a callback with the given fields to the hit log. It does
+// nothing on a nil HitLog.
+func (h *HitLog) Write(fields ...zap.Field) {
+	if h == nil {
+		return
+	}
+	h.logger.Info("", fields...)
+}
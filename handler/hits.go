@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultHitsLimit and maxHitsLimit bound the number of hits returned by
+	// the hits API.
+	defaultHitsLimit = 100
+	maxHitsLimit     = 1000
+
+	// recordTimeout bounds how long a callback waits for its hit to be
+	// stored.
+	recordTimeout = 5 * time.Second
+)
+
+// NewHitStore opens the store configured in the storage section. It returns
+// nil if storage.driver is empty.
+func NewHitStore(cfg config.Provider, lc fx.Lifecycle) (storage.Store, error) {
+	var raw struct {
+		Driver   string `yaml:"driver"`
+		Path     string `yaml:"path"`
+		Capacity int    `yaml:"capacity"`
+	}
+	if err := cfg.Get("storage").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load storage: %v", err)
+	}
+
+	var (
+		store storage.Store
+		err   error
+	)
+	switch raw.Driver {
+	case "":
+		return nil, nil
+	case "sqlite":
+		if dir := filepath.Dir(raw.Path); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create storage directory: %v", err)
+			}
+		}
+		store, err = storage.OpenSQLite(raw.Path)
+	case "memory":
+		store = storage.NewMemory(raw.Capacity)
+	default:
+		return nil, fmt.Errorf("unsupported storage.driver %q", raw.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{OnStop: func(context.Context) error { return store.Close() }})
+	return store, nil
+}
+
+// recordHit sends the callback to live hit streams and stores it, along with
+// the ID of the target it was attributed to, if any, and of its session.
+func (s *SSRFSheriffRouter) recordHit(r *http.Request, token, target string) {
+	hit := storage.Hit{
+		Time:      time.Now().UTC(),
+		IP:        clientIP(r),
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Token:     token,
+		UserAgent: r.UserAgent(),
+		Headers:   r.Header,
+		Target:    target,
+		Session:   requestSession(r),
+	}
+	s.storeHit(hit)
+}
+
+// storeHit sends a hit to live hit streams and stores it, if storage is
+// enabled.
+func (s *SSRFSheriffRouter) storeHit(hit storage.Hit) {
+	s.feed.publish(hit)
+	if s.hits == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	if err := s.hits.Record(ctx, hit); err != nil {
+		s.logger.Error("Failed to record hit",
+			zap.String("IP", hit.IP),
+			zap.String("Method", hit.Method),
+			zap.String("Path", hit.Path),
+			zap.Error(err),
+		)
+	}
+}
+
+// HitsHandler returns recorded callbacks as JSON, newest first, filtered as
+// described for hitsQuery.
+func (s *SSRFSheriffRouter) HitsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.hits == nil {
+		http.Error(w, "hit storage is disabled", http.StatusNotFound)
+		return
+	}
+
+	q, err := hitsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hits, err := s.hits.Query(r.Context(), q)
+	if err != nil {
+		s.logger.Error("Failed to query hits", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	res, _ := json.Marshal(hits)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// hitsQuery reads the filters of a hits API request: since (an RFC 3339 time,
+// or a duration such as "1h" meaning that long ago), ip, token, target,
+// session and limit.
+func hitsQuery(r *http.Request) (storage.Query, error) {
+	query := r.URL.Query()
+	q := storage.Query{
+		IP:      query.Get("ip"),
+		Token:   query.Get("token"),
+		Target:  query.Get("target"),
+		Session: query.Get("session"),
+		Limit:   defaultHitsLimit,
+	}
+	if since := query.Get("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			q.Since = t
+		} else if d, err := time.ParseDuration(since); err == nil {
+			q.Since = time.Now().Add(-d)
+		} else {
+			return q, errors.New("since must be an RFC 3339 time or a duration")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, errors.New("limit must be a positive integer")
+		}
+		if n > maxHitsLimit {
+			n = maxHitsLimit
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
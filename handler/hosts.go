@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// hostProfile is the token and template set used to answer requests for a
+// virtual host. An empty Templates directory uses the embedded templates.
+type hostProfile struct {
+	Token     string `yaml:"ssrf_token"`
+	Templates string `yaml:"templates"`
+
+	// mediaToken, if set, is rendered into generated media instead of
+	// Token: the profile's own token while Token is a per-request one, so
+	// media stays cached.
+	mediaToken string
+}
+
+// tokenForMedia returns the token rendered into the profile's generated
+// media.
+func (p hostProfile) tokenForMedia() string {
+	if p.mediaToken != "" {
+		return p.mediaToken
+	}
+	return p.Token
+}
+
+// hostRule binds a hostname pattern (as understood by path.Match, e.g.
+// "*.engagement.example.com") to a hostProfile.
+type hostRule struct {
+	pattern string
+	profile hostProfile
+}
+
+// loadHostRules reads the hosts config map and validates its patterns. Rules
+// are sorted so that longer, more specific patterns are tried first.
+func loadHostRules(cfg config.Provider) ([]hostRule, error) {
+	var hosts map[string]hostProfile
+	if err := cfg.Get("hosts").Populate(&hosts); err != nil {
+		return nil, fmt.Errorf("failed to load hosts: %v", err)
+	}
+
+	rules := make([]hostRule, 0, len(hosts))
+	for pattern, profile := range hosts {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %v", pattern, err)
+		}
+		if profile.Token == "" {
+			return nil, fmt.Errorf("host pattern %q has no ssrf_token", pattern)
+		}
+		rules = append(rules, hostRule{pattern: pattern, profile: profile})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].pattern) != len(rules[j].pattern) {
+			return len(rules[i].pattern) > len(rules[j].pattern)
+		}
+		return rules[i].pattern < rules[j].pattern
+	})
+	return rules, nil
+}
+
+// profileFor returns the hostProfile matching the request's Host, falling
+// back to the default token and the embedded templates.
+func (s *SSRFSheriffRouter) profileFor(r *http.Request) hostProfile {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, rule := range s.hostRules {
+		if ok, _ := path.Match(rule.pattern, host); ok {
+			return rule.profile
+		}
+	}
+
+	return hostProfile{Token: s.secretToken()}
+}
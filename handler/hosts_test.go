@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/teknogeek/ssrf-sheriff/generators"
+	"go.uber.org/zap"
+)
+
+func TestMediaIsGeneratedWithTheBaseToken(t *testing.T) {
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), media: generators.NewCache(generators.Options{})}
+	profile := hostProfile{Token: "perrequesttoken", mediaToken: "basetoken"}
+
+	svg := s.templateFile(profile, "svg.svg")
+	if !strings.Contains(svg, "basetoken") || strings.Contains(svg, "perrequesttoken") {
+		t.Errorf("svg.svg doesn't carry just the base token:\n%s", svg)
+	}
+	if got := (hostProfile{Token: "tok"}).tokenForMedia(); got != "tok" {
+		t.Errorf("tokenForMedia without a media token = %q, want the token", got)
+	}
+}
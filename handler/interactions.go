@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultInteractionWindow is how far apart two hits with the same token or
+// target may be to belong to one interaction, unless interactions.window is
+// configured.
+const defaultInteractionWindow = 5 * time.Minute
+
+// loadInteractionWindow reads interactions.window.
+func loadInteractionWindow(cfg config.Provider) (time.Duration, error) {
+	window := defaultInteractionWindow
+	if err := cfg.Get("interactions.window").Populate(&window); err != nil {
+		return 0, fmt.Errorf("failed to load interactions.window: %v", err)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("interactions.window must be positive, got %v", window)
+	}
+	return window, nil
+}
+
+// interaction is a run of hits sharing a correlation ID, each recorded within
+// the window of the previous one: typically a DNS lookup of <id>.<zone>
+// followed by the HTTP request it resolved for.
+type interaction struct {
+	// ID is the target ID or token the hits share.
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	DNSLookups   int `json:"dns_lookups"`
+	HTTPRequests int `json:"http_requests"`
+	// ResolvedThenFetched is set when an HTTP request followed a DNS lookup,
+	// the usual shape of an SSRF fetching a URL.
+	ResolvedThenFetched bool `json:"resolved_then_fetched"`
+
+	// Hits are oldest first.
+	Hits []storage.Hit `json:"hits"`
+}
+
+// correlateHits groups hits, newest first as returned by a Store, into
+// interactions by the ID key returns for each. Hits for which key returns ""
+// are left out. Interactions are returned most recently active first.
+func correlateHits(hits []storage.Hit, window time.Duration, key func(storage.Hit) string) []*interaction {
+	var (
+		interactions []*interaction
+		open         = make(map[string]*interaction)
+	)
+	for i := len(hits) - 1; i >= 0; i-- {
+		hit := hits[i]
+		id := key(hit)
+		if id == "" {
+			continue
+		}
+
+		in, ok := open[id]
+		if !ok || hit.Time.Sub(in.End) > window {
+			in = &interaction{ID: id, Start: hit.Time}
+			open[id] = in
+			interactions = append(interactions, in)
+		}
+		in.End = hit.Time
+		in.Hits = append(in.Hits, hit)
+		if hit.Method == "DNS" {
+			in.DNSLookups++
+		} else {
+			in.HTTPRequests++
+			if in.DNSLookups > 0 {
+				in.ResolvedThenFetched = true
+			}
+		}
+	}
+
+	sort.SliceStable(interactions, func(i, j int) bool {
+		return interactions[i].End.After(interactions[j].End)
+	})
+	return interactions
+}
+
+// interactionID returns the correlation ID of a hit: the ID of its target,
+// or else its token unless that is the shared secret token, which many
+// unrelated callbacks carry.
+func (s *SSRFSheriffRouter) interactionID(hit storage.Hit) string {
+	if hit.Target != "" {
+		return hit.Target
+	}
+	if _, ok := s.generations.lookup(hit.Token); ok {
+		return ""
+	}
+	return hit.Token
+}
+
+// InteractionsHandler returns the recorded hits grouped into interactions by
+// target or token, correlating DNS lookups with the HTTP requests that
+// followed them, most recent first. The id query parameter restricts them to
+// one target ID or token, and since works as for HitsHandler. Only the most
+// recent hits, as many as the hits API returns at most, are considered.
+func (s *SSRFSheriffRouter) InteractionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.hits == nil {
+		http.Error(w, "hit storage is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	q := storage.Query{Limit: maxHitsLimit}
+	if since := query.Get("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			q.Since = t
+		} else if d, err := time.ParseDuration(since); err == nil {
+			q.Since = time.Now().Add(-d)
+		} else {
+			http.Error(w, "since must be an RFC 3339 time or a duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var (
+		hits []storage.Hit
+		key  = s.interactionID
+	)
+	if id := query.Get("id"); id != "" {
+		key = func(storage.Hit) string { return id }
+		// An ID may be a target or a token, and the store filters on one
+		// field at a time.
+		for _, q := range []storage.Query{{Since: q.Since, Target: id, Limit: q.Limit}, {Since: q.Since, Token: id, Limit: q.Limit}} {
+			found, err := s.hits.Query(r.Context(), q)
+			if err != nil {
+				s.logger.Error("Failed to query hits", zap.Error(err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			hits = mergeHits(hits, found)
+		}
+	} else {
+		var err error
+		if hits, err = s.hits.Query(r.Context(), q); err != nil {
+			s.logger.Error("Failed to query hits", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	interactions := correlateHits(hits, s.interactionWindow, key)
+	if interactions == nil {
+		interactions = []*interaction{}
+	}
+	res, _ := json.Marshal(interactions)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// mergeHits merges two lists of hits, newest first, dropping duplicates.
+func mergeHits(a, b []storage.Hit) []storage.Hit {
+	seen := make(map[int64]bool, len(a))
+	for _, hit := range a {
+		seen[hit.ID] = true
+	}
+	for _, hit := range b {
+		if !seen[hit.ID] {
+			a = append(a, hit)
+		}
+	}
+	sort.SliceStable(a, func(i, j int) bool { return a[i].Time.After(a[j].Time) })
+	return a
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/storage"
+)
+
+func TestZoneLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"abc.sheriff.test", []string{"abc"}},
+		{"x.ABC.sheriff.test.", []string{"x", "abc"}},
+		{"abc.sheriff.test:8080", []string{"abc"}},
+		{"sheriff.test", nil},
+		{"abc.notsheriff.test", nil},
+		{"abc.sheriff.test.evil.test", nil},
+	}
+	for _, tt := range tests {
+		if got := zoneLabels(tt.name, "sheriff.test"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("zoneLabels(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := zoneLabels("abc.sheriff.test", ""); got != nil {
+		t.Errorf("zoneLabels without a zone = %q, want nil", got)
+	}
+}
+
+func TestCorrelateHits(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	// Newest first, as a Store returns them.
+	hits := []storage.Hit{
+		{ID: 7, Time: at(30 * time.Minute), Method: "GET", Token: "a"},
+		{ID: 6, Time: at(3 * time.Minute), Method: "GET", Token: "secret"},
+		{ID: 5, Time: at(2 * time.Minute), Method: "GET", Token: "b"},
+		{ID: 4, Time: at(90 * time.Second), Method: "GET", Token: "a"},
+		{ID: 3, Time: at(time.Minute), Method: "DNS", Token: "a"},
+		{ID: 2, Time: at(30 * time.Second), Method: "GET", Token: "secret", Target: "t1"},
+		{ID: 1, Time: at(0), Method: "DNS", Token: "a"},
+	}
+	key := func(hit storage.Hit) string {
+		if hit.Target != "" {
+			return hit.Target
+		}
+		if hit.Token == "secret" {
+			return ""
+		}
+		return hit.Token
+	}
+
+	got := correlateHits(hits, 5*time.Minute, key)
+
+	type summary struct {
+		id                  string
+		ids                 []int64
+		dns, http           int
+		resolvedThenFetched bool
+	}
+	var sums []summary
+	for _, in := range got {
+		s := summary{id: in.ID, dns: in.DNSLookups, http: in.HTTPRequests, resolvedThenFetched: in.ResolvedThenFetched}
+		for _, hit := range in.Hits {
+			s.ids = append(s.ids, hit.ID)
+		}
+		sums = append(sums, s)
+	}
+	want := []summary{
+		// Too long after the first interaction for a to belong to it.
+		{"a", []int64{7}, 0, 1, false},
+		{"b", []int64{5}, 0, 1, false},
+		{"a", []int64{1, 3, 4}, 2, 1, true},
+		{"t1", []int64{2}, 0, 1, false},
+	}
+	if !reflect.DeepEqual(sums, want) {
+		t.Errorf("interactions = %+v, want %+v", sums, want)
+	}
+	if first := got[2]; !first.Start.Equal(at(0)) || !first.End.Equal(at(90*time.Second)) {
+		t.Errorf("interaction spans %v to %v, want %v to %v", first.Start, first.End, at(0), at(90*time.Second))
+	}
+}
+
+func TestMergeHits(t *testing.T) {
+	now := time.Now()
+	a := []storage.Hit{{ID: 3, Time: now}, {ID: 1, Time: now.Add(-2 * time.Second)}}
+	b := []storage.Hit{{ID: 3, Time: now}, {ID: 2, Time: now.Add(-time.Second)}}
+
+	var ids []int64
+	for _, hit := range mergeHits(a, b) {
+		ids = append(ids, hit.ID)
+	}
+	if want := []int64{3, 2, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("merged IDs = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// jwksPaths are where the key set is served. Services that fetch a JWKS from
+// a URL taken from a token or a discovery document look in either.
+var jwksPaths = []string{"/jwks.json", "/.well-known/jwks.json"}
+
+// signingKeys are the keys published in the JWKS, generated at startup.
+type signingKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newSigningKeys() (*signingKeys, error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate EC key: %v", err)
+	}
+	return &signingKeys{rsa: rsaKey, ec: ecKey}, nil
+}
+
+// jwk is a public JSON Web Key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// set returns the public key set with the token in the key IDs.
+func (k *signingKeys) set(token string) []jwk {
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecBytes := (k.ec.Curve.Params().BitSize + 7) / 8
+	return []jwk{
+		{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: token,
+			N:   b64(k.rsa.N.Bytes()),
+			E:   b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			Kty: "EC",
+			Use: "sig",
+			Alg: "ES256",
+			Kid: token + "-ec",
+			Crv: "P-256",
+			X:   b64(k.ec.X.FillBytes(make([]byte, ecBytes))),
+			Y:   b64(k.ec.Y.FillBytes(make([]byte, ecBytes))),
+		},
+	}
+}
+
+// JWKSHandler serves the public keys generated at startup as a JSON Web Key
+// Set, with the token in each key ID. A service fetching it has followed an
+// attacker-controlled JWKS URL, e.g. from a token's jku header, which is
+// logged on its own.
+func (s *SSRFSheriffRouter) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	defer s.callbacks.record()
+
+	profile := s.acceptCallback(r)
+	s.logger.Warn("JWKS fetched",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("User-Agent", r.UserAgent()),
+	)
+
+	body, _ := json.MarshalIndent(struct {
+		Keys []jwk `json:"keys"`
+	}{s.signingKeys.set(profile.Token)}, "", "  ")
+
+	w.Header().Set("Content-Type", "application/json")
+	for _, header := range s.tokenHeaders {
+		w.Header().Set(header, profile.Token)
+	}
+	s.writeResponse(w, r, http.StatusOK, body)
+}
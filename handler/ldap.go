@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/ldapserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewLDAPServer builds the LDAP honeypot configured in the ldap section, which
+// answers searches with the secret token and logs every bind and search.
+// Sources outside security.allowed_source_cidrs get the decoy token. It
+// returns nil if ldap.address isn't set.
+func NewLDAPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*ldapserver.Server, error) {
+	var raw struct {
+		Address string `yaml:"address"`
+	}
+	if err := cfg.Get("ldap").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load ldap: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return ldapserver.New(ldapserver.Config{
+		Addr:  raw.Address,
+		Token: s.sourceToken("LDAP"),
+		Proxy: proxy,
+	}, logger), nil
+}
+
+// StartLDAPServer starts the LDAP honeypot, if one is configured.
+func StartLDAPServer(srv *ldapserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
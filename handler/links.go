@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// linkAlternates sets a Link header advertising the same resource in each of
+// the configured formats, for clients that prefetch linked resources.
+func (s *SSRFSheriffRouter) linkAlternates(w http.ResponseWriter, r *http.Request, fileExtension string) {
+	if len(s.linkFormats) == 0 {
+		return
+	}
+
+	base := strings.TrimSuffix(r.URL.Path, fileExtension)
+	if base == "" || strings.HasSuffix(base, "/") {
+		base += "payload"
+	}
+
+	links := make([]string, 0, len(s.linkFormats))
+	for _, ext := range s.linkFormats {
+		if ext == fileExtension {
+			continue
+		}
+		links = append(links, fmt.Sprintf(`<%s%s>; rel="alternate"; type="%s"`, base, ext, contentTypeFor(ext)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/gorilla/mux"
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/config"
+)
+
+// ExtraHandles are the Handles of the additional listeners on http.addresses
+// and http.tls.addresses, which serve the same routes as the main HTTP and
+// HTTPS ones. SSRF filters and firewalls often treat ports differently, so
+// listening on many of them catches probes that never reach the main port.
+type ExtraHandles []*httpserver.Handle
+
+// NewExtraHandles builds the additional HTTP and HTTPS listeners. Each
+// address may only be listened on once, including by the main listeners.
+func NewExtraHandles(mux *mux.Router, cfg config.Provider, cert TLSCertificate) (ExtraHandles, error) {
+	var raw struct {
+		Address   string    `yaml:"address"`
+		Addresses []string  `yaml:"addresses"`
+		TLS       tlsConfig `yaml:"tls"`
+	}
+	if err := cfg.Get("http").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load http: %v", err)
+	}
+	if len(raw.Addresses) == 0 && len(raw.TLS.Addresses) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]string)
+	for key, addr := range map[string]string{"http.address": raw.Address, "http.tls.address": raw.TLS.Address} {
+		if addr == "" {
+			continue
+		}
+		if normalized, err := httpserver.NormalizeAddr(addr); err == nil {
+			seen[normalized] = key
+		}
+	}
+
+	var handles ExtraHandles
+	add := func(key string, addrs []string, opts []httpserver.HandleOption) error {
+		for _, addr := range addrs {
+			normalized, err := httpserver.NormalizeAddr(addr)
+			if err != nil {
+				return fmt.Errorf("invalid address in %s: %v", key, err)
+			}
+			if other, ok := seen[normalized]; ok {
+				return fmt.Errorf("address %q in %s is already listened on by %s", addr, key, other)
+			}
+			seen[normalized] = key
+
+			server, err := newServer(normalized, mux, cfg)
+			if err != nil {
+				return err
+			}
+			handles = append(handles, httpserver.NewHandle(server, opts...))
+		}
+		return nil
+	}
+
+	if len(raw.Addresses) > 0 {
+		opts, err := httpHandleOptions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := add("http.addresses", raw.Addresses, opts); err != nil {
+			return nil, err
+		}
+	}
+	if len(raw.TLS.Addresses) > 0 {
+		opts, err := tlsHandleOptions(raw.TLS, cert, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := add("http.tls.addresses", raw.TLS.Addresses, opts); err != nil {
+			return nil, err
+		}
+	}
+	return handles, nil
+}
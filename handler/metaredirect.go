@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// metaRedirectConfig configures the endpoint redirecting SSRF clients into a
+// cloud metadata service.
+type metaRedirectConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	Target  string `yaml:"target"`
+}
+
+var defaultMetaRedirect = metaRedirectConfig{
+	Path:   "/meta-redirect",
+	Target: "http://169.254.169.254/latest/meta-data/iam/security-credentials/",
+}
+
+func loadMetaRedirect(cfg config.Provider) (metaRedirectConfig, error) {
+	mr := defaultMetaRedirect
+	if err := cfg.Get("meta_redirect").Populate(&mr); err != nil {
+		return mr, fmt.Errorf("failed to load meta_redirect: %v", err)
+	}
+	if !mr.Enabled {
+		return mr, nil
+	}
+
+	u, err := url.Parse(mr.Target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return mr, fmt.Errorf("invalid meta_redirect.target %q: must be an absolute URL", mr.Target)
+	}
+	return mr, nil
+}
+
+// MetaRedirectHandler 302-redirects to the configured cloud metadata URL, to
+// test whether a client that passed an initial allowlist check follows a
+// redirect into the metadata service.
+func (s *SSRFSheriffRouter) MetaRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	defer s.callbacks.record()
+	s.acceptCallback(r)
+
+	s.logger.Info("Redirecting to metadata URL",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Location", s.metaRedirect.Target),
+	)
+	http.Redirect(w, r, s.metaRedirect.Target, http.StatusFound)
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/teknogeek/ssrf-sheriff/notifier"
+)
+
+// Metrics are the Prometheus metrics exported by the sheriff on the admin
+// listener's /metrics endpoint.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	hits            prometheus.Counter
+	hitsByExtension *prometheus.CounterVec
+	hitsBySource    *prometheus.CounterVec
+	hitsByASN       *prometheus.CounterVec
+	responseLatency *prometheus.HistogramVec
+	generatorErrors prometheus.Counter
+	tokenEchoes     *prometheus.CounterVec
+}
+
+// NewMetrics registers the sheriff's metrics, along with the Go runtime and
+// process collectors, on a registry of their own.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "hits_total",
+			Help:      "Callbacks received.",
+		}),
+		hitsByExtension: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "hits_by_extension_total",
+			Help:      "Callbacks received, by requested file extension.",
+		}, []string{"extension"}),
+		hitsBySource: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "hits_by_source_total",
+			Help:      "Callbacks received, by client network: the /24 of IPv4 clients and the /48 of IPv6 ones.",
+		}, []string{"network"}),
+		hitsByASN: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "hits_by_asn_total",
+			Help:      "Callbacks received, by the autonomous system of the client. Only exported with enrichment.asn_db.",
+		}, []string{"asn"}),
+		responseLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "response_duration_seconds",
+			Help:      "Time taken to answer callbacks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"extension", "status"}),
+		generatorErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "generator_errors_total",
+			Help:      "Media generator runs that failed.",
+		}),
+		tokenEchoes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "token_echoes_total",
+			Help:      "Inbound requests carrying a token the sheriff served, by where it was found.",
+		}, []string{"location"}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.hits,
+		m.hitsByExtension,
+		m.hitsBySource,
+		m.hitsByASN,
+		m.responseLatency,
+		m.generatorErrors,
+		m.tokenEchoes,
+	)
+	return m
+}
+
+// observeCallback records a callback and how long it took to answer. asn is
+// the label of the client's autonomous system, or "" if ASNs aren't looked
+// up.
+func (m *Metrics) observeCallback(r *http.Request, status int, duration time.Duration, asn string) {
+	ext := extensionLabel(filepath.Ext(r.URL.Path))
+	m.hits.Inc()
+	m.hitsByExtension.WithLabelValues(ext).Inc()
+	m.hitsBySource.WithLabelValues(networkLabel(clientIP(r))).Inc()
+	if asn != "" {
+		m.hitsByASN.WithLabelValues(asn).Inc()
+	}
+	m.responseLatency.WithLabelValues(ext, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// networkLabel returns the label recorded for a client IP: its /24 for IPv4
+// and its /48 for IPv6, so clients spreading requests over many addresses
+// can't blow up the number of series.
+func networkLabel(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "unknown"
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// extensionLabel returns the label recorded for a requested extension. Only
+// served formats get their own label, so arbitrary paths can't blow up the
+// number of series.
+func extensionLabel(ext string) string {
+	if ext == "" {
+		return "none"
+	}
+	if _, ok := contentTypeOverrides[ext]; ok {
+		return ext
+	}
+	for _, known := range callbackExtensions {
+		if ext == known {
+			return ext
+		}
+	}
+	return "other"
+}
+
+// generatorFailed records n failed media generator runs.
+func (m *Metrics) generatorFailed(n int) {
+	m.generatorErrors.Add(float64(n))
+}
+
+// tokenEchoed records a served token found in an inbound request. Headers
+// share a single label so arbitrary header names can't blow up the number of
+// series.
+func (m *Metrics) tokenEchoed(location string) {
+	if strings.HasPrefix(location, "header:") {
+		location = "header"
+	}
+	m.tokenEchoes.WithLabelValues(location).Inc()
+}
+
+// watchNotifier exports the queue depth and drops of a notifier.
+func (m *Metrics) watchNotifier(webhooks *notifier.Webhooks) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "notification_queue_depth",
+			Help:      "Notifications waiting to be delivered.",
+		}, func() float64 { return float64(webhooks.QueueDepth()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "ssrf_sheriff",
+			Name:      "notifications_dropped_total",
+			Help:      "Notifications dropped because the queue was full or the notifier was stopped.",
+		}, func() float64 { return float64(webhooks.Dropped()) }),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,32 @@
+package handler
+
+import "testing"
+
+func TestNetworkLabel(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0/24"},
+		{"203.0.113.1", "203.0.113.0/24"},
+		{"::ffff:203.0.113.9", "203.0.113.0/24"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::/48"},
+		{"not an ip", "unknown"},
+		{"", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := networkLabel(tt.ip); got != tt.want {
+			t.Errorf("networkLabel(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestASNLabelWithoutDatabase(t *testing.T) {
+	var e *Enricher
+	if got := e.ASNLabel("203.0.113.1"); got != "" {
+		t.Errorf("ASNLabel without enrichment = %q, want none", got)
+	}
+	if got := (&Enricher{}).ASNLabel("203.0.113.1"); got != "" {
+		t.Errorf("ASNLabel without an ASN database = %q, want none", got)
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/zap"
+)
+
+// responseRecorder wraps an http.ResponseWriter to record the status code and
+// the number of body bytes actually written.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status       int
+	bytesWritten int64
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	// Interim 1xx responses are followed by the final status.
+	if rec.status == 0 && !informational(status) {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytesWritten += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent to the client, defaulting to 200 if the
+// handler never wrote a header explicitly.
+func (rec *responseRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Flush implements http.Flusher if the underlying writer supports it.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the underlying writer supports it.
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying writer for use with http.ResponseController.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logFieldsKey is the context key under which loggingMiddleware collects the
+// fields handlers add to a request's log entry.
+type logFieldsKey struct{}
+
+// addLogFields adds fields to the entry loggingMiddleware writes for r. It
+// does nothing for requests that aren't logged.
+func addLogFields(r *http.Request, fields ...zap.Field) {
+	if extra, ok := r.Context().Value(logFieldsKey{}).(*[]zap.Field); ok {
+		*extra = append(*extra, fields...)
+	}
+}
+
+// sessionKey is the context key under which loggingMiddleware stores the ID
+// of the request's session.
+type sessionKey struct{}
+
+// requestSession returns the ID of the session r belongs to, or "" for
+// requests that aren't logged.
+func requestSession(r *http.Request) string {
+	id, _ := r.Context().Value(sessionKey{}).(string)
+	return id
+}
+
+// loggingMiddleware logs every inbound request along with the response that
+// was sent for it, including its size and how long the handler took, and
+// writes the same entry to the hit log. Requests to internal paths aren't
+// callbacks and are not logged.
+func (s *SSRFSheriffRouter) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.internalPaths.contains(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sessionID := s.sessions.assign(clientIP(r), start)
+		rec := newResponseRecorder(w)
+		bodyFields := s.captureBody(r)
+		var extraFields []zap.Field
+		ctx := context.WithValue(r.Context(), logFieldsKey{}, &extraFields)
+		r = r.WithContext(context.WithValue(ctx, sessionKey{}, sessionID))
+		s.detectEchoedToken(r)
+
+		next.ServeHTTP(rec, r)
+		s.metrics.observeCallback(r, rec.Status(), time.Since(start), s.enricher.ASNLabel(clientIP(r)))
+
+		fields := []zap.Field{
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Method", r.Method),
+			zap.String("Host", r.Host),
+			zap.String("Path", r.URL.Path),
+			zap.String("Query", r.URL.RawQuery),
+			zap.String("Protocol", r.Proto),
+			zap.String("Local Address", localAddr(r)),
+			zap.String("Session", sessionID),
+			zap.String("Referer", r.Referer()),
+			zap.String("Origin", r.Header.Get("Origin")),
+			zap.Int("Response Status", rec.Status()),
+			zap.String("Response Content-Type", rec.Header().Get("Content-Type")),
+			zap.Int64("Response Bytes", rec.bytesWritten),
+			zap.Duration("Duration", time.Since(start)),
+			zap.Any("Request Headers", r.Header),
+		}
+		fields = append(fields, bodyFields...)
+		fields = append(fields, extraFields...)
+		fields = append(fields, proxyFields(r)...)
+		fields = append(fields, s.enricher.Fields(clientIP(r))...)
+		if fp := httpserver.TLSFingerprint(r.Context()); fp != nil {
+			fields = append(fields,
+				zap.String("JA3", fp.JA3),
+				zap.String("JA3 Hash", fp.JA3Hash),
+				zap.String("JA4", fp.JA4),
+			)
+		}
+		s.logger.Info("New inbound HTTP request", fields...)
+		s.hitLog.Write(fields...)
+	})
+}
+
+// localAddr returns the address of the listener the request came in on, which
+// tells the listeners on http.addresses and http.tls.addresses apart even when
+// the Host header doesn't.
+func localAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// responseModes are the response behaviors that can be switched at runtime
+// through the admin API. A snapshot is taken at the start of each callback.
+type responseModes struct {
+	Randomize         bool `json:"randomize_responses"`
+	SplitCanary       bool `json:"split_canary"`
+	NTLMCapture       bool `json:"ntlm_capture"`
+	UnknownPathStatus int  `json:"unknown_path_status"`
+	// XMLVariant is the variant of .xml responses, "" for the default.
+	XMLVariant string `json:"xml_variant"`
+}
+
+func (m responseModes) validate() error {
+	if m.UnknownPathStatus != 0 && (m.UnknownPathStatus < 100 || m.UnknownPathStatus > 599) {
+		return fmt.Errorf("invalid unknown_path_status %d", m.UnknownPathStatus)
+	}
+	if err := validXMLVariant(m.XMLVariant); err != nil {
+		return fmt.Errorf("invalid xml_variant: %v", err)
+	}
+	return nil
+}
+
+// responseModes returns the current response modes.
+func (s *SSRFSheriffRouter) responseModes() responseModes {
+	return *s.modes.Load()
+}
+
+// ModesHandler returns the current response modes on GET, and on PATCH
+// updates the ones present in the JSON body and returns the result.
+func (s *SSRFSheriffRouter) ModesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		// Fields missing from the body keep their current value.
+		modes := s.responseModes()
+		if err := json.NewDecoder(r.Body).Decode(&modes); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := modes.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.modes.Store(&modes)
+		s.logger.Info("Changed response modes",
+			zap.String("IP", r.RemoteAddr),
+			zap.Any("Modes", modes),
+		)
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, _ := json.Marshal(s.responseModes())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
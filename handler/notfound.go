@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// notFoundTemplate is the page served for unknown paths when
+// http.unknown_path_status is set. It is looked up in the host's templates
+// directory like any other template.
+const notFoundTemplate = "404.html"
+
+// defaultNotFoundPage is served when no notFoundTemplate can be found.
+const defaultNotFoundPage = "<html>\r\n<head><title>404 Not Found</title></head>\r\n<body>\r\n<center><h1>404 Not Found</h1></center>\r\n<hr><center>nginx</center>\r\n</body>\r\n</html>\r\n"
+
+// loadUnknownPathStatus reads http.unknown_path_status. Zero keeps the
+// original behavior of answering every path with the token.
+func loadUnknownPathStatus(cfg config.Provider) (int, error) {
+	var status int
+	if err := cfg.Get("http.unknown_path_status").Populate(&status); err != nil {
+		return 0, fmt.Errorf("failed to load http.unknown_path_status: %v", err)
+	}
+	if status != 0 && (status < 100 || status > 599) {
+		return 0, fmt.Errorf("invalid http.unknown_path_status %d", status)
+	}
+	return status, nil
+}
+
+// handedOut reports whether the request is for a URL the sheriff handed out,
+// which is answered normally even when http.unknown_path_status is set: one
+// carrying a minted or per-request token, a target ID or a generation of the
+// secret token in its path, or a minted token or target ID in its Host, such
+// as the extensionless /<token>/callback URL returned by /new.
+func (s *SSRFSheriffRouter) handedOut(r *http.Request) bool {
+	if _, ok := s.tokens.match(r); ok {
+		return true
+	}
+	if _, ok := s.tokens.matchLabels(zoneLabels(r.Host, s.targets.zone)); ok {
+		return true
+	}
+	if _, ok := s.targets.match(r); ok {
+		return true
+	}
+	if _, ok := s.generations.carriedBy(r); ok {
+		return true
+	}
+	if s.requestTokens != nil {
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if idx := strings.IndexByte(segment, '.'); idx >= 0 {
+				segment = segment[:idx]
+			}
+			if _, ok := s.requestTokens.lookup(segment); ok && segment != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveUnknownPath answers a request for a path that doesn't map to any token
+// format, and wasn't handed out, with the given status and a plain error page, so the sheriff
+// blends in as an ordinary web server. The request is still a callback and is
+// logged as one.
+func (s *SSRFSheriffRouter) serveUnknownPath(w http.ResponseWriter, r *http.Request, profile hostProfile, status int) {
+	s.logger.Info("Callback on unknown path",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Token", profile.Token),
+		zap.Int("Status", status),
+	)
+
+	body, ok := s.renderTemplate(r, profile, notFoundTemplate)
+	if !ok {
+		body = defaultNotFoundPage
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.writeResponse(w, r, status, []byte(body))
+}
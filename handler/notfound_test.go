@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandedOut(t *testing.T) {
+	s := &SSRFSheriffRouter{
+		tokens:        newTokenRegistry(time.Hour),
+		targets:       &targetRegistry{prefix: "/t", zone: "sheriff.test", targets: map[string]target{"tgt": {ID: "tgt"}}},
+		generations:   &tokenGenerations{},
+		requestTokens: &requestTokens{secret: []byte("secret"), issued: make(map[string]issuedToken)},
+	}
+	s.generations.add("SECRET", time.Time{}, time.Time{})
+	minted, _, err := s.tokens.mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := s.requestTokens.issue(httptest.NewRequest("GET", "/first", nil))
+
+	tests := []struct {
+		host, path string
+		want       bool
+	}{
+		{"sheriff.test", "/" + minted + "/callback", true},
+		{"sheriff.test", "/" + minted + "/callback.json", true},
+		{minted + ".sheriff.test", "/anything", true},
+		{"sheriff.test", "/t/tgt/anything", true},
+		{"tgt.sheriff.test", "/anything", true},
+		{"sheriff.test", "/x/" + issued, true},
+		{"sheriff.test", "/callback?token=SECRET", true},
+		{"sheriff.test", "/wp-admin", false},
+		{"sheriff.test", "/t/unknown/anything", false},
+		{"sheriff.test", "/0123456789abcdef0123456789abcdef/callback", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Host = tt.host
+		if got := s.handedOut(r); got != tt.want {
+			t.Errorf("handedOut(%s%s) = %v, want %v", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/notifier"
+	"github.com/teknogeek/ssrf-sheriff/storage"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// defaultWebhookTimeout bounds each webhook delivery unless
+// notifications.timeout is configured.
+const defaultWebhookTimeout = 10 * time.Second
+
+// NewWebhooks builds the webhook notifier configured in
+// notifications.webhooks and notifications.routes, and exports its queue
+// depth and drops as metrics. It returns nil if no webhooks are configured.
+func NewWebhooks(cfg config.Provider, lc fx.Lifecycle, logger *zap.Logger, metrics *Metrics) (*notifier.Webhooks, error) {
+	raw := struct {
+		Webhooks  []string      `yaml:"webhooks"`
+		Timeout   time.Duration `yaml:"timeout"`
+		Workers   int           `yaml:"workers"`
+		QueueSize int           `yaml:"queue_size"`
+		WhenFull  string        `yaml:"when_full"`
+		Routes    []struct {
+			TokenPrefix string   `yaml:"token_prefix"`
+			Webhooks    []string `yaml:"webhooks"`
+		} `yaml:"routes"`
+	}{
+		Timeout:   defaultWebhookTimeout,
+		Workers:   notifier.DefaultWorkers,
+		QueueSize: notifier.DefaultQueueSize,
+		WhenFull:  "drop",
+	}
+	if err := cfg.Get("notifications").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load notifications: %v", err)
+	}
+	if len(raw.Webhooks) == 0 && len(raw.Routes) == 0 {
+		return nil, nil
+	}
+	if raw.Workers <= 0 || raw.QueueSize <= 0 {
+		return nil, fmt.Errorf("notifications.workers and notifications.queue_size must be positive")
+	}
+	if raw.WhenFull != "drop" && raw.WhenFull != "block" {
+		return nil, fmt.Errorf("invalid notifications.when_full %q: must be drop or block", raw.WhenFull)
+	}
+
+	routes := make([]notifier.Route, 0, len(raw.Routes))
+	for _, route := range raw.Routes {
+		routes = append(routes, notifier.Route{TokenPrefix: route.TokenPrefix, URLs: route.Webhooks})
+	}
+
+	webhooks, err := notifier.NewWebhooks(raw.Webhooks, notifier.Options{
+		Timeout:   raw.Timeout,
+		Workers:   raw.Workers,
+		QueueSize: raw.QueueSize,
+		Block:     raw.WhenFull == "block",
+		Routes:    routes,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	metrics.watchNotifier(webhooks)
+	lc.Append(fx.Hook{OnStart: webhooks.Start, OnStop: webhooks.Stop})
+	return webhooks, nil
+}
+
+// notify sends the callback to the configured webhooks, if any. In canary
+// mode only echoed tokens are sent, see detectEchoedToken.
+func (s *SSRFSheriffRouter) notify(r *http.Request, token, target string) {
+	if s.webhooks == nil || s.canary.enabled {
+		return
+	}
+	s.webhooks.Notify(notifier.Event{
+		Type:    notifier.EventCallback,
+		Time:    time.Now().UTC(),
+		IP:      clientIP(r),
+		Method:  r.Method,
+		Host:    r.Host,
+		Path:    r.URL.Path,
+		Token:   token,
+		Headers: r.Header,
+		Target:  target,
+		Session: requestSession(r),
+	})
+}
+
+// notifyHit sends a hit that didn't come from an HTTP request, such as a DNS
+// lookup, to the configured webhooks, if any, like notify.
+func (s *SSRFSheriffRouter) notifyHit(hit storage.Hit) {
+	if s.webhooks == nil || s.canary.enabled {
+		return
+	}
+	s.webhooks.Notify(notifier.Event{
+		Type:         notifier.EventCallback,
+		Time:         hit.Time,
+		IP:           hit.IP,
+		Method:       hit.Method,
+		Host:         hit.Host,
+		Path:         hit.Path,
+		Token:        hit.Token,
+		Target:       hit.Target,
+		Data:         hit.Data,
+		DataEncoding: hit.DataEncoding,
+	})
+}
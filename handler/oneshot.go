@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// UntilCallback is supplied by the -until-callback flag to stop after the
+// first callback regardless of http.stop_after_callbacks.
+type UntilCallback bool
+
+// callbackCounter counts callbacks and signals once a limit is reached.
+type callbackCounter struct {
+	limit int
+
+	mu      sync.Mutex
+	count   int
+	reached chan struct{}
+}
+
+func newCallbackCounter() *callbackCounter {
+	return &callbackCounter{reached: make(chan struct{})}
+}
+
+// record counts a callback. It does nothing until a limit is set.
+func (c *callbackCounter) record() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limit == 0 {
+		return
+	}
+	c.count++
+	if c.count == c.limit {
+		close(c.reached)
+	}
+}
+
+// StopAfterCallbacksParams are the dependencies of StopAfterCallbacks.
+type StopAfterCallbacksParams struct {
+	fx.In
+
+	Router     *SSRFSheriffRouter
+	Config     config.Provider
+	Lifecycle  fx.Lifecycle
+	Logger     *zap.Logger
+	Shutdowner fx.Shutdowner
+
+	UntilCallback UntilCallback `optional:"true"`
+}
+
+// StopAfterCallbacks shuts the application down with exit code 0 once
+// http.stop_after_callbacks callbacks have been answered, so a CI pipeline
+// can start the sheriff, run a scan and wait for it to exit. If
+// http.stop_timeout passes first, it exits with code 1 instead.
+func StopAfterCallbacks(p StopAfterCallbacksParams) error {
+	var limit int
+	if err := p.Config.Get("http.stop_after_callbacks").Populate(&limit); err != nil {
+		return fmt.Errorf("failed to load http.stop_after_callbacks: %v", err)
+	}
+	var timeout time.Duration
+	if err := p.Config.Get("http.stop_timeout").Populate(&timeout); err != nil {
+		return fmt.Errorf("failed to load http.stop_timeout: %v", err)
+	}
+	if p.UntilCallback {
+		limit = 1
+	}
+	if limit < 0 || timeout < 0 {
+		return fmt.Errorf("http.stop_after_callbacks and http.stop_timeout must not be negative")
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	counter := p.Router.callbacks
+	counter.mu.Lock()
+	counter.limit = limit
+	counter.mu.Unlock()
+
+	stop := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				var expired <-chan time.Time
+				if timeout > 0 {
+					timer := time.NewTimer(timeout)
+					defer timer.Stop()
+					expired = timer.C
+				}
+
+				select {
+				case <-counter.reached:
+					p.Logger.Info("Received expected callbacks, shutting down", zap.Int("Callbacks", limit))
+					p.Shutdowner.Shutdown(fx.ExitCode(0))
+				case <-expired:
+					p.Logger.Error("Timed out waiting for callbacks",
+						zap.Int("Expected", limit),
+						zap.Duration("Timeout", timeout),
+					)
+					p.Shutdowner.Shutdown(fx.ExitCode(1))
+				case <-stop:
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(stop)
+			return nil
+		},
+	})
+	return nil
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// internalPaths are the paths served by the sheriff itself rather than
+// answered as SSRF callbacks. Requests to them are never logged, counted or
+// otherwise treated as callbacks. Each path can be renamed in config in case
+// it collides with a path an SSRF target needs to fetch.
+type internalPaths struct {
+	Health       string `yaml:"health"`
+	Version      string `yaml:"version"`
+	Raw          string `yaml:"raw"`
+	UserAgents   string `yaml:"user_agents"`
+	NewToken     string `yaml:"new_token"`
+	Sessions     string `yaml:"sessions"`
+	HAR          string `yaml:"har"`
+	VerifyToken  string `yaml:"verify_token"`
+	Hits         string `yaml:"hits"`
+	Interactions string `yaml:"interactions"`
+}
+
+// defaultInternalPaths are used for any internal path that isn't configured.
+var defaultInternalPaths = internalPaths{
+	Health:       "/healthz",
+	Version:      "/version",
+	Raw:          "/raw",
+	UserAgents:   "/api/useragents",
+	NewToken:     "/new",
+	Sessions:     "/api/sessions",
+	HAR:          "/api/har",
+	VerifyToken:  "/api/tokens/verify",
+	Hits:         "/api/hits",
+	Interactions: "/api/interactions",
+}
+
+// loadInternalPaths reads internal_paths from config, filling in defaults.
+func loadInternalPaths(cfg config.Provider) (internalPaths, error) {
+	paths := defaultInternalPaths
+	if err := cfg.Get("internal_paths").Populate(&paths); err != nil {
+		return paths, fmt.Errorf("failed to load internal_paths: %v", err)
+	}
+
+	for _, p := range paths.all() {
+		if !strings.HasPrefix(p, "/") {
+			return paths, fmt.Errorf("internal path %q must start with /", p)
+		}
+	}
+	return paths, nil
+}
+
+func (p internalPaths) all() []string {
+	return []string{p.Health, p.Version, p.Raw, p.UserAgents, p.NewToken, p.Sessions, p.HAR, p.VerifyToken, p.Hits, p.Interactions}
+}
+
+// contains reports whether path is one of the internal paths. Paths left
+// empty are disabled and match nothing.
+func (p internalPaths) contains(path string) bool {
+	for _, internal := range p.all() {
+		if internal != "" && path == internal {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthHandler reports that the sheriff is up.
+func (s *SSRFSheriffRouter) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
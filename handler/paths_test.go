@@ -0,0 +1,17 @@
+package handler
+
+import "testing"
+
+func TestDisabledInternalPathsMatchNothing(t *testing.T) {
+	paths := defaultInternalPaths
+	paths.Raw = ""
+
+	for _, path := range []string{"/raw", ""} {
+		if paths.contains(path) {
+			t.Errorf("contains(%q) = true with raw capture off", path)
+		}
+	}
+	if !paths.contains("/healthz") {
+		t.Error("contains(/healthz) = false")
+	}
+}
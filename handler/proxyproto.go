@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"go.uber.org/config"
+)
+
+// loadProxyPolicy reads the proxy_protocol section. It returns nil if the
+// PROXY protocol is disabled.
+func loadProxyPolicy(cfg config.Provider) (*proxyproto.Policy, error) {
+	var enabled bool
+	if err := cfg.Get("proxy_protocol.enabled").Populate(&enabled); err != nil {
+		return nil, fmt.Errorf("failed to load proxy_protocol.enabled: %v", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	trusted, err := loadNetworks(cfg, "proxy_protocol.trusted")
+	if err != nil {
+		return nil, err
+	}
+	return &proxyproto.Policy{Trusted: trusted}, nil
+}
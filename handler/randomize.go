@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// randomInt returns a uniformly random int in [0, n).
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(v.Int64())
+}
+
+// randomNonce returns a random hex string.
+func randomNonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// randomWhitespace returns one of a few whitespace styles used to vary the
+// layout of serialized responses.
+func randomWhitespace() (newline, indent string) {
+	switch randomInt(3) {
+	case 0:
+		return "", ""
+	case 1:
+		return "\n", "  "
+	default:
+		return "\n", "\t"
+	}
+}
+
+// randomizedJSON serializes the token alongside a random nonce, in a random
+// key order and with random whitespace. The token always stays under the
+// "token" key.
+func randomizedJSON(token string) string {
+	tokenValue, _ := json.Marshal(token)
+	fields := []string{
+		`"token":` + string(tokenValue),
+		fmt.Sprintf(`"nonce":"%s"`, randomNonce()),
+	}
+	if randomInt(2) == 0 {
+		fields[0], fields[1] = fields[1], fields[0]
+	}
+
+	newline, indent := randomWhitespace()
+	var buf strings.Builder
+	buf.WriteString("{" + newline)
+	for i, field := range fields {
+		buf.WriteString(indent + field)
+		if i < len(fields)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(newline)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// randomizedXML serializes the token alongside a random nonce, with the
+// elements in a random order and random whitespace. The token always stays
+// in the <token> element of the SerializableResponse root.
+func randomizedXML(token string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(token))
+
+	elements := []string{
+		"<token>" + escaped.String() + "</token>",
+		"<nonce>" + randomNonce() + "</nonce>",
+	}
+	if randomInt(2) == 0 {
+		elements[0], elements[1] = elements[1], elements[0]
+	}
+
+	newline, indent := randomWhitespace()
+	var buf strings.Builder
+	buf.WriteString("<SerializableResponse>" + newline)
+	for _, element := range elements {
+		buf.WriteString(indent + element + newline)
+	}
+	buf.WriteString("</SerializableResponse>")
+	return buf.String()
+}
+
+// setRandomizedHeaders adds headers whose values change on every response.
+//
+// net/http always writes headers sorted by name, so their order can't be
+// varied without hijacking the connection. A random ETag changes the header
+// block without adding anything unusual to it.
+func setRandomizedHeaders(w http.ResponseWriter) {
+	w.Header().Set("ETag", `"`+randomNonce()+`"`)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+)
+
+// defaultRawCaptureBytes is the per-request capture limit used when
+// raw_capture.max_bytes isn't set.
+const defaultRawCaptureBytes = 64 * 1024
+
+// maxRawClients bounds the number of clients whose last raw request is kept.
+const maxRawClients = 1024
+
+// rawRequestStore keeps the most recent raw request received from each
+// client IP, evicting the oldest clients once maxRawClients is reached.
+type rawRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]rawRequest
+	order    []string
+}
+
+// rawRequest is a raw request along with who sent it and when.
+type rawRequest struct {
+	IP       string
+	Received time.Time
+	Raw      []byte
+}
+
+func newRawRequestStore() *rawRequestStore {
+	return &rawRequestStore{requests: make(map[string]rawRequest)}
+}
+
+func (st *rawRequestStore) put(ip string, received time.Time, raw []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.requests[ip]; !ok {
+		if len(st.order) >= maxRawClients {
+			delete(st.requests, st.order[0])
+			st.order = st.order[1:]
+		}
+		st.order = append(st.order, ip)
+	}
+	st.requests[ip] = rawRequest{IP: ip, Received: received, Raw: raw}
+}
+
+func (st *rawRequestStore) get(ip string) ([]byte, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	req, ok := st.requests[ip]
+	return req.Raw, ok
+}
+
+type rawBytesKey struct{}
+
+// rawRequestBytes returns the raw bytes captured for the request, if any.
+func rawRequestBytes(r *http.Request) []byte {
+	raw, _ := r.Context().Value(rawBytesKey{}).([]byte)
+	return raw
+}
+
+// rawCaptureMiddleware records the raw bytes of every request, except those
+// made to internal paths, and makes them available to handlers through
+// rawRequestBytes.
+func (s *SSRFSheriffRouter) rawCaptureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := httpserver.RawBytes(r.Context())
+		if !s.internalPaths.contains(r.URL.Path) && len(raw) > 0 {
+			s.rawRequests.put(clientIP(r), time.Now(), raw)
+			r = r.WithContext(context.WithValue(r.Context(), rawBytesKey{}, raw))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RawHandler returns the most recent raw request received from the client IP
+// given in the ip query parameter, or from the caller if it is omitted.
+func (s *SSRFSheriffRouter) RawHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	ip := r.URL.Query().Get("ip")
+	if ip == "" {
+		ip = clientIP(r)
+	}
+
+	raw, ok := s.rawRequests.get(ip)
+	if !ok {
+		http.Error(w, "no raw request captured for "+ip, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(raw)
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+type proxyAddrKey struct{}
+
+// loadTrustedProxies parses http.trusted_proxies, a list of CIDRs or single
+// IP addresses whose X-Forwarded-For and X-Real-IP headers are believed.
+func loadTrustedProxies(cfg config.Provider) ([]*net.IPNet, error) {
+	return loadNetworks(cfg, "http.trusted_proxies")
+}
+
+// loadNetworks parses the list of CIDRs or single IP addresses at key.
+func loadNetworks(cfg config.Provider, key string) ([]*net.IPNet, error) {
+	var entries []string
+	if err := cfg.Get(key).Populate(&entries); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", key, err)
+	}
+
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q in %s", entry, key)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q in %s: %v", entry, key, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// fromUnixSocket reports whether the request came in on a unix socket
+// listener.
+func fromUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func (s *SSRFSheriffRouter) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIPMiddleware replaces the RemoteAddr of requests relayed by a trusted
+// proxy with the address of the client the proxy is relaying for, so it is
+// what gets logged, recorded and matched against allowed sources. That is
+// the rightmost X-Forwarded-For address that isn't itself a trusted proxy,
+// or X-Real-IP if there is no X-Forwarded-For. The proxy's own address is
+// kept for the logs. Requests on a unix socket can only come from a local
+// reverse proxy, so they are trusted too.
+func (s *SSRFSheriffRouter) realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.trustedProxy(clientIP(r)) && !fromUnixSocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := ""
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(forwarded[i])
+			if net.ParseIP(addr) == nil {
+				break
+			}
+			origin = addr
+			if !s.trustedProxy(addr) {
+				break
+			}
+		}
+		if origin == "" {
+			if addr := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(addr) != nil {
+				origin = addr
+			}
+		}
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		proxy := r.RemoteAddr
+		r = r.WithContext(context.WithValue(r.Context(), proxyAddrKey{}, proxy))
+		r.RemoteAddr = origin
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyFields returns the log field holding the address of the proxy that
+// relayed the request, if a trusted proxy did, either over HTTP or with the
+// PROXY protocol.
+func proxyFields(r *http.Request) []zap.Field {
+	if proxy, ok := r.Context().Value(proxyAddrKey{}).(string); ok {
+		return []zap.Field{zap.String("Proxy", proxy)}
+	}
+	if proxy := httpserver.ProxyAddr(r.Context()); proxy != nil {
+		return []zap.Field{zap.Stringer("Proxy", proxy)}
+	}
+	return nil
+}
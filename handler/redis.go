@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/redisserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewRedisServer builds the Redis honeypot configured in the redis section,
+// which answers with the secret token and logs every command. Sources outside
+// security.allowed_source_cidrs get the decoy token. It returns nil if
+// redis.address isn't set.
+func NewRedisServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*redisserver.Server, error) {
+	var raw struct {
+		Address string `yaml:"address"`
+	}
+	if err := cfg.Get("redis").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load redis: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return redisserver.New(redisserver.Config{
+		Addr:  raw.Address,
+		Token: s.sourceToken("Redis"),
+		Proxy: proxy,
+	}, logger), nil
+}
+
+// StartRedisServer starts the Redis honeypot, if one is configured.
+func StartRedisServer(srv *redisserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// internalHostSuffixes are DNS suffixes commonly used for hosts that are only
+// reachable from inside a network.
+var internalHostSuffixes = []string{".internal", ".local", ".localdomain", ".corp", ".lan", ".intranet", ".home.arpa"}
+
+// internalHost reports whether host (without a port) names a loopback,
+// private or link-local address, or looks like an internal hostname.
+func internalHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+	}
+	if host == "localhost" || !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range internalHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalReferrer reports whether a Referer or Origin header value points at
+// an internal host.
+func internalReferrer(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return internalHost(u.Hostname())
+}
+
+// logReferrers logs the Referer and Origin of a callback. They can reveal the
+// page that triggered the fetch, so a callback referred from an internal host
+// is logged as a warning.
+func (s *SSRFSheriffRouter) logReferrers(r *http.Request) {
+	referer, origin := r.Referer(), r.Header.Get("Origin")
+	if referer == "" && origin == "" {
+		return
+	}
+
+	internal := internalReferrer(referer) || internalReferrer(origin)
+	fields := []zap.Field{
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Referer", referer),
+		zap.String("Origin", origin),
+		zap.Bool("Internal Referrer", internal),
+	}
+	if internal {
+		s.logger.Warn("Callback referred from an internal host", fields...)
+		return
+	}
+	s.logger.Info("Callback with referrer", fields...)
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// handoffReadyTimeout is how long a replacement process has to start serving
+// before a reload is given up and this process carries on.
+const handoffReadyTimeout = 30 * time.Second
+
+// ReloadTrigger requests a graceful reload, just like a reload signal does.
+// It is how the admin API asks for one.
+type ReloadTrigger chan struct{}
+
+// NewReloadTrigger returns a ReloadTrigger that holds one pending request.
+func NewReloadTrigger() ReloadTrigger {
+	return make(ReloadTrigger, 1)
+}
+
+// request asks for a reload without waiting for it. It returns false if one
+// is already pending.
+func (t ReloadTrigger) request() bool {
+	select {
+	case t <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchReload waits for one of the platform's reload signals, or a request on
+// trigger, and then hands the listening sockets of the given handles off to a
+// freshly started copy of the sheriff, shutting this process down once it is
+// serving. Only HTTP sockets can be handed off, so reloads are refused while
+// any of the servers named in unhanded, which would fail to listen again in
+// the copy, are enabled. The returned function stops watching.
+func watchReload(logger *zap.Logger, shutdowner fx.Shutdowner, trigger ReloadTrigger, unhanded []string, handles ...*httpserver.Handle) func() {
+	sigCh := make(chan os.Signal, 1)
+	doneCh := make(chan struct{})
+	if len(reloadSignals) > 0 {
+		signal.Notify(sigCh, reloadSignals...)
+	}
+
+	go func() {
+		for {
+			var cause zap.Field
+			select {
+			case <-doneCh:
+				return
+			case sig := <-sigCh:
+				cause = zap.Stringer("Signal", sig)
+			case <-trigger:
+				cause = zap.String("Signal", "admin API")
+			}
+
+			if len(unhanded) > 0 {
+				logger.Error("Graceful reload refused, restart the sheriff instead",
+					cause,
+					zap.Strings("Servers Without Handoff", unhanded),
+				)
+				continue
+			}
+
+			proc, err := httpserver.Handoff(handoffReadyTimeout, handles...)
+			if err != nil {
+				logger.Error("Graceful reload failed", cause, zap.Error(err))
+				continue
+			}
+
+			logger.Info("New process is serving, draining connections",
+				cause,
+				zap.Int("PID", proc.Pid),
+			)
+			if err := shutdowner.Shutdown(); err != nil {
+				logger.Error("Failed to shut down after reload", zap.Error(err))
+			}
+			return
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// NotifyReady tells the process this one replaces in a graceful reload that
+// every server has started, so it can drain and exit. It must be the last
+// invoke, so that its start hook runs after every other.
+func NotifyReady(lc fx.Lifecycle, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			if err := httpserver.NotifyReady(); err != nil {
+				logger.Error("Failed to report readiness after reload", zap.Error(err))
+			}
+			return nil
+		},
+	})
+}
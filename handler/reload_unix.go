@@ -0,0 +1,11 @@
+//go:build !windows
+
+package handler
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals trigger a graceful reload through listener handoff.
+var reloadSignals = []os.Signal{syscall.SIGUSR2}
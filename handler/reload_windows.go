@@ -0,0 +1,7 @@
+package handler
+
+import "os"
+
+// reloadSignals is empty on Windows, which cannot pass listening sockets to
+// child processes.
+var reloadSignals []os.Signal
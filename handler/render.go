@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// templateData is what text templates are rendered with.
+type templateData struct {
+	// Token is the token served for the request.
+	Token string
+	// RemoteIP is the client's IP address, without its port.
+	RemoteIP string
+	// Path is the requested path.
+	Path string
+	// Host is the requested host, and Scheme http or https.
+	Host   string
+	Scheme string
+	// BaseURL is Scheme://Host, for building links back to the sheriff.
+	BaseURL string
+	// Timestamp is when the request was answered, in UTC. It prints like
+	// time.Time.String and can be formatted with {{.Timestamp.Format ...}}.
+	Timestamp time.Time
+}
+
+func newTemplateData(r *http.Request, token string) templateData {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return templateData{
+		Token:     token,
+		RemoteIP:  clientIP(r),
+		Path:      r.URL.Path,
+		Host:      r.Host,
+		Scheme:    scheme,
+		BaseURL:   scheme + "://" + r.Host,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// escaped returns the data with its string fields passed through escape, or
+// unchanged if escape is nil. Host, and so BaseURL, come straight from the
+// request, so a client could otherwise inject markup or break out of a JSON
+// string.
+func (d templateData) escaped(escape func(string) string) templateData {
+	if escape == nil {
+		return d
+	}
+	d.Token = escape(d.Token)
+	d.RemoteIP = escape(d.RemoteIP)
+	d.Path = escape(d.Path)
+	d.Host = escape(d.Host)
+	d.Scheme = escape(d.Scheme)
+	d.BaseURL = escape(d.BaseURL)
+	return d
+}
+
+// templateEscaper returns how values are escaped in a response of the given
+// Content-Type: as the contents of a JSON string for JSON, as character data
+// or an attribute value for XML and HTML, and not at all for anything else.
+func templateEscaper(contentType string) func(string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return jsonEscape
+	case mediaType == "text/html" || mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return xmlEscape
+	}
+	return nil
+}
+
+// jsonEscape escapes s for use inside a JSON string.
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// xmlEscape escapes s for use as XML or HTML text or attribute value.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// customTemplateName returns the name of the template served for a file
+// extension without a built-in handler, following the naming of the built-in
+// templates: "yaml.yaml" for ".yaml".
+func customTemplateName(fileExtension string) string {
+	return strings.TrimPrefix(fileExtension, ".") + fileExtension
+}
+
+// renderTemplate renders the named text template from the profile's templates
+// directory, or the embedded templates, for the request, escaping the values
+// it is rendered with for the format its extension names. It returns false if
+// there is no such template. A template that fails to parse or execute is
+// logged and answered with the bare token, so the callback still carries it.
+func (s *SSRFSheriffRouter) renderTemplate(r *http.Request, profile hostProfile, name string) (string, bool) {
+	text := readTemplateFile(profile.Templates, name)
+	if text == "" {
+		return "", false
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err == nil {
+		var buf bytes.Buffer
+		escape := templateEscaper(mime.TypeByExtension(path.Ext(name)))
+		if err = tmpl.Execute(&buf, newTemplateData(r, profile.Token).escaped(escape)); err == nil {
+			return buf.String(), true
+		}
+	}
+
+	s.logger.Error("Failed to render template",
+		zap.String("Directory", profile.Templates),
+		zap.String("File", name),
+		zap.Error(err),
+	)
+	return profile.Token, true
+}
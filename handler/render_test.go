@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// hostileHost would break out of a JSON string or an XML element if it were
+// rendered unescaped.
+const hostileHost = `evil.example.com"}<x a='1'>&`
+
+func renderWithHost(t *testing.T, name string) string {
+	t.Helper()
+	s := &SSRFSheriffRouter{logger: zap.NewNop()}
+	r := httptest.NewRequest("GET", "/"+name, nil)
+	r.Host = hostileHost
+	body, ok := s.renderTemplate(r, hostProfile{Token: "tok"}, name)
+	if !ok {
+		t.Fatalf("no %s template", name)
+	}
+	return body
+}
+
+func TestTemplatesEscapeHostForJSON(t *testing.T) {
+	for _, name := range []string{"openapi.json", "swagger.json", "openid-configuration.json"} {
+		t.Run(name, func(t *testing.T) {
+			body := renderWithHost(t, name)
+			var doc interface{}
+			if err := json.Unmarshal([]byte(body), &doc); err != nil {
+				t.Fatalf("rendered document isn't valid JSON: %v", err)
+			}
+			if encoded, _ := json.Marshal(doc); !strings.Contains(string(encoded), jsonEscape(hostileHost)) {
+				t.Error("host lost in rendering")
+			}
+		})
+	}
+}
+
+func TestTemplatesEscapeHostForXML(t *testing.T) {
+	body := renderWithHost(t, "sitemap.xml")
+	if strings.Contains(body, "<x ") {
+		t.Fatalf("host injected markup:\n%s", body)
+	}
+	var sitemap struct {
+		URLs []string `xml:"url>loc"`
+	}
+	if err := xml.Unmarshal([]byte(body), &sitemap); err != nil {
+		t.Fatalf("rendered document isn't valid XML: %v", err)
+	}
+	if len(sitemap.URLs) == 0 || !strings.HasPrefix(sitemap.URLs[0], "http://"+hostileHost) {
+		t.Errorf("locations = %q, want them under http://%s", sitemap.URLs, hostileHost)
+	}
+}
+
+func TestTemplateEscaper(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"application/json", `a\"\u003cb\u003e`},
+		{"application/problem+json; charset=utf-8", `a\"\u003cb\u003e`},
+		{"text/html; charset=utf-8", "a&#34;&lt;b&gt;"},
+		{"application/xml", "a&#34;&lt;b&gt;"},
+		{"image/svg+xml", "a&#34;&lt;b&gt;"},
+		{"text/plain", `a"<b>`},
+		{"", `a"<b>`},
+	}
+	for _, tt := range tests {
+		got := templateData{Host: `a"<b>`}.escaped(templateEscaper(tt.contentType)).Host
+		if got != tt.want {
+			t.Errorf("%q: escaped to %q, want %q", tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+)
+
+// maxIssuedTokens bounds the number of per-request tokens remembered for the
+// verify endpoint. Older tokens can still be verified when their path is
+// known.
+const maxIssuedTokens = 10000
+
+// issuedToken records the request a per-request token was served to.
+type issuedToken struct {
+	Token  string    `json:"token"`
+	Path   string    `json:"path"`
+	IP     string    `json:"ip"`
+	Host   string    `json:"host"`
+	Issued time.Time `json:"issued"`
+}
+
+// requestTokens mints a unique token for every callback, derived as
+// HMAC(secret, path|timestamp), so a token seen later in an exfiltration
+// channel can be traced back to the exact probe that leaked it.
+type requestTokens struct {
+	secret []byte
+
+	mu     sync.Mutex
+	issued map[string]issuedToken
+	order  []string
+}
+
+// loadRequestTokens reads tokens.per_request. It returns nil unless
+// per-request tokens are enabled. Without a configured secret a random one
+// is used, so tokens can only be verified by this process.
+func loadRequestTokens(cfg config.Provider) (*requestTokens, error) {
+	var raw struct {
+		Enabled bool   `yaml:"enabled"`
+		Secret  string `yaml:"secret"`
+	}
+	if err := cfg.Get("tokens.per_request").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load tokens.per_request: %v", err)
+	}
+	if !raw.Enabled {
+		return nil, nil
+	}
+
+	secret := []byte(raw.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate per-request token secret: %v", err)
+		}
+	}
+	return &requestTokens{secret: secret, issued: make(map[string]issuedToken)}, nil
+}
+
+// sign returns the token for a request to path at the given time. Tokens are
+// the base-36 timestamp followed by the truncated HMAC.
+func (rt *requestTokens) sign(path string, issued time.Time) string {
+	ts := strconv.FormatInt(issued.UnixNano(), 36)
+	mac := hmac.New(sha256.New, rt.secret)
+	mac.Write([]byte(path + "|" + ts))
+	return ts + "-" + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// issue mints and remembers the token for r.
+func (rt *requestTokens) issue(r *http.Request) string {
+	now := time.Now()
+	token := rt.sign(r.URL.Path, now)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if len(rt.order) >= maxIssuedTokens {
+		delete(rt.issued, rt.order[0])
+		rt.order = rt.order[1:]
+	}
+	rt.issued[token] = issuedToken{Token: token, Path: r.URL.Path, IP: clientIP(r), Host: r.Host, Issued: now.UTC()}
+	rt.order = append(rt.order, token)
+	return token
+}
+
+// lookup returns the request token was issued to, if it is still
+// remembered.
+func (rt *requestTokens) lookup(token string) (issuedToken, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	issued, ok := rt.issued[token]
+	return issued, ok
+}
+
+// verify reports whether token was issued for a request to path, without
+// needing to remember it.
+func (rt *requestTokens) verify(token, path string) (time.Time, bool) {
+	ts, _, ok := strings.Cut(token, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(ts, 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	issued := time.Unix(0, nanos)
+	return issued.UTC(), hmac.Equal([]byte(rt.sign(path, issued)), []byte(token))
+}
+
+type verifyTokenResponse struct {
+	Valid   bool         `json:"valid"`
+	Request *issuedToken `json:"request,omitempty"`
+}
+
+// VerifyTokenHandler reports which request a per-request token given in the
+// token query parameter was served to. Tokens that are no longer remembered
+// can be checked against a candidate path given in the path query parameter.
+func (s *SSRFSheriffRouter) VerifyTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.requestTokens == nil {
+		http.Error(w, "per-request tokens are disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	token := query.Get("token")
+
+	var res verifyTokenResponse
+	if issued, ok := s.requestTokens.lookup(token); ok {
+		res = verifyTokenResponse{Valid: true, Request: &issued}
+	} else if path := query.Get("path"); path != "" {
+		if at, ok := s.requestTokens.verify(token, path); ok {
+			res = verifyTokenResponse{Valid: true, Request: &issuedToken{Token: token, Path: path, Issued: at}}
+		}
+	}
+
+	body, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestTokensVerifyByPath(t *testing.T) {
+	rt := &requestTokens{secret: []byte("secret"), issued: make(map[string]issuedToken)}
+	r := httptest.NewRequest("GET", "/probe/1", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	token := rt.issue(r)
+	if other := rt.issue(r); other == token {
+		t.Error("two requests got the same token")
+	}
+	if issued, ok := rt.lookup(token); !ok || issued.Path != "/probe/1" || issued.IP != "192.0.2.1" {
+		t.Errorf("lookup = %+v, %v, want the request it was issued to", issued, ok)
+	}
+
+	// A restarted sheriff with the same secret can still verify it.
+	restarted := &requestTokens{secret: []byte("secret")}
+	if _, ok := restarted.verify(token, "/probe/1"); !ok {
+		t.Error("token doesn't verify for its path")
+	}
+	if _, ok := restarted.verify(token, "/probe/2"); ok {
+		t.Error("token verifies for another path")
+	}
+	if _, ok := (&requestTokens{secret: []byte("other")}).verify(token, "/probe/1"); ok {
+		t.Error("token verifies with another secret")
+	}
+	for _, bad := range []string{"", "nodash", "!!-00"} {
+		if _, ok := restarted.verify(bad, "/probe/1"); ok {
+			t.Errorf("verify(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// responseLimits guards against the sheriff being used as a bandwidth
+// amplifier by features that can produce large bodies.
+type responseLimits struct {
+	// MaxBytes is the largest body that will be written. Zero disables the
+	// check.
+	MaxBytes int `yaml:"max_bytes"`
+
+	// Truncate cuts oversized bodies down to MaxBytes instead of rejecting
+	// them with a 413.
+	Truncate bool `yaml:"truncate"`
+}
+
+// writeResponse runs the body through the configured transforms and writes
+// it with the status, enforcing responses.max_bytes and compressing it if the
+// client accepts a configured encoding. Headers must already be set on w.
+func (s *SSRFSheriffRouter) writeResponse(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	body, ok := s.limitResponse(w, r, body)
+	if !ok {
+		return
+	}
+
+	body, err := s.compressResponse(w, r, body)
+	if err != nil {
+		s.logger.Error("Failed to compress response",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.Error(err),
+		)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// limitResponse runs the body through the configured transforms and enforces
+// responses.max_bytes. If the body can't be sent, it writes an error response
+// and returns false.
+func (s *SSRFSheriffRouter) limitResponse(w http.ResponseWriter, r *http.Request, body []byte) ([]byte, bool) {
+	body, err := s.transforms.Apply(body, r)
+	if err != nil {
+		s.logger.Error("Failed to transform response",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.Error(err),
+		)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if max := s.responseLimits.MaxBytes; max > 0 && len(body) > max {
+		if !s.responseLimits.Truncate {
+			s.logger.Warn("Rejected oversized response",
+				zap.String("IP", r.RemoteAddr),
+				zap.String("Path", r.URL.Path),
+				zap.Int("Size", len(body)),
+				zap.Int("Max Bytes", max),
+			)
+			w.Header().Del("Content-Length")
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+
+		s.logger.Warn("Truncated oversized response",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Path", r.URL.Path),
+			zap.Int("Size", len(body)),
+			zap.Int("Max Bytes", max),
+		)
+		body = body[:max]
+	}
+	return body, true
+}
+
+// rangeExtensions are the formats served with support for Range requests,
+// which media players and video processing pipelines rely on.
+var rangeExtensions = map[string]bool{
+	".mp3": true,
+	".mp4": true,
+}
+
+// writeRangeResponse writes body like writeResponse with a 200, except that
+// it advertises Accept-Ranges and answers Range requests with 206 Partial
+// Content, or 416 if no requested range can be satisfied. The requested
+// ranges are logged with the request. The body is never compressed, since
+// ranges refer to the bytes of the media itself.
+func (s *SSRFSheriffRouter) writeRangeResponse(w http.ResponseWriter, r *http.Request, body []byte) {
+	body, ok := s.limitResponse(w, r, body)
+	if !ok {
+		return
+	}
+
+	if ranges := r.Header.Get("Range"); ranges != "" {
+		addLogFields(r, zap.String("Range", ranges))
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
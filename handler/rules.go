@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"text/template"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// pathRule answers the callbacks whose path matches it with a fixed response,
+// to emulate a specific internal service.
+type pathRule struct {
+	// Name identifies the rule in the logs. It defaults to the pattern.
+	Name string `yaml:"name"`
+	// Path is a path.Match pattern, e.g. "/latest/meta-data/*".
+	Path string `yaml:"path"`
+	// Regex is a regular expression matched against the path instead.
+	Regex string `yaml:"regex"`
+
+	Status      int               `yaml:"status"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+	// Body is a text template rendered like html.html.
+	Body  string        `yaml:"body"`
+	Delay time.Duration `yaml:"delay"`
+
+	regex *regexp.Regexp
+	body  *template.Template
+}
+
+func (rule *pathRule) matches(urlPath string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(urlPath)
+	}
+	ok, _ := path.Match(rule.Path, urlPath)
+	return ok
+}
+
+// loadPathRules reads the rules listed in the file at rules.file. Rules are
+// tried in the order they appear in the file.
+func loadPathRules(cfg config.Provider) ([]*pathRule, error) {
+	file := cfg.Get("rules.file").String()
+	if file == "" {
+		return nil, nil
+	}
+
+	provider, err := config.NewYAML(config.File(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules file: %v", err)
+	}
+	var rules []*pathRule
+	if err := provider.Get("rules").Populate(&rules); err != nil {
+		return nil, fmt.Errorf("failed to load rules from %q: %v", file, err)
+	}
+
+	for i, rule := range rules {
+		if (rule.Path == "") == (rule.Regex == "") {
+			return nil, fmt.Errorf("rule %d in %q must have exactly one of path and regex", i, file)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Path + rule.Regex
+		}
+		if rule.Regex != "" {
+			if rule.regex, err = regexp.Compile(rule.Regex); err != nil {
+				return nil, fmt.Errorf("invalid regex in rule %q: %v", rule.Name, err)
+			}
+		} else if _, err := path.Match(rule.Path, ""); err != nil {
+			return nil, fmt.Errorf("invalid path in rule %q: %v", rule.Name, err)
+		}
+		if rule.Status == 0 {
+			rule.Status = http.StatusOK
+		} else if rule.Status < 100 || rule.Status > 599 {
+			return nil, fmt.Errorf("invalid status %d in rule %q", rule.Status, rule.Name)
+		}
+		if rule.ContentType == "" {
+			rule.ContentType = "text/plain"
+		}
+		if rule.Delay < 0 {
+			return nil, fmt.Errorf("negative delay in rule %q", rule.Name)
+		}
+		if rule.body, err = template.New(rule.Name).Option("missingkey=error").Parse(rule.Body); err != nil {
+			return nil, fmt.Errorf("invalid body in rule %q: %v", rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// servePathRule answers the callback with the first rule matching its path.
+// It returns false if no rule matches.
+func (s *SSRFSheriffRouter) servePathRule(w http.ResponseWriter, r *http.Request, token string) bool {
+	var rule *pathRule
+	for _, candidate := range s.pathRules {
+		if candidate.matches(r.URL.Path) {
+			rule = candidate
+			break
+		}
+	}
+	if rule == nil {
+		return false
+	}
+
+	s.logger.Info("Callback matched rule",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("Rule", rule.Name),
+	)
+	if !sleep(r, rule.Delay) {
+		return true
+	}
+
+	var body bytes.Buffer
+	data := newTemplateData(r, token).escaped(templateEscaper(rule.ContentType))
+	if err := rule.body.Execute(&body, data); err != nil {
+		s.logger.Error("Failed to render rule body", zap.String("Rule", rule.Name), zap.Error(err))
+		body.Reset()
+		body.WriteString(token)
+	}
+
+	w.Header().Set("Content-Type", rule.ContentType)
+	for name, value := range rule.Headers {
+		w.Header().Set(name, value)
+	}
+	s.writeResponse(w, r, rule.Status, body.Bytes())
+	return true
+}
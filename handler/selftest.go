@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoding for the self-test
+	_ "image/png"  // register PNG decoding for the self-test
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/generators"
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// selfTestTimeout bounds each request made by the self-test.
+const selfTestTimeout = 10 * time.Second
+
+// selfTestCheck describes how a single format is verified by the self-test.
+type selfTestCheck struct {
+	ext string
+	// contentType must be contained in the response Content-Type.
+	contentType string
+	// verify checks the body, returning an error if the token couldn't be
+	// found or the body isn't a valid document of that format.
+	verify func(body []byte, token string) error
+}
+
+// selfTestChecks lists every format served by PathHandler. Generated media
+// carry the token, in their metadata or ID3 tags, and are checked for it.
+func selfTestChecks(opts generators.Options) []selfTestCheck {
+	var (
+		images = decodesAsImage
+		mp3    = notEmpty
+		mp4    = notEmpty
+	)
+	if !opts.Skip {
+		mp3 = containsToken
+		if opts.Metadata {
+			images = decodesAsImageWithToken
+			mp4 = containsToken
+		}
+	}
+
+	checks := []selfTestCheck{
+		{".json", "json", containsToken},
+		{".xml", "xml", containsToken},
+		{".html", "html", containsToken},
+		{".csv", "csv", containsToken},
+		{".txt", "text/plain", containsToken},
+		{"", "text/plain", containsToken},
+		{".png", "image/png", images},
+		{".jpg", "image/jpeg", images},
+		{".gif", "image/gif", notEmpty},
+		{".mp3", "audio/mpeg", mp3},
+		{".mp4", "video/mp4", mp4},
+	}
+	if !opts.Skip {
+		// There are no static PDF or SVG templates to fall back on.
+		checks = append(checks,
+			selfTestCheck{".pdf", "application/pdf", containsToken},
+			selfTestCheck{".svg", "image/svg+xml", containsToken},
+		)
+	}
+	if opts.Office {
+		checks = append(checks,
+			selfTestCheck{".docx", "wordprocessingml", zipContainsToken},
+			selfTestCheck{".xlsx", "spreadsheetml", zipContainsToken},
+		)
+	}
+	return checks
+}
+
+// selfTestClient returns a client that connects to the listener at addr, and
+// the base URL to request. A listener on an unspecified address is reached
+// over loopback, and a unix socket is dialed directly.
+func selfTestClient(addr net.Addr) (*http.Client, string, error) {
+	client := &http.Client{Timeout: selfTestTimeout}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip := addr.IP
+		if ip == nil || ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+			if addr.IP != nil && addr.IP.To4() == nil {
+				ip = net.IPv6loopback
+			}
+		}
+		return client, "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port)), nil
+	case *net.UnixAddr:
+		var d net.Dialer
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, addr.Net, addr.Name)
+			},
+		}
+		return client, "http://localhost", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported listener address %v", addr)
+	}
+}
+
+// RunSelfTest requests every supported format from the running server once
+// it has started, and verifies the status, Content-Type and token of each
+// response. If any format fails the application is shut down with a non-zero
+// exit code.
+func RunSelfTest(
+	h *httpserver.Handle,
+	cfg config.Provider,
+	lc fx.Lifecycle,
+	logger *zap.Logger,
+	shutdowner fx.Shutdowner,
+) error {
+	var opts generators.Options
+	if err := cfg.Get("generators").Populate(&opts); err != nil {
+		return fmt.Errorf("failed to load generators: %v", err)
+	}
+	token := cfg.Get("ssrf_token").String()
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			client, base, err := selfTestClient(h.Addr())
+			if err != nil {
+				return fmt.Errorf("self-test could not reach the server: %v", err)
+			}
+
+			go func() {
+				failed := 0
+				for _, check := range selfTestChecks(opts) {
+					if err := runSelfTestCheck(client, base, token, check); err != nil {
+						failed++
+						logger.Error("Self-test failed", zap.String("Format", check.ext), zap.Error(err))
+						continue
+					}
+					logger.Info("Self-test passed", zap.String("Format", check.ext))
+				}
+
+				if failed > 0 {
+					logger.Error("Self-test finished with failures", zap.Int("Failed", failed))
+					shutdowner.Shutdown(fx.ExitCode(1))
+					return
+				}
+				logger.Info("Self-test passed for all formats")
+			}()
+			return nil
+		},
+	})
+	return nil
+}
+
+func runSelfTestCheck(client *http.Client, base, token string, check selfTestCheck) error {
+	res, err := client.Get(base + "/selftest" + check.ext)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.Contains(ct, check.contentType) {
+		return fmt.Errorf("unexpected Content-Type %q, expected %q", ct, check.contentType)
+	}
+	return check.verify(body, token)
+}
+
+func notEmpty(body []byte, _ string) error {
+	if len(body) == 0 {
+		return fmt.Errorf("empty body, is the template missing?")
+	}
+	return nil
+}
+
+func containsToken(body []byte, token string) error {
+	if !bytes.Contains(body, []byte(token)) {
+		return fmt.Errorf("token not found in body")
+	}
+	return nil
+}
+
+func decodesAsImage(body []byte, _ string) error {
+	if err := notEmpty(body, ""); err != nil {
+		return err
+	}
+	if _, _, err := image.Decode(bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("body is not a valid image: %v", err)
+	}
+	return nil
+}
+
+func decodesAsImageWithToken(body []byte, token string) error {
+	if err := decodesAsImage(body, token); err != nil {
+		return err
+	}
+	return containsToken(body, token)
+}
+
+func zipContainsToken(body []byte, token string) error {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("body is not a valid zip archive: %v", err)
+	}
+
+	var escaped bytes.Buffer
+	if err := xml.EscapeText(&escaped, []byte(token)); err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, escaped.Bytes()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("token not found in any document part")
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfTestClientBaseURL(t *testing.T) {
+	tests := []struct {
+		addr *net.TCPAddr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 8000}, "http://127.0.0.1:8000"},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8000}, "http://[::1]:8000"},
+		{&net.TCPAddr{Port: 8000}, "http://127.0.0.1:8000"},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 8000}, "http://10.1.2.3:8000"},
+	}
+	for _, tt := range tests {
+		_, base, err := selfTestClient(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base != tt.want {
+			t.Errorf("base URL for %v = %q, want %q", tt.addr, base, tt.want)
+		}
+	}
+}
+
+func TestSelfTestClientDialsUnixSocket(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "http.sock"))
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client, base, err := selfTestClient(ln.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(base + "/selftest.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "/selftest.txt" {
+		t.Errorf("body = %q, want /selftest.txt", body)
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+)
+
+// defaultSessionIdleWindow is how long a client can go without a callback
+// before its next one starts a new session, unless sessions.idle_window is
+// configured.
+const defaultSessionIdleWindow = 30 * time.Second
+
+// maxSessions bounds the number of sessions kept for the sessions API.
+const maxSessions = 1024
+
+// session groups the callbacks made by one client IP in a burst, such as all
+// the resources fetched while rendering a single page.
+type session struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	Start     time.Time `json:"start"`
+	LastSeen  time.Time `json:"last_seen"`
+	Callbacks int       `json:"callbacks"`
+}
+
+// sessionTracker assigns callbacks to sessions, starting a new session for a
+// client once it has been idle for longer than the idle window.
+type sessionTracker struct {
+	idle time.Duration
+
+	mu       sync.Mutex
+	current  map[string]*session
+	sessions []*session
+}
+
+func newSessionTracker(idle time.Duration) *sessionTracker {
+	return &sessionTracker{idle: idle, current: make(map[string]*session)}
+}
+
+func loadSessionIdleWindow(cfg config.Provider) (time.Duration, error) {
+	idle := defaultSessionIdleWindow
+	if err := cfg.Get("sessions.idle_window").Populate(&idle); err != nil {
+		return 0, fmt.Errorf("failed to load sessions.idle_window: %v", err)
+	}
+	if idle < 0 {
+		return 0, fmt.Errorf("sessions.idle_window must not be negative, got %v", idle)
+	}
+	return idle, nil
+}
+
+// assign records a callback from ip and returns the ID of its session.
+func (t *sessionTracker) assign(ip string, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.current[ip]; ok && now.Sub(s.LastSeen) <= t.idle {
+		s.LastSeen = now
+		s.Callbacks++
+		return s.ID
+	}
+
+	if len(t.sessions) >= maxSessions {
+		oldest := t.sessions[0]
+		if t.current[oldest.IP] == oldest {
+			delete(t.current, oldest.IP)
+		}
+		t.sessions = t.sessions[1:]
+	}
+
+	s := &session{ID: randomNonce(), IP: ip, Start: now, LastSeen: now, Callbacks: 1}
+	t.current[ip] = s
+	t.sessions = append(t.sessions, s)
+	return s.ID
+}
+
+// find returns copies of the known sessions, newest first, optionally
+// filtered by session ID and client IP.
+func (t *sessionTracker) find(id, ip string) []session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found := []session{}
+	for i := len(t.sessions) - 1; i >= 0; i-- {
+		s := t.sessions[i]
+		if (id == "" || s.ID == id) && (ip == "" || s.IP == ip) {
+			found = append(found, *s)
+		}
+	}
+	return found
+}
+
+// SessionsHandler lists recent callback sessions as JSON. They can be
+// filtered with the id and ip query parameters.
+func (s *SSRFSheriffRouter) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	res, _ := json.Marshal(s.sessions.find(query.Get("id"), query.Get("ip")))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
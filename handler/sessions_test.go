@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionsSplitOnIdleClients(t *testing.T) {
+	tracker := newSessionTracker(30 * time.Second)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	first := tracker.assign("192.0.2.1", start)
+	if got := tracker.assign("192.0.2.1", start.Add(20*time.Second)); got != first {
+		t.Error("callback within the idle window started a new session")
+	}
+	other := tracker.assign("192.0.2.2", start.Add(21*time.Second))
+	if other == first {
+		t.Error("another IP joined the session")
+	}
+	// The window counts from the last callback, not the first.
+	if got := tracker.assign("192.0.2.1", start.Add(45*time.Second)); got != first {
+		t.Error("callback 25s after the last one started a new session")
+	}
+	second := tracker.assign("192.0.2.1", start.Add(2*time.Minute))
+	if second == first {
+		t.Error("callback after the idle window joined the old session")
+	}
+
+	sessions := tracker.find("", "192.0.2.1")
+	if len(sessions) != 2 || sessions[0].ID != second || sessions[1].ID != first {
+		t.Fatalf("sessions for 192.0.2.1 = %+v, want the new one first", sessions)
+	}
+	if sessions[1].Callbacks != 3 || !sessions[1].LastSeen.Equal(start.Add(45*time.Second)) {
+		t.Errorf("first session = %+v, want 3 callbacks ending at +45s", sessions[1])
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// slowConfig configures the slow drip route, which trickles the token out
+// to test how long clients keep reading and to hold connections open.
+type slowConfig struct {
+	// Prefix of the /slow/<path> route. Empty disables it.
+	Prefix string `yaml:"prefix"`
+	// Interval is the wait between chunks.
+	Interval time.Duration `yaml:"interval"`
+	// ChunkSize is the number of bytes sent at a time.
+	ChunkSize int `yaml:"chunk_size"`
+}
+
+var defaultSlow = slowConfig{
+	Prefix:    "/slow",
+	Interval:  time.Second,
+	ChunkSize: 1,
+}
+
+func loadSlow(cfg config.Provider) (slowConfig, error) {
+	sc := defaultSlow
+	if err := cfg.Get("slow").Populate(&sc); err != nil {
+		return sc, fmt.Errorf("failed to load slow: %v", err)
+	}
+	if sc.Prefix != "" && (!strings.HasPrefix(sc.Prefix, "/") || sc.Prefix == "/") {
+		return sc, fmt.Errorf("invalid slow.prefix %q: must start with / and not be /", sc.Prefix)
+	}
+	sc.Prefix = strings.TrimSuffix(sc.Prefix, "/")
+	if sc.Interval < 0 {
+		return sc, fmt.Errorf("slow.interval must not be negative")
+	}
+	if sc.ChunkSize <= 0 {
+		return sc, fmt.Errorf("slow.chunk_size must be positive")
+	}
+	return sc, nil
+}
+
+// SlowHandler answers /slow/<path> with the token, sent slow.chunk_size
+// bytes at a time with a flush and a wait of slow.interval after each chunk.
+// How far the client read before hanging up is logged, which shows the
+// target's read timeout.
+func (s *SSRFSheriffRouter) SlowHandler(w http.ResponseWriter, r *http.Request) {
+	defer s.callbacks.record()
+
+	profile := s.acceptCallback(r)
+	body := []byte(profile.Token)
+
+	w.Header().Set("Content-Type", "text/plain")
+	// Keep browsers from buffering the body to sniff its type.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	for _, name := range s.tokenHeaders {
+		w.Header().Set(name, profile.Token)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	start := time.Now()
+	sent := 0
+	for sent < len(body) {
+		if sent > 0 && !sleep(r, s.slow.Interval) {
+			break
+		}
+		end := min(sent+s.slow.ChunkSize, len(body))
+		if _, err := w.Write(body[sent:end]); err != nil {
+			break
+		}
+		if err := rc.Flush(); err != nil {
+			break
+		}
+		sent = end
+	}
+
+	fields := []zap.Field{
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.Int("Bytes Sent", sent),
+		zap.Int("Bytes Total", len(body)),
+		zap.Duration("Duration", time.Since(start)),
+	}
+	if sent < len(body) {
+		s.logger.Info("Client hung up during slow drip", fields...)
+		return
+	}
+	s.logger.Info("Finished slow drip", fields...)
+}
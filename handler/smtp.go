@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teknogeek/ssrf-sheriff/smtpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// defaultSMTPMaxMessageBytes is the largest message accepted by the SMTP
+// server unless smtp.max_message_bytes is configured.
+const defaultSMTPMaxMessageBytes = 1 << 20
+
+// NewSMTPServer builds the SMTP server configured in the smtp section, which
+// accepts and logs every message. Sources outside
+// security.allowed_source_cidrs get the decoy token. It returns nil if
+// smtp.address isn't set.
+func NewSMTPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*smtpserver.Server, error) {
+	raw := struct {
+		Address         string `yaml:"address"`
+		Hostname        string `yaml:"hostname"`
+		MaxMessageBytes int    `yaml:"max_message_bytes"`
+	}{
+		Hostname:        "mail.localdomain",
+		MaxMessageBytes: defaultSMTPMaxMessageBytes,
+	}
+	if err := cfg.Get("smtp").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load smtp: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+	if raw.MaxMessageBytes <= 0 {
+		return nil, fmt.Errorf("smtp.max_message_bytes must be positive")
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return smtpserver.New(smtpserver.Config{
+		Addr:            raw.Address,
+		Hostname:        raw.Hostname,
+		Token:           s.sourceToken("SMTP"),
+		MaxMessageBytes: raw.MaxMessageBytes,
+		Proxy:           proxy,
+	}, logger), nil
+}
+
+// StartSMTPServer starts the SMTP server, if one is configured.
+func StartSMTPServer(srv *smtpserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
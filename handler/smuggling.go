@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// smugglingIndicators returns the reasons a request looks like it was built
+// to smuggle another request past a front-end, based on its framing headers.
+//
+// net/http normalizes away most conflicting framing before handlers run, so
+// when raw capture is enabled the headers as received on the wire are
+// inspected as well.
+func smugglingIndicators(r *http.Request, raw []byte) (indicators []string, contentLengths, transferEncodings []string) {
+	contentLengths = r.Header.Values("Content-Length")
+	transferEncodings = r.TransferEncoding
+
+	if rawHeader := rawRequestHeader(raw); rawHeader != nil {
+		contentLengths = rawHeader.Values("Content-Length")
+		transferEncodings = rawHeader.Values("Transfer-Encoding")
+	}
+
+	if len(contentLengths) > 0 && len(transferEncodings) > 0 {
+		indicators = append(indicators, "both Content-Length and Transfer-Encoding present")
+	}
+	if len(contentLengths) > 1 {
+		indicators = append(indicators, fmt.Sprintf("%d Content-Length headers", len(contentLengths)))
+	}
+	if len(transferEncodings) > 1 {
+		indicators = append(indicators, fmt.Sprintf("%d Transfer-Encoding headers", len(transferEncodings)))
+	}
+	for _, te := range transferEncodings {
+		if !strings.EqualFold(te, "chunked") {
+			indicators = append(indicators, fmt.Sprintf("unusual Transfer-Encoding %q", te))
+		}
+	}
+	return indicators, contentLengths, transferEncodings
+}
+
+// rawRequestHeader parses the header block of a raw request, or returns nil
+// if there isn't a complete one.
+func rawRequestHeader(raw []byte) textproto.MIMEHeader {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	if _, err := tp.ReadLine(); err != nil {
+		return nil
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil
+	}
+	return header
+}
+
+// logSmugglingIndicators logs requests with conflicting framing headers.
+func (s *SSRFSheriffRouter) logSmugglingIndicators(r *http.Request) {
+	indicators, contentLengths, transferEncodings := smugglingIndicators(r, rawRequestBytes(r))
+	if len(indicators) == 0 {
+		return
+	}
+
+	s.logger.Warn("Potential request smuggling indicators",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.Strings("Indicators", indicators),
+		zap.Strings("Content-Length", contentLengths),
+		zap.Strings("Transfer-Encoding", transferEncodings),
+	)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// decoyProfile is served instead of the real token and media to sources
+// outside security.allowed_source_cidrs. Its media is generated at startup
+// like any other host profile's.
+var decoyProfile = hostProfile{
+	Token: "00000000000000000000",
+}
+
+// loadAllowedSources parses security.allowed_source_cidrs. An empty list
+// allows every source.
+func loadAllowedSources(cfg config.Provider) ([]*net.IPNet, error) {
+	var cidrs []string
+	if err := cfg.Get("security.allowed_source_cidrs").Populate(&cidrs); err != nil {
+		return nil, fmt.Errorf("failed to load security.allowed_source_cidrs: %v", err)
+	}
+
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q in security.allowed_source_cidrs: %v", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// sourceAllowed reports whether the request comes from a network allowed to
+// receive the real token.
+func (s *SSRFSheriffRouter) sourceAllowed(r *http.Request) bool {
+	return s.ipAllowed(net.ParseIP(clientIP(r)))
+}
+
+// ipAllowed reports whether ip is in a network allowed to receive the real
+// token.
+func (s *SSRFSheriffRouter) ipAllowed(ip net.IP) bool {
+	if len(s.allowedSources) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.allowedSources {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictProfile returns the profile to serve for the request: the given
+// profile for allowed sources, or the decoy profile for everyone else.
+func (s *SSRFSheriffRouter) restrictProfile(r *http.Request, profile hostProfile) hostProfile {
+	if s.sourceAllowed(r) {
+		return profile
+	}
+
+	s.logger.Warn("Serving decoy token to disallowed source",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("User-Agent", r.UserAgent()),
+	)
+	return decoyProfile
+}
+
+// sourceToken returns the token supplier of a non-HTTP listener: it returns
+// the current generation of the secret token to allowed sources and the decoy
+// token to everyone else, so listeners follow rotations. protocol names the
+// listener in logs.
+func (s *SSRFSheriffRouter) sourceToken(protocol string) func(net.Addr) string {
+	return func(remote net.Addr) string {
+		if s.ipAllowed(addrIP(remote)) {
+			return s.secretToken()
+		}
+		s.logger.Warn("Serving decoy token to disallowed source",
+			zap.Stringer("IP", remote),
+			zap.String("Protocol", protocol),
+		)
+		return decoyProfile.Token
+	}
+}
+
+// addrIP returns the IP address of a TCP or UDP address, or nil for any
+// other address.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
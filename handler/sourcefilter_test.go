@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSourceTokenServesDecoyToDisallowedSources(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("192.0.2.0/24")
+	s := &SSRFSheriffRouter{
+		logger:         zap.NewNop(),
+		allowedSources: []*net.IPNet{allowed},
+		generations:    &tokenGenerations{},
+	}
+	s.generations.add("secret", time.Time{}, time.Time{})
+	token := s.sourceToken("TCP")
+
+	tests := []struct {
+		remote net.Addr
+		want   string
+	}{
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 1234}, "secret"},
+		{&net.UDPAddr{IP: net.IPv4(192, 0, 2, 8), Port: 53}, "secret"},
+		{&net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1234}, decoyProfile.Token},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, decoyProfile.Token},
+		{nil, decoyProfile.Token},
+	}
+	for _, tt := range tests {
+		if got := token(tt.remote); got != tt.want {
+			t.Errorf("token(%v) = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+
+	s.allowedSources = nil
+	if got := token(&net.TCPAddr{IP: net.IPv4(198, 51, 100, 1)}); got != "secret" {
+		t.Errorf("token without an allowlist = %q, want the real token", got)
+	}
+
+	s.generations.add("rotated", time.Time{}, time.Time{})
+	if got := token(&net.TCPAddr{IP: net.IPv4(198, 51, 100, 1)}); got != "rotated" {
+		t.Errorf("token after a rotation = %q, want the new generation", got)
+	}
+}
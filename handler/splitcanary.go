@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// splitCanaryParam is the query parameter which requests the response
+// splitting canary when research.split_canary is enabled.
+const splitCanaryParam = "splitcanary"
+
+// serveSplitCanary hijacks the connection and writes a response crafted to
+// detect clients with lenient response parsers:
+//
+//   - a header value containing a bare LF followed by an injected
+//     X-Injected-Token header, which only parsers accepting LF as a line
+//     terminator will see as a separate header
+//   - a second, complete response after the body declared by Content-Length,
+//     which only clients ignoring Content-Length or desyncing on reuse will
+//     ever read
+//
+// The payload is fixed and the connection is closed straight after, so the
+// canary can't grow or leak into other requests. It returns false, leaving
+// the request to the regular handler, if it couldn't be served safely.
+func (s *SSRFSheriffRouter) serveSplitCanary(w http.ResponseWriter, r *http.Request, token string) bool {
+	// The token ends up verbatim in the raw response, so refuse anything that
+	// could break the framing in ways we didn't intend.
+	if strings.ContainsAny(token, "\r\n") {
+		s.logger.Warn("Refusing to serve split canary for token containing CR/LF")
+		return false
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Warn("Failed to hijack connection for split canary", zap.Error(err))
+		return false
+	}
+	defer conn.Close()
+
+	body := fmt.Sprintf("token=%s", token)
+	smuggled := fmt.Sprintf("split-token=%s", token)
+
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain\r\n")
+	for _, name := range s.tokenHeaders {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, token)
+	}
+	fmt.Fprintf(buf, "X-Split-Canary: lf\nX-Injected-Token: %s\r\n", token)
+	fmt.Fprintf(buf, "Content-Length: %d\r\n", len(body))
+	fmt.Fprintf(buf, "Connection: close\r\n\r\n")
+	fmt.Fprint(buf, body)
+
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain\r\n")
+	fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(smuggled))
+	fmt.Fprint(buf, smuggled)
+
+	if err := buf.Flush(); err != nil {
+		s.logger.Warn("Failed to write split canary", zap.Error(err))
+	}
+
+	s.logger.Info("Served response splitting canary",
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+	)
+	return true
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultStatusPrefix is where the status routes live unless status.prefix
+// is configured.
+const defaultStatusPrefix = "/status"
+
+// loadStatusPrefix reads status.prefix. An empty prefix disables the status
+// routes.
+func loadStatusPrefix(cfg config.Provider) (string, error) {
+	prefix := defaultStatusPrefix
+	if err := cfg.Get("status.prefix").Populate(&prefix); err != nil {
+		return "", fmt.Errorf("failed to load status.prefix: %v", err)
+	}
+	if prefix != "" && (!strings.HasPrefix(prefix, "/") || prefix == "/") {
+		return "", fmt.Errorf("invalid status.prefix %q: must start with / and not be /", prefix)
+	}
+	return strings.TrimSuffix(prefix, "/"), nil
+}
+
+// informational reports whether net/http sends status as an interim 1xx
+// response, to be followed by a final one. 101 Switching Protocols is final.
+func informational(status int) bool {
+	return status >= 100 && status <= 199 && status != http.StatusSwitchingProtocols
+}
+
+// StatusHandler answers <prefix>/<code>[/<path>] with the token and the given
+// status, which may be anything from 100 to 599, to see how the client
+// handles non-200 responses. Informational codes other than 101 can't end a
+// response, so they are sent as an interim response followed by a 200. The
+// token is also sent in the token headers, since statuses such as 101, 204
+// and 304 can't carry a body. Paths without a valid code are still callbacks,
+// answered by PathHandler.
+func (s *SSRFSheriffRouter) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, s.statusPrefix+"/")
+	code, _, _ := strings.Cut(rest, "/")
+
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 100 || status > 599 {
+		s.PathHandler(w, r)
+		return
+	}
+
+	defer s.callbacks.record()
+	profile := s.acceptCallback(r)
+
+	for _, name := range s.tokenHeaders {
+		w.Header().Set(name, profile.Token)
+	}
+	addLogFields(r, zap.Int("Requested Status", status))
+	if informational(status) {
+		w.WriteHeader(status)
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	s.writeResponse(w, r, status, []byte(profile.Token))
+}
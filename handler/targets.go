@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultTargetPrefix is the path prefix carrying target IDs unless
+// targets.prefix is configured.
+const defaultTargetPrefix = "/t"
+
+// targetIDEncoding encodes target IDs in lowercase unpadded base32, so they
+// can be used as DNS labels as well as path segments.
+var targetIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// target is an identifier handed out for one payload, target or teammate, so
+// the callbacks it leads to can be attributed to it.
+type target struct {
+	ID      string    `json:"id" yaml:"id"`
+	Label   string    `json:"label,omitempty" yaml:"label"`
+	Created time.Time `json:"created,omitempty" yaml:"-"`
+}
+
+// NewTargetID returns a new random target ID, for -mint-target and the
+// targets API.
+func NewTargetID() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return targetIDEncoding.EncodeToString(b), nil
+}
+
+// validTargetID reports whether id can be used both as a path segment and as
+// a DNS label.
+func validTargetID(id string) bool {
+	if id == "" || len(id) > 63 || id[0] == '-' || id[len(id)-1] == '-' {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// targetRegistry holds the known targets: those in targets.known and those
+// minted through the targets API since startup.
+type targetRegistry struct {
+	prefix string
+	// zone is dns.zone, under which target IDs make hostnames.
+	zone string
+
+	mu      sync.Mutex
+	targets map[string]target
+}
+
+// loadTargets reads the targets section, and dns.zone for the hostnames
+// carrying target IDs.
+func loadTargets(cfg config.Provider) (*targetRegistry, error) {
+	raw := struct {
+		Prefix string   `yaml:"prefix"`
+		Known  []target `yaml:"known"`
+	}{Prefix: defaultTargetPrefix}
+	if err := cfg.Get("targets").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load targets: %v", err)
+	}
+	if raw.Prefix != "" && (!strings.HasPrefix(raw.Prefix, "/") || strings.HasSuffix(raw.Prefix, "/")) {
+		return nil, fmt.Errorf("invalid targets.prefix %q: must start and not end with /", raw.Prefix)
+	}
+
+	reg := &targetRegistry{
+		prefix:  raw.Prefix,
+		zone:    strings.ToLower(strings.Trim(cfg.Get("dns.zone").String(), ".")),
+		targets: make(map[string]target, len(raw.Known)),
+	}
+	for _, t := range raw.Known {
+		t.ID = strings.ToLower(t.ID)
+		if !validTargetID(t.ID) {
+			return nil, fmt.Errorf("invalid target ID %q in targets.known", t.ID)
+		}
+		if _, ok := reg.targets[t.ID]; ok {
+			return nil, fmt.Errorf("duplicate target ID %q in targets.known", t.ID)
+		}
+		reg.targets[t.ID] = t
+	}
+	return reg, nil
+}
+
+// mint registers a target with a new random ID.
+func (reg *targetRegistry) mint(label string) (target, error) {
+	id, err := NewTargetID()
+	if err != nil {
+		return target{}, err
+	}
+	t := target{ID: id, Label: label, Created: time.Now().UTC()}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.targets[id] = t
+	return t, nil
+}
+
+func (reg *targetRegistry) lookup(id string) (target, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	t, ok := reg.targets[strings.ToLower(id)]
+	return t, ok
+}
+
+// list returns the known targets, oldest first.
+func (reg *targetRegistry) list() []target {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	targets := make([]target, 0, len(reg.targets))
+	for _, t := range reg.targets {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if !targets[i].Created.Equal(targets[j].Created) {
+			return targets[i].Created.Before(targets[j].Created)
+		}
+		return targets[i].ID < targets[j].ID
+	})
+	return targets
+}
+
+// match returns the known target whose ID is in the request's path, as
+// <prefix>/<id>/..., or is a label of its Host under the zone, as
+// <id>.<zone>. Hosts outside the zone never match, so a target ID can't be
+// claimed by pointing another domain at the sheriff.
+func (reg *targetRegistry) match(r *http.Request) (target, bool) {
+	if reg.prefix != "" {
+		if rest, ok := strings.CutPrefix(r.URL.Path, reg.prefix+"/"); ok {
+			id, _, _ := strings.Cut(rest, "/")
+			if t, ok := reg.lookup(id); ok {
+				return t, true
+			}
+		}
+	}
+	return reg.matchName(r.Host)
+}
+
+// matchName returns the known target whose ID is a label of name, a hostname
+// or DNS name, left of the zone.
+func (reg *targetRegistry) matchName(name string) (target, bool) {
+	for _, label := range zoneLabels(name, reg.zone) {
+		if t, ok := reg.lookup(label); ok {
+			return t, true
+		}
+	}
+	return target{}, false
+}
+
+// targetResponse is a target with where to use its ID.
+type targetResponse struct {
+	target
+	Path     string `json:"path,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+func (s *SSRFSheriffRouter) targetResponse(t target) targetResponse {
+	res := targetResponse{target: t}
+	if s.targets.prefix != "" {
+		res.Path = s.targets.prefix + "/" + t.ID + "/"
+	}
+	if s.targets.zone != "" {
+		res.Hostname = t.ID + "." + s.targets.zone
+	}
+	return res
+}
+
+// TargetsHandler lists the known targets on GET. On POST it mints a target
+// with the label in the JSON body and returns it, with the path prefix and
+// hostname carrying its ID. Callbacks to either are logged, notified and
+// stored with the target's ID. Minted targets are forgotten on restart unless
+// they are added to targets.known.
+func (s *SSRFSheriffRouter) TargetsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	var res []byte
+	switch r.Method {
+	case http.MethodGet:
+		targets := s.targets.list()
+		list := make([]targetResponse, len(targets))
+		for i, t := range targets {
+			list[i] = s.targetResponse(t)
+		}
+		res, _ = json.Marshal(list)
+	case http.MethodPost:
+		var body struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := s.targets.mint(body.Label)
+		if err != nil {
+			s.logger.Error("Failed to mint target", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		s.logger.Info("Minted target",
+			zap.String("IP", r.RemoteAddr),
+			zap.String("Target", t.ID),
+			zap.String("Target Label", t.Label),
+		)
+		res, _ = json.Marshal(s.targetResponse(t))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTargetMatchRequiresZone(t *testing.T) {
+	reg := &targetRegistry{prefix: "/t", zone: "sheriff.test", targets: map[string]target{"abc": {ID: "abc"}}}
+	tests := []struct {
+		host, path string
+		want       bool
+	}{
+		{"abc.sheriff.test", "/", true},
+		{"ABC.sheriff.test:8080", "/", true},
+		{"abc.evil.test", "/", false},
+		{"abc.sheriff.test.evil.test", "/", false},
+		{"sheriff.test", "/t/abc/x", true},
+		{"sheriff.test", "/t/nope/x", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Host = tt.host
+		if _, got := reg.match(r); got != tt.want {
+			t.Errorf("match(%s%s) = %v, want %v", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
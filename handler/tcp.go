@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/tcpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTCPBanner is written to raw TCP connections unless tcp.banner
+	// is configured. {token} is replaced with the secret token.
+	defaultTCPBanner = "220 ssrf-sheriff ready token={token}\r\n"
+
+	defaultTCPReadTimeout = 10 * time.Second
+	defaultTCPMaxBytes    = 64 * 1024
+)
+
+// NewTCPServer builds the raw TCP listener configured in the tcp section.
+// Sources outside security.allowed_source_cidrs get the decoy token in the
+// banner. It returns nil if no tcp.addresses are set.
+func NewTCPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*tcpserver.Server, error) {
+	raw := struct {
+		Addresses   []string      `yaml:"addresses"`
+		Banner      string        `yaml:"banner"`
+		Echo        bool          `yaml:"echo"`
+		ReadTimeout time.Duration `yaml:"read_timeout"`
+		MaxBytes    int           `yaml:"max_bytes"`
+	}{
+		Banner:      defaultTCPBanner,
+		ReadTimeout: defaultTCPReadTimeout,
+		MaxBytes:    defaultTCPMaxBytes,
+	}
+	if err := cfg.Get("tcp").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load tcp: %v", err)
+	}
+	if len(raw.Addresses) == 0 {
+		return nil, nil
+	}
+	if raw.MaxBytes <= 0 {
+		return nil, fmt.Errorf("tcp.max_bytes must be positive")
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	token := s.sourceToken("TCP")
+	return tcpserver.New(tcpserver.Config{
+		Addrs: raw.Addresses,
+		Banner: func(remote net.Addr) []byte {
+			return []byte(strings.ReplaceAll(raw.Banner, "{token}", token(remote)))
+		},
+		Echo:        raw.Echo,
+		ReadTimeout: raw.ReadTimeout,
+		MaxBytes:    raw.MaxBytes,
+		Proxy:       proxy,
+	}, logger), nil
+}
+
+// StartTCPServer starts the raw TCP listener, if one is configured.
+func StartTCPServer(srv *tcpserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
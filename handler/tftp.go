@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/teknogeek/ssrf-sheriff/tftpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewTFTPServer builds the TFTP server configured in the tftp section, which
+// serves the secret token as every file, or the decoy token to sources
+// outside security.allowed_source_cidrs. It returns nil if tftp.address isn't
+// set.
+func NewTFTPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*tftpserver.Server, error) {
+	var raw struct {
+		Address string `yaml:"address"`
+	}
+	if err := cfg.Get("tftp").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load tftp: %v", err)
+	}
+	if raw.Address == "" {
+		return nil, nil
+	}
+
+	token := s.sourceToken("TFTP")
+	return tftpserver.New(tftpserver.Config{
+		Addr: raw.Address,
+		Content: func(remote net.Addr) []byte {
+			return []byte("token=" + token(remote))
+		},
+	}, logger), nil
+}
+
+// StartTFTPServer starts the TFTP server, if one is configured.
+func StartTFTPServer(srv *tftpserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
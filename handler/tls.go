@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/teknogeek/ssrf-sheriff/httpserver"
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultAutocertCacheDir is where certificates obtained through ACME are
+// cached unless http.tls.autocert.cache_dir is configured.
+const defaultAutocertCacheDir = "certs/acme"
+
+// defaultSelfSignedNames are the names a self-signed certificate is issued
+// for unless http.tls.self_signed_names is configured.
+var defaultSelfSignedNames = []string{"localhost", "127.0.0.1", "::1"}
+
+// tlsConfig is the http.tls section of the config.
+type tlsConfig struct {
+	// Address of the HTTPS listener. HTTPS is disabled if this is empty.
+	Address string `yaml:"address"`
+	// Addresses of additional HTTPS listeners, see NewExtraHandles.
+	Addresses []string `yaml:"addresses"`
+	CertFile  string   `yaml:"cert_file"`
+	KeyFile   string   `yaml:"key_file"`
+
+	// SelfSigned generates a certificate for SelfSignedNames at startup
+	// instead of reading CertFile and KeyFile, which must be empty.
+	SelfSigned      bool     `yaml:"self_signed"`
+	SelfSignedNames []string `yaml:"self_signed_names"`
+
+	// Autocert obtains certificates from Let's Encrypt instead of reading
+	// CertFile and KeyFile when any domains are listed.
+	Autocert struct {
+		Domains  []string `yaml:"domains"`
+		CacheDir string   `yaml:"cache_dir"`
+	} `yaml:"autocert"`
+
+	// Range of TLS versions negotiated, such as "1.0" or "1.3". Empty keeps
+	// Go's defaults.
+	MinVersion string `yaml:"min_version"`
+	MaxVersion string `yaml:"max_version"`
+	// Names of the cipher suites negotiated up to TLS 1.2, as listed by
+	// tls.CipherSuites and tls.InsecureCipherSuites.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// tlsVersions maps the accepted spellings of TLS versions to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as "1.2", "TLS1.2" or
+// "TLS 1.2". An empty string gives 0.
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "TLS"))
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return version, nil
+}
+
+// parseCipherSuites looks up cipher suites by their Go names, such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", including the insecure ones.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// tlsPolicyOption returns the TLSPolicy option for the versions and cipher
+// suites configured in tc.
+func tlsPolicyOption(tc tlsConfig) (httpserver.HandleOption, error) {
+	minVersion, err := parseTLSVersion(tc.MinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.tls.min_version: %v", err)
+	}
+	maxVersion, err := parseTLSVersion(tc.MaxVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.tls.max_version: %v", err)
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return nil, fmt.Errorf("http.tls.min_version %s is above max_version %s", tc.MinVersion, tc.MaxVersion)
+	}
+	suites, err := parseCipherSuites(tc.CipherSuites)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.tls.cipher_suites: %v", err)
+	}
+	if len(suites) > 0 && minVersion == tls.VersionTLS13 {
+		return nil, fmt.Errorf("http.tls.cipher_suites has no effect with min_version 1.3")
+	}
+	return httpserver.TLSPolicy(minVersion, maxVersion, suites), nil
+}
+
+// TLSCertificate is the certificate served by the HTTPS listeners.
+// Certificate is nil when none of them is configured, or when they obtain
+// their certificates through autocert.
+type TLSCertificate struct {
+	*tls.Certificate
+}
+
+// NewTLSCertificate loads the certificate configured in http.tls, or
+// generates a self-signed one, once for all HTTPS listeners so a bad path
+// fails at startup. The SHA-256 fingerprint of a generated certificate is
+// logged so clients can pin it; reloading the config generates a new one.
+func NewTLSCertificate(cfg config.Provider, logger *zap.Logger) (TLSCertificate, error) {
+	var tc tlsConfig
+	if err := cfg.Get("http.tls").Populate(&tc); err != nil {
+		return TLSCertificate{}, fmt.Errorf("failed to load http.tls: %v", err)
+	}
+	if tc.Address == "" && len(tc.Addresses) == 0 {
+		return TLSCertificate{}, nil
+	}
+	if tc.SelfSigned && (tc.CertFile != "" || tc.KeyFile != "") {
+		return TLSCertificate{}, fmt.Errorf("http.tls.self_signed can't be combined with cert_file and key_file")
+	}
+	if tc.SelfSigned && len(tc.Autocert.Domains) > 0 {
+		return TLSCertificate{}, fmt.Errorf("http.tls.self_signed can't be combined with autocert")
+	}
+	if len(tc.Autocert.Domains) > 0 {
+		return TLSCertificate{}, nil
+	}
+
+	if !tc.SelfSigned {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return TLSCertificate{}, fmt.Errorf("failed to load http.tls certificate: %v", err)
+		}
+		return TLSCertificate{&cert}, nil
+	}
+
+	names := tc.SelfSignedNames
+	if len(names) == 0 {
+		names = defaultSelfSignedNames
+	}
+	cert, err := selfSignedCertificate(names, time.Now())
+	if err != nil {
+		return TLSCertificate{}, fmt.Errorf("failed to generate http.tls certificate: %v", err)
+	}
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	logger.Info("Generated self-signed TLS certificate",
+		zap.Strings("Names", names),
+		zap.String("SHA256 Fingerprint", hex.EncodeToString(fingerprint[:])),
+	)
+	return TLSCertificate{cert}, nil
+}
+
+// selfSignedCertificate generates an ECDSA P-256 certificate for names, which
+// may be DNS names or IP addresses, valid for a year from now.
+func selfSignedCertificate(names []string, now time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// TLSHandle is the Handle of the HTTPS listener, which serves the same
+// routes as the plain HTTP one. Handle is nil when HTTPS isn't configured.
+type TLSHandle struct {
+	*httpserver.Handle
+}
+
+// NewTLSHandle builds the HTTPS listener configured in http.tls.
+func NewTLSHandle(mux *mux.Router, cfg config.Provider, cert TLSCertificate) (TLSHandle, error) {
+	var tc tlsConfig
+	if err := cfg.Get("http.tls").Populate(&tc); err != nil {
+		return TLSHandle{}, fmt.Errorf("failed to load http.tls: %v", err)
+	}
+	if tc.Address == "" {
+		return TLSHandle{}, nil
+	}
+
+	addr, err := httpserver.NormalizeAddr(tc.Address)
+	if err != nil {
+		return TLSHandle{}, fmt.Errorf("invalid http.tls.address: %v", err)
+	}
+	opts, err := tlsHandleOptions(tc, cert, cfg)
+	if err != nil {
+		return TLSHandle{}, err
+	}
+
+	server, err := newServer(addr, mux, cfg)
+	if err != nil {
+		return TLSHandle{}, err
+	}
+	return TLSHandle{httpserver.NewHandle(server, opts...)}, nil
+}
+
+// tlsHandleOptions returns the options of the HTTPS listeners.
+func tlsHandleOptions(tc tlsConfig, cert TLSCertificate, cfg config.Provider) ([]httpserver.HandleOption, error) {
+	policy, err := tlsPolicyOption(tc)
+	if err != nil {
+		return nil, err
+	}
+	opts := []httpserver.HandleOption{
+		listenFunc(),
+		httpserver.FingerprintTLS(),
+		policy,
+	}
+	if domains := tc.Autocert.Domains; len(domains) > 0 {
+		cacheDir := tc.Autocert.CacheDir
+		if cacheDir == "" {
+			cacheDir = defaultAutocertCacheDir
+		}
+		opts = append(opts, httpserver.WithAutocert(domains...), httpserver.AutocertCacheDir(cacheDir))
+	} else {
+		opts = append(opts, httpserver.TLS(&tls.Config{Certificates: []tls.Certificate{*cert.Certificate}}))
+	}
+
+	proxy, err := loadProxyPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		opts = append(opts, httpserver.ProxyProtocol(*proxy))
+	}
+	return opts, nil
+}
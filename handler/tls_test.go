@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		{"", 0},
+		{"1.0", tls.VersionTLS10},
+		{"1.2", tls.VersionTLS12},
+		{"TLS1.3", tls.VersionTLS13},
+		{"tls 1.1", tls.VersionTLS11},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %#x, %v, want %#x", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"1.4", "SSL3", "12"} {
+		if _, err := parseTLSVersion(in); err == nil {
+			t.Errorf("parseTLSVersion(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parseCipherSuites = %#x, want %#x", got, want)
+	}
+
+	if _, err := parseCipherSuites([]string{"TLS_MADE_UP"}); err == nil || !strings.Contains(err.Error(), "TLS_MADE_UP") {
+		t.Errorf("error = %v, want one naming the unknown suite", err)
+	}
+}
+
+func TestTLSPolicyOptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		tc   tlsConfig
+		want string
+	}{
+		{"bad min", tlsConfig{MinVersion: "1.9"}, "min_version"},
+		{"bad max", tlsConfig{MaxVersion: "x"}, "max_version"},
+		{"inverted range", tlsConfig{MinVersion: "1.3", MaxVersion: "1.1"}, "above max_version"},
+		{"suites with 1.3 only", tlsConfig{MinVersion: "1.3", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "no effect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tlsPolicyOption(tt.tc)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	now := time.Now()
+	cert, err := selfSignedCertificate([]string{"sheriff.test", "127.0.0.1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("sheriff.test"); err != nil {
+		t.Error(err)
+	}
+	if len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("IP addresses = %v, want [127.0.0.1]", leaf.IPAddresses)
+	}
+	if err := leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+		t.Errorf("certificate isn't self-signed: %v", err)
+	}
+	if !leaf.NotBefore.Before(now) || !leaf.NotAfter.After(now.AddDate(0, 11, 0)) {
+		t.Errorf("validity %v to %v doesn't cover the coming year", leaf.NotBefore, leaf.NotAfter)
+	}
+
+	key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || !key.PublicKey.Equal(leaf.PublicKey) {
+		t.Error("private key doesn't match the certificate")
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/config"
+)
+
+// defaultTokenHeaders are the response headers carrying the token unless
+// http.token_headers is configured.
+var defaultTokenHeaders = []string{"X-Secret-Token"}
+
+// loadTokenHeaders reads the names of the response headers the token is sent
+// in. None are used when http.expose_token_header is disabled.
+func loadTokenHeaders(cfg config.Provider) ([]string, error) {
+	expose := true
+	if err := cfg.Get("http.expose_token_header").Populate(&expose); err != nil {
+		return nil, fmt.Errorf("failed to load http.expose_token_header: %v", err)
+	}
+	if !expose {
+		return nil, nil
+	}
+
+	var names []string
+	if err := cfg.Get("http.token_headers").Populate(&names); err != nil {
+		return nil, fmt.Errorf("failed to load http.token_headers: %v", err)
+	}
+	if len(names) == 0 {
+		return defaultTokenHeaders, nil
+	}
+	for i, name := range names {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q in http.token_headers", name)
+		}
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	return names, nil
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// defaultTokenTTL is how long minted tokens stay valid unless tokens.ttl is
+// configured.
+const defaultTokenTTL = 24 * time.Hour
+
+// callbackExtensions are the formats advertised in the callback URLs handed
+// out for minted tokens.
+var callbackExtensions = []string{"", ".json", ".xml", ".html", ".csv", ".txt", ".png", ".jpg", ".webp", ".gif", ".mp3", ".mp4", ".pdf", ".svg", ".zip", ".tar.gz"}
+
+// tokenRegistry holds ephemeral tokens minted through the /new endpoint,
+// along with when they expire.
+type tokenRegistry struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func newTokenRegistry(ttl time.Duration) *tokenRegistry {
+	return &tokenRegistry{ttl: ttl, tokens: make(map[string]time.Time)}
+}
+
+func loadTokenTTL(cfg config.Provider) (time.Duration, error) {
+	ttl := defaultTokenTTL
+	if err := cfg.Get("tokens.ttl").Populate(&ttl); err != nil {
+		return 0, fmt.Errorf("failed to load tokens.ttl: %v", err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("tokens.ttl must be positive, got %v", ttl)
+	}
+	return ttl, nil
+}
+
+// mint registers a new random token and returns it with its expiry.
+func (reg *tokenRegistry) mint() (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(reg.ttl)
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.expireLocked()
+	reg.tokens[token] = expires
+	return token, expires, nil
+}
+
+// valid reports whether token is registered and hasn't expired.
+func (reg *tokenRegistry) valid(token string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	expires, ok := reg.tokens[token]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(reg.tokens, token)
+		return false
+	}
+	return true
+}
+
+// list returns the registered tokens that haven't expired.
+func (reg *tokenRegistry) list() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.expireLocked()
+	tokens := make([]string, 0, len(reg.tokens))
+	for token := range reg.tokens {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (reg *tokenRegistry) expireLocked() {
+	now := time.Now()
+	for token, expires := range reg.tokens {
+		if now.After(expires) {
+			delete(reg.tokens, token)
+		}
+	}
+}
+
+// match returns the registered token carried in one of the request's path
+// segments, if any.
+func (reg *tokenRegistry) match(r *http.Request) (string, bool) {
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		// Allow the token to carry an extension, e.g. /<token>.json
+		if idx := strings.IndexByte(segment, '.'); idx >= 0 {
+			segment = segment[:idx]
+		}
+		if segment != "" && reg.valid(segment) {
+			return segment, true
+		}
+	}
+	return "", false
+}
+
+// matchLabels returns the registered token that is one of the given DNS
+// labels, if any.
+func (reg *tokenRegistry) matchLabels(labels []string) (string, bool) {
+	for _, label := range labels {
+		if reg.valid(label) {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+type newTokenResponse struct {
+	Token     string            `json:"token"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	URLs      map[string]string `json:"urls"`
+	// Hostname is <token>.<dns.zone>, whose lookups are recorded against
+	// the token. It is empty without a zone.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// NewTokenHandler mints a fresh ephemeral token and returns callback URLs
+// carrying it for every format, and the hostname carrying it under dns.zone.
+func (s *SSRFSheriffRouter) NewTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	token, expires, err := s.tokens.mint()
+	if err != nil {
+		s.logger.Error("Failed to mint token", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	res := newTokenResponse{
+		Token:     token,
+		ExpiresAt: expires.UTC(),
+		URLs:      make(map[string]string, len(callbackExtensions)),
+	}
+	for _, ext := range callbackExtensions {
+		name := ext
+		if name == "" {
+			name = "plain"
+		}
+		res.URLs[name] = fmt.Sprintf("%s://%s/%s/callback%s", scheme, r.Host, token, ext)
+	}
+	if s.targets.zone != "" {
+		res.Hostname = token + "." + s.targets.zone
+	}
+
+	s.logger.Info("Minted token", zap.String("Token", token), zap.Time("Expires", expires))
+
+	body, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/config"
+	"go.uber.org/fx"
+)
+
+// Transform rewrites an outgoing response body. Transforms are chained in the
+// order they are listed in the transforms config, each one receiving the
+// output of the previous one.
+type Transform interface {
+	Transform(body []byte, r *http.Request) ([]byte, error)
+}
+
+// TransformFunc adapts an ordinary function to the Transform interface.
+type TransformFunc func(body []byte, r *http.Request) ([]byte, error)
+
+// Transform calls f(body, r).
+func (f TransformFunc) Transform(body []byte, r *http.Request) ([]byte, error) {
+	return f(body, r)
+}
+
+// TransformFactory builds a Transform from the options it was configured
+// with. Factories are looked up by name when the pipeline is built.
+type TransformFactory struct {
+	Name string
+	New  func(options map[string]string) (Transform, error)
+}
+
+// TransformFactories registers TransformFactories with the fx app. Modules
+// providing their own transforms return one of these from a constructor.
+type TransformFactories struct {
+	fx.Out
+
+	Factories []TransformFactory `group:"transform_factories,flatten"`
+}
+
+// transformSpec is a single entry of the transforms config.
+type transformSpec struct {
+	Name    string            `yaml:"name"`
+	Options map[string]string `yaml:"options"`
+}
+
+// TransformPipeline is the ordered list of transforms applied to response
+// bodies before they are written.
+type TransformPipeline struct {
+	names      []string
+	transforms []Transform
+}
+
+// TransformPipelineParams are the dependencies of NewTransformPipeline.
+type TransformPipelineParams struct {
+	fx.In
+
+	Config    config.Provider
+	Factories []TransformFactory `group:"transform_factories"`
+}
+
+// NewTransformPipeline builds the pipeline configured under transforms
+// from the registered factories.
+func NewTransformPipeline(p TransformPipelineParams) (*TransformPipeline, error) {
+	var specs []transformSpec
+	if err := p.Config.Get("transforms").Populate(&specs); err != nil {
+		return nil, fmt.Errorf("failed to load transforms: %v", err)
+	}
+	return buildTransformPipeline(specs, p.Factories)
+}
+
+// buildTransformPipeline builds a pipeline of the transforms described by
+// specs, in order, from the given factories.
+func buildTransformPipeline(specs []transformSpec, registered []TransformFactory) (*TransformPipeline, error) {
+	factories := make(map[string]TransformFactory, len(registered))
+	for _, f := range registered {
+		if _, ok := factories[f.Name]; ok {
+			return nil, fmt.Errorf("transform %q registered more than once", f.Name)
+		}
+		factories[f.Name] = f
+	}
+
+	pipeline := &TransformPipeline{}
+	for _, spec := range specs {
+		f, ok := factories[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", spec.Name)
+		}
+		t, err := f.New(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for transform %q: %v", spec.Name, err)
+		}
+		pipeline.names = append(pipeline.names, spec.Name)
+		pipeline.transforms = append(pipeline.transforms, t)
+	}
+	return pipeline, nil
+}
+
+// Apply runs body through every transform in order. It stops at the first
+// transform that fails.
+func (p *TransformPipeline) Apply(body []byte, r *http.Request) ([]byte, error) {
+	if p == nil {
+		return body, nil
+	}
+	for i, t := range p.transforms {
+		var err error
+		if body, err = t.Transform(body, r); err != nil {
+			return nil, fmt.Errorf("transform %q failed: %v", p.names[i], err)
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// recordingFactory returns a factory whose transforms append their name to
+// calls and to the body.
+func recordingFactory(name string, calls *[]string) TransformFactory {
+	return TransformFactory{Name: name, New: func(map[string]string) (Transform, error) {
+		return TransformFunc(func(body []byte, _ *http.Request) ([]byte, error) {
+			*calls = append(*calls, name)
+			return append(body, "|"+name...), nil
+		}), nil
+	}}
+}
+
+func failingFactory(name string) TransformFactory {
+	return TransformFactory{Name: name, New: func(map[string]string) (Transform, error) {
+		return TransformFunc(func([]byte, *http.Request) ([]byte, error) {
+			return nil, errors.New("boom")
+		}), nil
+	}}
+}
+
+func TestTransformPipelineRunsInConfigOrder(t *testing.T) {
+	var calls []string
+	factories := []TransformFactory{
+		recordingFactory("a", &calls),
+		recordingFactory("b", &calls),
+		recordingFactory("c", &calls),
+	}
+	// Listed in a different order than registered, and one of them twice.
+	specs := []transformSpec{{Name: "c"}, {Name: "a"}, {Name: "b"}, {Name: "a"}}
+
+	p, err := buildTransformPipeline(specs, factories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := p.Apply([]byte("token"), httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "token|c|a|b|a"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+	if want := "c a b a"; strings.Join(calls, " ") != want {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestTransformPipelineChainsBuiltins(t *testing.T) {
+	specs := []transformSpec{
+		{Name: "wrap", Options: map[string]string{"prefix": "<", "suffix": ">"}},
+		{Name: "hex"},
+		{Name: "pad", Options: map[string]string{"size": "12", "char": "."}},
+	}
+	p, err := buildTransformPipeline(specs, NewBuiltinTransforms().Factories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := p.Apply([]byte("t"), httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "<t>" in hex, then padded
+	if want := "3c743e......"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestTransformPipelineStopsAtFirstFailure(t *testing.T) {
+	var calls []string
+	factories := []TransformFactory{
+		recordingFactory("before", &calls),
+		failingFactory("fail"),
+		recordingFactory("after", &calls),
+	}
+	specs := []transformSpec{{Name: "before"}, {Name: "fail"}, {Name: "after"}}
+
+	p, err := buildTransformPipeline(specs, factories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := p.Apply([]byte("token"), httptest.NewRequest("GET", "/", nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), `"fail"`) || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error %q doesn't name the failing transform and its error", err)
+	}
+	if body != nil {
+		t.Errorf("body = %q, want nil", body)
+	}
+	if want := "before"; strings.Join(calls, " ") != want {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestNilTransformPipelinePassesBodyThrough(t *testing.T) {
+	var p *TransformPipeline
+	body, err := p.Apply([]byte("token"), httptest.NewRequest("GET", "/", nil))
+	if err != nil || string(body) != "token" {
+		t.Errorf("Apply = %q, %v, want %q, nil", body, err, "token")
+	}
+}
+
+func TestBuildTransformPipelineErrors(t *testing.T) {
+	builtins := NewBuiltinTransforms().Factories
+	tests := []struct {
+		name      string
+		specs     []transformSpec
+		factories []TransformFactory
+		want      string
+	}{
+		{
+			name:      "unknown transform",
+			specs:     []transformSpec{{Name: "rot13"}},
+			factories: builtins,
+			want:      `unknown transform "rot13"`,
+		},
+		{
+			name:      "invalid options",
+			specs:     []transformSpec{{Name: "pad", Options: map[string]string{"size": "big"}}},
+			factories: builtins,
+			want:      `invalid options for transform "pad"`,
+		},
+		{
+			name:      "registered twice",
+			factories: append(builtins, TransformFactory{Name: "hex"}),
+			want:      `transform "hex" registered more than once`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildTransformPipeline(tt.specs, tt.factories)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailingTransformAnswersWithServerError(t *testing.T) {
+	p, err := buildTransformPipeline([]transformSpec{{Name: "fail"}}, []TransformFactory{failingFactory("fail")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SSRFSheriffRouter{logger: zap.NewNop(), transforms: p}
+
+	w := httptest.NewRecorder()
+	if _, ok := s.limitResponse(w, httptest.NewRequest("GET", "/", nil), []byte("token")); ok {
+		t.Fatal("limitResponse succeeded")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), "token") {
+		t.Errorf("untransformed body leaked: %q", w.Body.String())
+	}
+}
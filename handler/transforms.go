@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// NewBuiltinTransforms registers the transforms that ship with the sheriff:
+//
+//   - base64: encodes the body with standard base64.
+//   - hex: encodes the body as lowercase hex.
+//   - pad: pads the body up to "size" bytes with the "char" byte (a space by
+//     default). Bodies that are already large enough are left alone.
+//   - wrap: surrounds the body with the "prefix" and "suffix" strings, e.g. to
+//     inject a canary comment.
+func NewBuiltinTransforms() TransformFactories {
+	return TransformFactories{Factories: []TransformFactory{
+		{Name: "base64", New: newBase64Transform},
+		{Name: "hex", New: newHexTransform},
+		{Name: "pad", New: newPadTransform},
+		{Name: "wrap", New: newWrapTransform},
+	}}
+}
+
+func newBase64Transform(map[string]string) (Transform, error) {
+	return TransformFunc(func(body []byte, _ *http.Request) ([]byte, error) {
+		out := make([]byte, base64.StdEncoding.EncodedLen(len(body)))
+		base64.StdEncoding.Encode(out, body)
+		return out, nil
+	}), nil
+}
+
+func newHexTransform(map[string]string) (Transform, error) {
+	return TransformFunc(func(body []byte, _ *http.Request) ([]byte, error) {
+		out := make([]byte, hex.EncodedLen(len(body)))
+		hex.Encode(out, body)
+		return out, nil
+	}), nil
+}
+
+func newPadTransform(options map[string]string) (Transform, error) {
+	size, err := strconv.Atoi(options["size"])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("size must be a non-negative integer, got %q", options["size"])
+	}
+	char := byte(' ')
+	if c, ok := options["char"]; ok {
+		if len(c) != 1 {
+			return nil, fmt.Errorf("char must be a single byte, got %q", c)
+		}
+		char = c[0]
+	}
+
+	return TransformFunc(func(body []byte, _ *http.Request) ([]byte, error) {
+		if len(body) >= size {
+			return body, nil
+		}
+		return append(body, bytes.Repeat([]byte{char}, size-len(body))...), nil
+	}), nil
+}
+
+func newWrapTransform(options map[string]string) (Transform, error) {
+	prefix, suffix := []byte(options["prefix"]), []byte(options["suffix"])
+	return TransformFunc(func(body []byte, _ *http.Request) ([]byte, error) {
+		out := make([]byte, 0, len(prefix)+len(body)+len(suffix))
+		out = append(out, prefix...)
+		out = append(out, body...)
+		return append(out, suffix...), nil
+	}), nil
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/teknogeek/ssrf-sheriff/udpserver"
+	"go.uber.org/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// defaultUDPReply is sent back for every UDP datagram unless udp.reply is
+// configured. {token} is replaced with the secret token.
+const defaultUDPReply = "token={token}\n"
+
+// NewUDPServer builds the UDP listener configured in the udp section. Only
+// sources in security.allowed_source_cidrs, if any are set, are answered. It
+// returns nil if no udp.addresses are set.
+func NewUDPServer(cfg config.Provider, logger *zap.Logger, s *SSRFSheriffRouter) (*udpserver.Server, error) {
+	raw := struct {
+		Addresses []string `yaml:"addresses"`
+		Reply     string   `yaml:"reply"`
+	}{
+		Reply: defaultUDPReply,
+	}
+	if err := cfg.Get("udp").Populate(&raw); err != nil {
+		return nil, fmt.Errorf("failed to load udp: %v", err)
+	}
+	if len(raw.Addresses) == 0 {
+		return nil, nil
+	}
+
+	allowed, err := loadAllowedSources(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	udpCfg := udpserver.Config{
+		Addrs:          raw.Addresses,
+		AllowedSources: allowed,
+	}
+	if raw.Reply != "" {
+		udpCfg.Reply = func() []byte {
+			return []byte(strings.ReplaceAll(raw.Reply, "{token}", s.secretToken()))
+		}
+	}
+	return udpserver.New(udpCfg, logger), nil
+}
+
+// StartUDPServer starts the UDP listener, if one is configured.
+func StartUDPServer(srv *udpserver.Server, lc fx.Lifecycle) {
+	if srv == nil {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: srv.Start,
+		OnStop:  func(ctx context.Context) error { return srv.Shutdown(ctx) },
+	})
+}
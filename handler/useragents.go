@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTrackedUserAgents bounds the number of distinct User-Agents counted.
+// Anything beyond it is still counted towards its family.
+const maxTrackedUserAgents = 10000
+
+// userAgentFamilies maps lowercased User-Agent substrings to the HTTP client
+// family they identify. More specific entries come first.
+var userAgentFamilies = []struct {
+	match  string
+	family string
+}{
+	{"curl", "curl"},
+	{"wget", "wget"},
+	{"python-requests", "python-requests"},
+	{"python-urllib", "python-urllib"},
+	{"python-httpx", "python-httpx"},
+	{"aiohttp", "python-aiohttp"},
+	{"go-http-client", "go"},
+	{"okhttp", "okhttp"},
+	{"apache-httpclient", "apache-httpclient"},
+	{"java", "java"},
+	{"node-fetch", "node-fetch"},
+	{"axios", "axios"},
+	{"undici", "node-undici"},
+	{"node", "node"},
+	{"guzzlehttp", "php-guzzle"},
+	{"php", "php"},
+	{"libwww-perl", "perl"},
+	{"ruby", "ruby"},
+	{"faraday", "ruby-faraday"},
+	{".net", "dotnet"},
+	{"winhttp", "winhttp"},
+	{"headlesschrome", "headless-chrome"},
+	{"bot", "bot"},
+	{"mozilla", "browser"},
+}
+
+// classifyUserAgent returns the HTTP client family of a User-Agent.
+func classifyUserAgent(ua string) string {
+	if ua == "" {
+		return "none"
+	}
+	lower := strings.ToLower(ua)
+	for _, f := range userAgentFamilies {
+		if strings.Contains(lower, f.match) {
+			return f.family
+		}
+	}
+	return "other"
+}
+
+// userAgentStats is a concurrency-safe frequency count of User-Agents.
+type userAgentStats struct {
+	mu       sync.Mutex
+	counts   map[string]int
+	families map[string]int
+}
+
+func newUserAgentStats() *userAgentStats {
+	return &userAgentStats{
+		counts:   make(map[string]int),
+		families: make(map[string]int),
+	}
+}
+
+func (st *userAgentStats) record(ua string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.families[classifyUserAgent(ua)]++
+	if _, ok := st.counts[ua]; ok || len(st.counts) < maxTrackedUserAgents {
+		st.counts[ua]++
+	}
+}
+
+type userAgentCount struct {
+	UserAgent string `json:"user_agent"`
+	Family    string `json:"family"`
+	Count     int    `json:"count"`
+}
+
+type userAgentReport struct {
+	UserAgents []userAgentCount `json:"user_agents"`
+	Families   map[string]int   `json:"families"`
+}
+
+func (st *userAgentStats) report() userAgentReport {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	rep := userAgentReport{
+		UserAgents: make([]userAgentCount, 0, len(st.counts)),
+		Families:   make(map[string]int, len(st.families)),
+	}
+	for ua, n := range st.counts {
+		rep.UserAgents = append(rep.UserAgents, userAgentCount{
+			UserAgent: ua,
+			Family:    classifyUserAgent(ua),
+			Count:     n,
+		})
+	}
+	for family, n := range st.families {
+		rep.Families[family] = n
+	}
+
+	sort.Slice(rep.UserAgents, func(i, j int) bool {
+		if rep.UserAgents[i].Count != rep.UserAgents[j].Count {
+			return rep.UserAgents[i].Count > rep.UserAgents[j].Count
+		}
+		return rep.UserAgents[i].UserAgent < rep.UserAgents[j].UserAgent
+	})
+	return rep
+}
+
+// UserAgentsHandler returns callback counts grouped by User-Agent and by HTTP
+// client family, most frequent first.
+func (s *SSRFSheriffRouter) UserAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	res, _ := json.Marshal(s.userAgents.report())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
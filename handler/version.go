@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit identify the build. They are meant to be set at build
+// time, e.g.
+//
+//	go build -ldflags "-X github.com/teknogeek/ssrf-sheriff/handler.Version=v1.0.0 -X github.com/teknogeek/ssrf-sheriff/handler.Commit=abc123"
+//
+// and otherwise fall back to what the Go toolchain recorded in the binary.
+var (
+	Version string
+	Commit  string
+)
+
+// BuildInfo describes the running build of the sheriff.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// String formats the build info for the -version flag.
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("ssrf-sheriff %s (commit %s, %s)", b.Version, b.Commit, b.GoVersion)
+}
+
+// ReadBuildInfo returns the version, commit and Go version of the running
+// binary.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
+
+// VersionHandler reports the build info of the running sheriff.
+func (s *SSRFSheriffRouter) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	res, _ := json.Marshal(ReadBuildInfo())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
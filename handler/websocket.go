@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// websocketGUID is appended to the client's key to compute the accept key
+// (RFC 6455 section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsOpText   = 0x1
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xA
+)
+
+// websocketMaxMessage caps the client messages that are read and logged.
+const websocketMaxMessage = 64 << 10
+
+// websocketConfig configures the WebSocket endpoint.
+type websocketConfig struct {
+	// Path upgraded to a WebSocket. Empty disables the endpoint.
+	Path string `yaml:"path"`
+	// Linger is how long the connection is kept open after the token is
+	// sent, logging any messages from the client.
+	Linger time.Duration `yaml:"linger"`
+}
+
+var defaultWebSocket = websocketConfig{
+	Path:   "/ws",
+	Linger: 5 * time.Second,
+}
+
+func loadWebSocket(cfg config.Provider) (websocketConfig, error) {
+	wc := defaultWebSocket
+	if err := cfg.Get("websocket").Populate(&wc); err != nil {
+		return wc, fmt.Errorf("failed to load websocket: %v", err)
+	}
+	if wc.Path != "" && !strings.HasPrefix(wc.Path, "/") {
+		return wc, fmt.Errorf("invalid websocket.path %q: must start with /", wc.Path)
+	}
+	if wc.Linger < 0 {
+		return wc, fmt.Errorf("websocket.linger must not be negative")
+	}
+	return wc, nil
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WebSocketHandler upgrades the request to a WebSocket and sends the token as
+// a text frame straight away, for SSRF sinks that are WebSocket clients. The
+// handshake is logged, as are any messages the client sends before it closes
+// the connection or websocket.linger passes. Requests that aren't WebSocket
+// handshakes are answered like any other callback.
+func (s *SSRFSheriffRouter) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") || key == "" {
+		s.PathHandler(w, r)
+		return
+	}
+
+	defer s.callbacks.record()
+	profile := s.acceptCallback(r)
+
+	handshake := []zap.Field{
+		zap.String("IP", r.RemoteAddr),
+		zap.String("Path", r.URL.Path),
+		zap.String("WebSocket Version", r.Header.Get("Sec-WebSocket-Version")),
+		zap.String("WebSocket Protocol", r.Header.Get("Sec-WebSocket-Protocol")),
+		zap.String("WebSocket Extensions", r.Header.Get("Sec-WebSocket-Extensions")),
+		zap.String("Origin", r.Header.Get("Origin")),
+		zap.String("User-Agent", r.UserAgent()),
+	}
+	addLogFields(r, handshake[2:]...)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets need an HTTP/1.1 connection", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Warn("Failed to hijack connection for WebSocket", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprintf(buf, "Upgrade: websocket\r\n")
+	fmt.Fprintf(buf, "Connection: Upgrade\r\n")
+	fmt.Fprintf(buf, "Sec-WebSocket-Accept: %s\r\n", websocketAccept(key))
+	// Clients asking for a subprotocol may give up if none is selected.
+	if protocol, _, _ := strings.Cut(r.Header.Get("Sec-WebSocket-Protocol"), ","); protocol != "" {
+		fmt.Fprintf(buf, "Sec-WebSocket-Protocol: %s\r\n", sanitizeHeaderValue(strings.TrimSpace(protocol)))
+	}
+	for _, name := range s.tokenHeaders {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, sanitizeHeaderValue(profile.Token))
+	}
+	fmt.Fprintf(buf, "\r\n")
+	writeWebSocketFrame(buf.Writer, wsOpText, []byte(profile.Token))
+	if err := buf.Flush(); err != nil {
+		s.logger.Warn("Failed to send token over WebSocket", zap.Error(err))
+		return
+	}
+	s.logger.Info("Sent token over WebSocket", handshake...)
+
+	conn.SetDeadline(time.Now().Add(s.websocket.Linger))
+	for {
+		opcode, payload, err := readWebSocketFrame(buf.Reader)
+		if err != nil {
+			break
+		}
+		switch opcode {
+		case wsOpText, wsOpBinary:
+			s.logger.Info("Received WebSocket message",
+				zap.String("IP", r.RemoteAddr),
+				zap.Bool("Binary", opcode == wsOpBinary),
+				zap.String("Message", string(payload)),
+			)
+			continue
+		case wsOpPing:
+			writeWebSocketFrame(buf.Writer, wsOpPong, payload)
+			buf.Flush()
+			continue
+		case wsOpClose:
+		default:
+			continue
+		}
+		break
+	}
+
+	// Close normally, with status 1000, whether the client asked to or the
+	// linger time is up.
+	conn.SetDeadline(time.Now().Add(time.Second))
+	writeWebSocketFrame(buf.Writer, wsOpClose, []byte{0x03, 0xE8})
+	buf.Flush()
+}
+
+// headerContainsToken reports whether the comma-separated header contains the
+// token, ignoring case.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeWebSocketFrame writes an unmasked, unfragmented frame, as servers send
+// them.
+func writeWebSocketFrame(w *bufio.Writer, opcode byte, payload []byte) {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+}
+
+// readWebSocketFrame reads one frame from the client and returns its opcode
+// and unmasked payload. Fragmented messages are returned frame by frame.
+func readWebSocketFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(n)
+	case 127:
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return 0, nil, err
+		}
+	}
+	if length > websocketMaxMessage {
+		return 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
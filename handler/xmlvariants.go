@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/config"
+	"go.uber.org/zap"
+)
+
+// xmlVariantParam is the query parameter that picks the XML variant for a
+// single request, overriding the xml_variant response mode.
+const xmlVariantParam = "xml"
+
+// xmlVariants build the .xml responses other than the default
+// SerializableResponse, from the token and the sheriff's base URL, both
+// escaped for XML.
+var xmlVariants = map[string]func(token, baseURL string) string{
+	"saml": samlMetadata,
+	"xxe":  xxeProbe,
+}
+
+// loadXMLVariant reads http.xml_variant.
+func loadXMLVariant(cfg config.Provider) (string, error) {
+	var variant string
+	if err := cfg.Get("http.xml_variant").Populate(&variant); err != nil {
+		return "", fmt.Errorf("failed to load http.xml_variant: %v", err)
+	}
+	if err := validXMLVariant(variant); err != nil {
+		return "", fmt.Errorf("invalid http.xml_variant: %v", err)
+	}
+	return variant, nil
+}
+
+func validXMLVariant(variant string) error {
+	if _, ok := xmlVariants[variant]; variant != "" && !ok {
+		return fmt.Errorf("unknown XML variant %q", variant)
+	}
+	return nil
+}
+
+// xmlVariantResponse renders the XML variant picked by ?xml= or the mode, and
+// returns false if the default response should be served instead.
+func (s *SSRFSheriffRouter) xmlVariantResponse(r *http.Request, token, mode string) (string, bool) {
+	variant := mode
+	if v := r.URL.Query().Get(xmlVariantParam); v != "" {
+		variant = v
+	}
+	build, ok := xmlVariants[variant]
+	if !ok {
+		return "", false
+	}
+
+	data := newTemplateData(r, token).escaped(xmlEscape)
+	addLogFields(r, zap.String("XML Variant", variant))
+	return build(data.Token, data.BaseURL), true
+}
+
+// samlMetadata is a SAML 2.0 IdP metadata document whose entity ID and
+// endpoints point back at the sheriff, for service providers that fetch IdP
+// metadata from a URL.
+func samlMetadata(token, baseURL string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="%[2]s/saml/%[1]s" ID="_%[1]s">
+  <md:IDPSSODescriptor WantAuthnRequestsSigned="false" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo>
+        <ds:KeyName>%[1]s</ds:KeyName>
+        <ds:RetrievalMethod URI="%[2]s/saml/%[1]s/cert.pem"/>
+      </ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleLogoutService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%[2]s/saml/%[1]s/slo"/>
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress</md:NameIDFormat>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%[2]s/saml/%[1]s/sso"/>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="%[2]s/saml/%[1]s/sso"/>
+  </md:IDPSSODescriptor>
+  <md:Organization>
+    <md:OrganizationName xml:lang="en">%[1]s</md:OrganizationName>
+    <md:OrganizationDisplayName xml:lang="en">token=%[1]s</md:OrganizationDisplayName>
+    <md:OrganizationURL xml:lang="en">%[2]s/</md:OrganizationURL>
+  </md:Organization>
+  <md:ContactPerson contactType="technical">
+    <md:GivenName>%[1]s</md:GivenName>
+  </md:ContactPerson>
+</md:EntityDescriptor>`, token, baseURL)
+}
+
+// xxeProbe is an XML document with an external DTD and external entities
+// pointing back at the sheriff, each URL carrying the token. Parsers that
+// resolve them call back to /xxe/..., which links the XXE fetch to the SSRF
+// that served this document.
+func xxeProbe(token, baseURL string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE SerializableResponse SYSTEM "%[2]s/xxe/%[1]s/dtd.dtd" [
+  <!ENTITY external SYSTEM "%[2]s/xxe/%[1]s/entity.txt">
+  <!ENTITY %% parameter SYSTEM "%[2]s/xxe/%[1]s/parameter.dtd">
+  %%parameter;
+]>
+<SerializableResponse>
+  <token>%[1]s</token>
+  <external>&external;</external>
+</SerializableResponse>`, token, baseURL)
+}
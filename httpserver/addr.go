@@ -0,0 +1,55 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NormalizeAddr validates a listen address and returns it in host:port form.
+//
+// An empty address becomes ":0" (an OS-assigned port) and a bare port such as
+// "8080" is treated as ":8080". Ports must either be numeric and within
+// 0-65535 or a service name known to the system, like "http".
+//
+// Unix socket ("unix:<path>") and systemd socket activation
+// ("systemd:<name>") addresses are returned as they are, see UnixListenFunc
+// and SystemdListenFunc.
+func NormalizeAddr(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return ":0", nil
+	}
+
+	for _, prefix := range []string{UnixAddrPrefix, SystemdAddrPrefix} {
+		if strings.HasPrefix(addr, prefix) {
+			if addr == prefix {
+				return "", fmt.Errorf("invalid listen address %q: missing socket after %q", addr, prefix)
+			}
+			return addr, nil
+		}
+	}
+
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = ":" + addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: expected host:port or a port number", addr)
+	}
+
+	if port == "" {
+		return "", fmt.Errorf("invalid listen address %q: missing port", addr)
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		if n < 0 || n > 65535 {
+			return "", fmt.Errorf("invalid listen address %q: port %d out of range 0-65535", addr, n)
+		}
+	} else if _, err := net.LookupPort("tcp", port); err != nil {
+		return "", fmt.Errorf("invalid listen address %q: unknown port %q", addr, port)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
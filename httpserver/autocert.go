@@ -0,0 +1,29 @@
+package httpserver
+
+import "golang.org/x/crypto/acme/autocert"
+
+// WithAutocert is an option for Handle that serves HTTPS with certificates
+// that are obtained from Let's Encrypt for the given domains and renewed
+// automatically. Use AutocertCacheDir to keep them across restarts, so they
+// don't run into rate limits.
+//
+// Domains are validated with the TLS-ALPN-01 challenge, which the Handle
+// answers itself, so it must be reachable from the internet on port 443.
+func WithAutocert(domains ...string) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+		}
+		h.tlsConfig = h.autocert.TLSConfig()
+	})
+}
+
+// AutocertCacheDir is an option for Handle that caches the certificates
+// obtained by WithAutocert in dir. Certificates are only kept in memory
+// otherwise.
+func AutocertCacheDir(dir string) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.autocertCacheDir = dir
+	})
+}
@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+type captureKey struct{}
+
+// CaptureRaw is an option for Handle that records up to limit raw bytes read
+// from each connection before they are parsed by net/http. The bytes are
+// available to handlers through RawBytes.
+func CaptureRaw(limit int) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.captureLimit = limit
+	})
+}
+
+// RawBytes returns the raw bytes read from the connection serving the request
+// with the given context since the last call, and resets the capture buffer.
+// For a request that isn't pipelined, this is the exact request line, headers
+// and body as received on the wire, capped at the CaptureRaw limit.
+//
+// Returns nil if the Handle wasn't started with CaptureRaw.
+func RawBytes(ctx context.Context) []byte {
+	c, ok := ctx.Value(captureKey{}).(*captureConn)
+	if !ok {
+		return nil
+	}
+	return c.take()
+}
+
+// captureListener wraps accepted connections in captureConns.
+type captureListener struct {
+	net.Listener
+
+	limit int
+}
+
+func (ln captureListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{Conn: c, limit: ln.limit}, nil
+}
+
+// captureConn tees everything read from the connection into a bounded buffer.
+type captureConn struct {
+	net.Conn
+
+	limit int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if remaining := c.limit - len(c.buf); remaining > 0 {
+			if remaining > n {
+				remaining = n
+			}
+			c.buf = append(c.buf, b[:remaining]...)
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *captureConn) take() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := c.buf
+	c.buf = nil
+	return out
+}
+
+// captureConnContext stores the captureConn in the connection's base context,
+// chaining to any ConnContext the server already had.
+func captureConnContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		if cc, ok := c.(*captureConn); ok {
+			ctx = context.WithValue(ctx, captureKey{}, cc)
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,400 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxClientHelloBytes bounds how much of a connection is buffered while
+// looking for the ClientHello.
+const maxClientHelloBytes = 1 << 16
+
+type fingerprintKey struct{}
+
+// FingerprintTLS is an option for a TLS Handle that records the ClientHello
+// of each connection, so handlers can identify the TLS library a client uses
+// through TLSFingerprint. It has no effect on a plain HTTP Handle.
+func FingerprintTLS() HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.fingerprint = true
+	})
+}
+
+// Fingerprint identifies the TLS client that sent a ClientHello.
+type Fingerprint struct {
+	// JA3 is the JA3 string: version, ciphers, extensions, curves and point
+	// formats, with GREASE values removed.
+	JA3 string
+	// JA3Hash is the MD5 hash of JA3, as used by JA3 databases.
+	JA3Hash string
+	// JA4 is the JA4 fingerprint.
+	JA4 string
+}
+
+// TLSFingerprint returns the fingerprint of the ClientHello sent on the
+// connection serving the request with the given context. It returns nil if
+// the Handle wasn't started with FingerprintTLS or the ClientHello couldn't be
+// parsed.
+func TLSFingerprint(ctx context.Context) *Fingerprint {
+	c, ok := ctx.Value(fingerprintKey{}).(*helloConn)
+	if !ok {
+		return nil
+	}
+	return c.fingerprint()
+}
+
+// helloListener wraps accepted connections in helloConns.
+type helloListener struct {
+	net.Listener
+}
+
+func (ln helloListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c}, nil
+}
+
+// helloConn buffers what is read from the connection until it holds a whole
+// ClientHello.
+type helloConn struct {
+	net.Conn
+
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+
+	once sync.Once
+	fp   *Fingerprint
+}
+
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.done {
+			c.buf = append(c.buf, b[:n]...)
+			_, complete := clientHello(c.buf)
+			c.done = complete || len(c.buf) >= maxClientHelloBytes
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *helloConn) fingerprint() *Fingerprint {
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if hello, complete := clientHello(c.buf); complete {
+			if h, err := parseClientHello(hello); err == nil {
+				c.fp = h.fingerprint()
+			}
+		}
+		c.buf = nil
+	})
+	return c.fp
+}
+
+// fingerprintConnContext stores the helloConn in the connection's base
+// context, chaining to any ConnContext the server already had.
+func fingerprintConnContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		if tc, ok := c.(*tls.Conn); ok {
+			c = tc.NetConn()
+		}
+		if hc, ok := c.(*helloConn); ok {
+			ctx = context.WithValue(ctx, fingerprintKey{}, hc)
+		}
+		return ctx
+	}
+}
+
+// clientHello returns the handshake message at the start of the TLS records
+// in buf, and whether all of it has been received.
+func clientHello(buf []byte) ([]byte, bool) {
+	var msg []byte
+	for len(buf) >= 5 && buf[0] == 0x16 {
+		n := int(binary.BigEndian.Uint16(buf[3:5]))
+		if len(buf) < 5+n {
+			break
+		}
+		msg = append(msg, buf[5:5+n]...)
+		buf = buf[5+n:]
+		if len(msg) >= 4 {
+			size := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if len(msg) >= size {
+				return msg[:size], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// helloFields are the parts of a ClientHello that fingerprints are made of.
+type helloFields struct {
+	version        uint16
+	ciphers        []uint16
+	extensions     []uint16
+	curves         []uint16
+	points         []uint8
+	sigAlgs        []uint16
+	versions       []uint16
+	alpn           string
+	serverNameSent bool
+}
+
+var errShortHello = errors.New("truncated ClientHello")
+
+// helloReader reads big-endian, length-prefixed ClientHello fields.
+type helloReader []byte
+
+func (r *helloReader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(*r) < n {
+		return nil, errShortHello
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, nil
+}
+
+func (r *helloReader) uint(size int) (int, error) {
+	b, err := r.bytes(size)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n, nil
+}
+
+// vector reads a field prefixed with its length in lenSize bytes.
+func (r *helloReader) vector(lenSize int) (helloReader, error) {
+	n, err := r.uint(lenSize)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.bytes(n)
+	return helloReader(b), err
+}
+
+func (r helloReader) uint16s() []uint16 {
+	out := make([]uint16, 0, len(r)/2)
+	for i := 0; i+1 < len(r); i += 2 {
+		out = append(out, binary.BigEndian.Uint16(r[i:]))
+	}
+	return out
+}
+
+func parseClientHello(msg []byte) (*helloFields, error) {
+	if len(msg) < 4 || msg[0] != 0x01 {
+		return nil, errors.New("not a ClientHello")
+	}
+	r := helloReader(msg[4:])
+	h := &helloFields{}
+
+	version, err := r.uint(2)
+	if err != nil {
+		return nil, err
+	}
+	h.version = uint16(version)
+	if _, err := r.bytes(32); err != nil { // random
+		return nil, err
+	}
+	if _, err := r.vector(1); err != nil { // session ID
+		return nil, err
+	}
+	ciphers, err := r.vector(2)
+	if err != nil {
+		return nil, err
+	}
+	h.ciphers = ciphers.uint16s()
+	if _, err := r.vector(1); err != nil { // compression methods
+		return nil, err
+	}
+	if len(r) == 0 {
+		return h, nil
+	}
+
+	exts, err := r.vector(2)
+	if err != nil {
+		return nil, err
+	}
+	for len(exts) > 0 {
+		typ, err := exts.uint(2)
+		if err != nil {
+			return nil, err
+		}
+		data, err := exts.vector(2)
+		if err != nil {
+			return nil, err
+		}
+		h.extensions = append(h.extensions, uint16(typ))
+
+		switch typ {
+		case 0x0000: // server_name
+			h.serverNameSent = true
+		case 0x000a: // supported_groups
+			if v, err := data.vector(2); err == nil {
+				h.curves = v.uint16s()
+			}
+		case 0x000b: // ec_point_formats
+			if v, err := data.vector(1); err == nil {
+				h.points = []uint8(v)
+			}
+		case 0x000d: // signature_algorithms
+			if v, err := data.vector(2); err == nil {
+				h.sigAlgs = v.uint16s()
+			}
+		case 0x0010: // application_layer_protocol_negotiation
+			if list, err := data.vector(2); err == nil {
+				if proto, err := list.vector(1); err == nil {
+					h.alpn = string(proto)
+				}
+			}
+		case 0x002b: // supported_versions
+			if v, err := data.vector(1); err == nil {
+				h.versions = v.uint16s()
+			}
+		}
+	}
+	return h, nil
+}
+
+// isGREASE reports whether v is one of the reserved GREASE values (RFC 8701)
+// that clients insert at random and fingerprints ignore.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func joinDecimal[T uint8 | uint16](values []T) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, "-")
+}
+
+func (h *helloFields) fingerprint() *Fingerprint {
+	ja3 := fmt.Sprintf("%d,%s,%s,%s,%s",
+		h.version,
+		joinDecimal(withoutGREASE(h.ciphers)),
+		joinDecimal(withoutGREASE(h.extensions)),
+		joinDecimal(withoutGREASE(h.curves)),
+		joinDecimal(h.points),
+	)
+	sum := md5.Sum([]byte(ja3))
+	return &Fingerprint{
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(sum[:]),
+		JA4:     h.ja4(),
+	}
+}
+
+// ja4 computes the JA4 fingerprint of a TLS-over-TCP ClientHello.
+func (h *helloFields) ja4() string {
+	ciphers := withoutGREASE(h.ciphers)
+	extensions := withoutGREASE(h.extensions)
+
+	// TLS 1.3 clients put the versions they support in an extension and
+	// leave the legacy version at TLS 1.2.
+	version := h.version
+	if versions := withoutGREASE(h.versions); len(versions) > 0 {
+		version = versions[0]
+		for _, v := range versions {
+			version = max(version, v)
+		}
+	}
+	versions := map[uint16]string{0x0304: "13", 0x0303: "12", 0x0302: "11", 0x0301: "10", 0x0300: "s3"}
+	ver, ok := versions[version]
+	if !ok {
+		ver = "00"
+	}
+
+	sni := "i"
+	if h.serverNameSent {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if h.alpn != "" {
+		first, last := h.alpn[0], h.alpn[len(h.alpn)-1]
+		if isAlnum(first) && isAlnum(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			encoded := hex.EncodeToString([]byte(h.alpn))
+			alpn = encoded[:1] + encoded[len(encoded)-1:]
+		}
+	}
+
+	var sortedExts []uint16
+	for _, e := range extensions {
+		if e != 0x0000 && e != 0x0010 {
+			sortedExts = append(sortedExts, e)
+		}
+	}
+	extHash := ja4Hash(sortedHex(sortedExts))
+	if len(sortedExts) > 0 && len(h.sigAlgs) > 0 {
+		extHash = ja4Hash(sortedHex(sortedExts) + "_" + hexList(h.sigAlgs))
+	}
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s",
+		ver, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn,
+		ja4Hash(sortedHex(ciphers)), extHash)
+}
+
+func isAlnum(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// sortedHex returns values as sorted, comma-separated 4-digit hex numbers.
+func sortedHex(values []uint16) string {
+	sorted := append([]uint16(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return hexList(sorted)
+}
+
+func hexList(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja4Hash returns the truncated SHA-256 used in JA4, or zeros for an empty
+// list.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// extension is a ClientHello extension for buildHello.
+type extension struct {
+	typ  uint16
+	data []byte
+}
+
+// u16s encodes values as big-endian uint16s.
+func u16s(values ...uint16) []byte {
+	b := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+// vec prefixes b with its length in lenSize bytes.
+func vec(lenSize int, b []byte) []byte {
+	out := make([]byte, lenSize, lenSize+len(b))
+	for i, n := lenSize-1, len(b); i >= 0; i, n = i-1, n>>8 {
+		out[i] = byte(n)
+	}
+	return append(out, b...)
+}
+
+// buildHello returns a ClientHello handshake message.
+func buildHello(version uint16, ciphers []uint16, exts []extension) []byte {
+	body := u16s(version)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, vec(1, make([]byte, 32))...)
+	body = append(body, vec(2, u16s(ciphers...))...)
+	body = append(body, vec(1, []byte{0})...)
+	if exts != nil {
+		var b []byte
+		for _, e := range exts {
+			b = append(b, u16s(e.typ)...)
+			b = append(b, vec(2, e.data)...)
+		}
+		body = append(body, vec(2, b)...)
+	}
+	return append([]byte{0x01}, vec(3, body)...)
+}
+
+// record wraps a handshake message fragment in a TLS record.
+func record(fragment []byte) []byte {
+	return append([]byte{0x16, 0x03, 0x01}, vec(2, fragment)...)
+}
+
+// chromeHello is a ClientHello as sent by Chrome, GREASE values included,
+// whose JA4 is the worked example from the JA4 specification.
+var chromeHello = buildHello(0x0303,
+	[]uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+	[]extension{
+		{0x2a2a, nil},
+		{0x0000, vec(2, append([]byte{0}, vec(2, []byte("example.com"))...))},
+		{0x0017, nil},
+		{0xff01, []byte{0}},
+		{0x000a, vec(2, u16s(0x3a3a, 0x001d, 0x0017, 0x0018))},
+		{0x000b, vec(1, []byte{0})},
+		{0x0023, nil},
+		{0x0010, vec(2, append(vec(1, []byte("h2")), vec(1, []byte("http/1.1"))...))},
+		{0x0005, []byte{1, 0, 0, 0, 0}},
+		{0x000d, vec(2, u16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))},
+		{0x0012, nil},
+		{0x0033, vec(2, nil)},
+		{0x002d, vec(1, []byte{1})},
+		{0x002b, vec(1, u16s(0x4a4a, 0x0304, 0x0303))},
+		{0x001b, vec(1, u16s(0x0002))},
+		{0x4469, vec(2, vec(1, []byte("h2")))},
+		{0x0015, make([]byte, 8)},
+		{0x1a1a, []byte{0}},
+	},
+)
+
+func TestClientHelloFingerprints(t *testing.T) {
+	tests := []struct {
+		name    string
+		hello   []byte
+		ja3     string
+		ja3Hash string
+		ja4     string
+	}{
+		{
+			name:    "Chrome",
+			hello:   chromeHello,
+			ja3:     "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+			ja3Hash: "cd08e31494f9531f560d64c695473da9",
+			ja4:     "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name:    "no extensions",
+			hello:   buildHello(0x0303, []uint16{0x002f, 0x0035}, nil),
+			ja3:     "771,47-53,,,",
+			ja3Hash: "577fbfd57b256f5467f2fe09d1105a26",
+			ja4:     "t12i020000_f54dd463d39b_000000000000",
+		},
+		{
+			name: "TLS 1.2 without SNI",
+			hello: buildHello(0x0303, []uint16{0x002f}, []extension{
+				{0x000a, vec(2, u16s(0x0017))},
+				{0x000b, vec(1, []byte{0})},
+				{0x000d, vec(2, u16s(0x0403))},
+				{0x0010, vec(2, vec(1, []byte("http/1.1")))},
+			}),
+			ja3:     "771,47,10-11-13-16,23,0",
+			ja3Hash: "9ef11fa6ffc6f0c70a20a6ad297fbc35",
+			ja4:     "t12i0104h1_ba72b8082249_2bbaf9536c97",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseClientHello(tt.hello)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fp := h.fingerprint()
+			if fp.JA3 != tt.ja3 {
+				t.Errorf("JA3 = %q, want %q", fp.JA3, tt.ja3)
+			}
+			if fp.JA3Hash != tt.ja3Hash {
+				t.Errorf("JA3 hash = %q, want %q", fp.JA3Hash, tt.ja3Hash)
+			}
+			if fp.JA4 != tt.ja4 {
+				t.Errorf("JA4 = %q, want %q", fp.JA4, tt.ja4)
+			}
+		})
+	}
+}
+
+func TestMalformedClientHellos(t *testing.T) {
+	// withLength replaces the two bytes at off, a vector length, with n.
+	withLength := func(off int, n uint16) []byte {
+		b := append([]byte(nil), chromeHello...)
+		binary.BigEndian.PutUint16(b[off:], n)
+		return b
+	}
+	// Offsets into chromeHello: 4 header, 2 version, 32 random, 33 session ID.
+	const ciphersOff = 4 + 2 + 32 + 33
+	extsOff := ciphersOff + 2 + 2*16 + 2
+
+	tests := []struct {
+		name  string
+		hello []byte
+	}{
+		{"empty", nil},
+		{"header only", chromeHello[:4]},
+		{"not a ClientHello", append([]byte{0x02}, chromeHello[1:]...)},
+		{"truncated random", chromeHello[:20]},
+		{"cipher suites overrun", withLength(ciphersOff, 0xfff0)},
+		{"extensions overrun", withLength(extsOff, 0xfff0)},
+		{"extension overruns the block", withLength(extsOff+2+2, 0x0100)},
+		{"truncated extension header", append(withLength(extsOff, 3), 0, 0, 0)[:extsOff+2+3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseClientHello(tt.hello); err == nil {
+				t.Error("parsed without an error")
+			}
+		})
+	}
+
+	// Every cut into the extensions must be an error, and no cut may panic.
+	for n := 0; n < len(chromeHello); n++ {
+		_, err := parseClientHello(chromeHello[:n])
+		if n > extsOff && err == nil {
+			t.Errorf("hello cut to %d bytes parsed without an error", n)
+		}
+	}
+}
+
+func TestClientHelloRecords(t *testing.T) {
+	half := len(chromeHello) / 2
+	tests := []struct {
+		name     string
+		buf      []byte
+		complete bool
+	}{
+		{"one record", record(chromeHello), true},
+		{"split across records", append(record(chromeHello[:half]), record(chromeHello[half:])...), true},
+		{"trailing data", append(record(chromeHello), 0x17, 0x03, 0x03), true},
+		{"partial record", record(chromeHello)[:100], false},
+		{"second record missing", record(chromeHello[:half]), false},
+		{"not a handshake record", append([]byte{0x17}, record(chromeHello)[1:]...), false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, complete := clientHello(tt.buf)
+			if complete != tt.complete {
+				t.Fatalf("complete = %v, want %v", complete, tt.complete)
+			}
+			if complete && string(msg) != string(chromeHello) {
+				t.Error("reassembled message differs from the ClientHello sent")
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // HandleOption customizes the behavior of a Handle.
@@ -68,6 +72,32 @@ type Handle struct {
 
 	// Function used to build dialers. Defaults to newDialer.
 	newDialerFunc func() dialer
+
+	// Maximum number of raw bytes captured per connection. Capturing is
+	// disabled if this is zero.
+	captureLimit int
+
+	// TLS configuration used to serve HTTPS. Plain HTTP is served if this is
+	// nil.
+	tlsConfig *tls.Config
+
+	// TLS versions and cipher suites set by TLSPolicy. Zero values keep
+	// those of tlsConfig.
+	tlsMinVersion   uint16
+	tlsMaxVersion   uint16
+	tlsCipherSuites []uint16
+
+	// Certificate manager set up by WithAutocert, and the directory its
+	// certificates are cached in.
+	autocert         *autocert.Manager
+	autocertCacheDir string
+
+	// Whether to record the ClientHello of each TLS connection.
+	fingerprint bool
+
+	// Policy for reading PROXY protocol headers. Headers aren't read if
+	// this is nil.
+	proxyPolicy *proxyproto.Policy
 }
 
 // NewHandle builds a Handle to the given HTTP server. You can use the
@@ -130,9 +160,11 @@ func (h *Handle) Start(ctx context.Context) error {
 
 	// http.Server defaults to ":http" if Addr is empty. For our purposes,
 	// ":0" is more desirable since we almost never listen on port 80.
-	addr := h.srv.Addr
-	if addr == "" {
-		addr = ":0"
+	// NormalizeAddr takes care of that, and rejects malformed addresses
+	// before we get a less helpful error out of Listen.
+	addr, err := NormalizeAddr(h.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting HTTP server: %v", err)
 	}
 
 	// Most errors that occur when starting an http.Server are actually Listen
@@ -142,12 +174,33 @@ func (h *Handle) Start(ctx context.Context) error {
 		return fmt.Errorf("error starting HTTP server on %q: %v", addr, err)
 	}
 
+	if h.autocert != nil && h.autocertCacheDir != "" {
+		h.autocert.Cache = autocert.DirCache(h.autocertCacheDir)
+	}
+
+	serveLn := ln
+	if h.proxyPolicy != nil {
+		serveLn = proxyproto.NewListener(serveLn, *h.proxyPolicy)
+		h.srv.ConnContext = proxyConnContext(h.srv.ConnContext)
+	}
+	switch {
+	case h.tlsConfig != nil:
+		if h.fingerprint {
+			serveLn = helloListener{Listener: serveLn}
+			h.srv.ConnContext = fingerprintConnContext(h.srv.ConnContext)
+		}
+		serveLn = tls.NewListener(serveLn, h.serverTLSConfig())
+	case h.captureLimit > 0:
+		serveLn = captureListener{Listener: serveLn, limit: h.captureLimit}
+		h.srv.ConnContext = captureConnContext(h.srv.ConnContext)
+	}
+
 	errCh := make(chan error, 1)
 	go func() {
 		// Serve blocks until it encounters an error or until the server shuts
 		// down, so we need to call it in a separate goroutine. Errors here
 		// (apart from http.ErrServerClosed) are rare.
-		err := h.srv.Serve(ln)
+		err := h.srv.Serve(serveLn)
 		errCh <- err
 
 		// Close the channel so that if shutdown is called on this Handle
@@ -169,7 +222,7 @@ func (h *Handle) Start(ctx context.Context) error {
 	// srv.Serve has transitioned the server to the running state,
 	// srv.Shutdown will return right away but srv.Serve will run forever.
 	d := h.newDialerFunc()
-	if err := waitUntilAvailable(ctx, d, ln.Addr().String()); err != nil {
+	if err := waitUntilAvailable(ctx, d, ln.Addr().Network(), ln.Addr().String(), h.tlsConfig != nil, h.proxyPolicy); err != nil {
 		select {
 		case err := <-errCh:
 			// If the server failed to start up, errCh probably has a more
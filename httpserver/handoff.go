@@ -0,0 +1,227 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InheritedFDsEnv is the environment variable used to pass listening sockets
+// from a parent process to its replacement during a Handoff. It holds a
+// comma-separated list of address=fd pairs.
+const InheritedFDsEnv = "HTTPSERVER_INHERITED_FDS"
+
+// ReadyFDEnv is the environment variable holding the file descriptor on which
+// the replacement started by a Handoff reports, through NotifyReady, that it
+// is serving.
+const ReadyFDEnv = "HTTPSERVER_READY_FD"
+
+// Subset of the net.Listener implementations that can expose their underlying
+// file descriptor.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// InheritedListenFunc wraps a listen function so that sockets handed down by
+// a parent process through Handoff are reused instead of being opened again.
+// Addresses that were not inherited fall back to the provided function.
+//
+//	h := httpserver.NewHandle(srv, httpserver.ListenFunc(
+//	  httpserver.InheritedListenFunc(httpserver.DefaultListenFunc),
+//	))
+func InheritedListenFunc(fallback func(string, string) (net.Listener, error)) func(string, string) (net.Listener, error) {
+	inherited := parseInheritedFDs(os.Getenv(InheritedFDsEnv))
+
+	return func(network, address string) (net.Listener, error) {
+		fd, ok := inherited[address]
+		if !ok {
+			return fallback(network, address)
+		}
+		// Each inherited socket may only be claimed once.
+		delete(inherited, address)
+
+		f := os.NewFile(uintptr(fd), address)
+		defer f.Close()
+
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited listener for %q: %v", address, err)
+		}
+		if tcpListener, ok := ln.(*net.TCPListener); ok {
+			ln = tcpKeepAliveListener{tcpListener}
+		}
+		return ln, nil
+	}
+}
+
+func parseInheritedFDs(env string) map[string]int {
+	fds := make(map[string]int)
+	for _, pair := range strings.Split(env, ",") {
+		idx := strings.LastIndex(pair, "=")
+		if idx < 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(pair[idx+1:])
+		if err != nil {
+			continue
+		}
+		fds[pair[:idx]] = fd
+	}
+	return fds
+}
+
+// Handoff starts a new copy of the running executable with the same arguments
+// and passes it the listening sockets of the given handles. The new process
+// picks them up through InheritedListenFunc and starts accepting connections
+// on them right away. Handoff returns once it has called NotifyReady, after
+// which the current process can drain its in-flight connections with
+// Shutdown. If it exits or doesn't become ready within timeout, it is killed
+// and an error is returned, leaving the current process serving.
+//
+// Limitations:
+//
+//   - Sockets are matched by the configured address (Server.Addr), so the
+//     replacement must be configured with the same addresses. Listeners
+//     started on an OS-assigned port (":0") cannot be handed off.
+//   - Only the sockets of the given handles are passed on. A replacement that
+//     listens on anything else must not do so while this process still is.
+//   - Passing file descriptors to child processes is not supported on
+//     Windows.
+//   - The replacement is a child of the current process. When running under a
+//     supervisor that tracks the main PID, the supervisor must be told about
+//     the new process.
+func Handoff(timeout time.Duration, handles ...*Handle) (*os.Process, error) {
+	var (
+		files []*os.File
+		pairs []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, h := range handles {
+		if h.ln == nil {
+			return nil, errors.New("server is not running")
+		}
+		// Start listens on the normalized address, which is also what
+		// InheritedListenFunc will be asked for in the replacement.
+		addr, err := NormalizeAddr(h.srv.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(addr, ":0") {
+			return nil, fmt.Errorf("cannot hand off listener with OS-assigned port %q", addr)
+		}
+		fl, ok := h.ln.(filer)
+		if !ok {
+			return nil, fmt.Errorf("listener for %q does not expose a file descriptor", addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file for listener %q: %v", addr, err)
+		}
+
+		// ExtraFiles entry i becomes file descriptor 3+i in the child.
+		pairs = append(pairs, fmt.Sprintf("%s=%d", addr, 3+len(files)))
+		files = append(files, f)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %v", err)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create readiness pipe: %v", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(withoutEnv(withoutEnv(os.Environ(), InheritedFDsEnv), ReadyFDEnv),
+		InheritedFDsEnv+"="+strings.Join(pairs, ","),
+		fmt.Sprintf("%s=%d", ReadyFDEnv, 3+len(files)),
+	)
+
+	err = cmd.Start()
+	// Only the child may hold the write end, so that the read below sees EOF
+	// if it exits.
+	readyW.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start replacement process: %v", err)
+	}
+
+	if err := waitReady(ready, timeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("replacement process failed to start: %v", err)
+	}
+	return cmd.Process, nil
+}
+
+// waitReady waits for the replacement to write to the readiness pipe.
+func waitReady(ready *os.File, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := ready.Read(b[:])
+		errCh <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		if errors.Is(err, io.EOF) {
+			return errors.New("exited before it was ready")
+		}
+		return err
+	case <-timer.C:
+		return fmt.Errorf("not ready after %v", timeout)
+	}
+}
+
+// NotifyReady tells the process that started this one through Handoff that
+// it is serving, so that process can drain and exit. It does nothing if this
+// process wasn't started by a Handoff.
+func NotifyReady() error {
+	env := os.Getenv(ReadyFDEnv)
+	if env == "" {
+		return nil
+	}
+	// Processes this one starts mustn't report for it.
+	os.Unsetenv(ReadyFDEnv)
+
+	fd, err := strconv.Atoi(env)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", ReadyFDEnv, env)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to notify parent process: %v", err)
+	}
+	return nil
+}
+
+func withoutEnv(env []string, key string) []string {
+	out := env[:0:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
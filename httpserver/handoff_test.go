@@ -0,0 +1,58 @@
+package httpserver
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyReadyWakesParent(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	t.Setenv(ReadyFDEnv, strconv.Itoa(int(w.Fd())))
+
+	// NotifyReady closes the write end once it has reported.
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitReady(r, time.Second); err != nil {
+		t.Errorf("waitReady = %v, want nil", err)
+	}
+	if env := os.Getenv(ReadyFDEnv); env != "" {
+		t.Errorf("%s still set to %q", ReadyFDEnv, env)
+	}
+}
+
+func TestNotifyReadyWithoutParent(t *testing.T) {
+	t.Setenv(ReadyFDEnv, "")
+	if err := NotifyReady(); err != nil {
+		t.Errorf("NotifyReady = %v, want nil", err)
+	}
+}
+
+func TestWaitReadyFailures(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := waitReady(r, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("waitReady with a silent child = %v, want a timeout", err)
+	}
+
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Close()
+	if err := waitReady(r, time.Second); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("waitReady after the child exited = %v, want an error", err)
+	}
+}
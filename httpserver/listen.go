@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// UnixAddrPrefix marks a listen address as the path of a unix socket,
+	// e.g. "unix:/run/ssrf-sheriff/http.sock".
+	UnixAddrPrefix = "unix:"
+
+	// SystemdAddrPrefix marks a listen address as a socket passed by systemd
+	// socket activation, named by its FileDescriptorName= (e.g.
+	// "systemd:http") or by its position among the passed sockets, starting
+	// at 0 (e.g. "systemd:0").
+	SystemdAddrPrefix = "systemd:"
+)
+
+// UnixListenFunc wraps a listen function so that addresses starting with
+// "unix:" are listened on as unix sockets, so the server can sit behind a
+// local reverse proxy. A stale socket file left behind by an earlier process
+// is removed first; one that is still accepting connections is an error.
+// Other addresses fall back to the provided function.
+//
+// The socket file isn't removed when the listener is closed, so that it
+// keeps working for the replacement process after a Handoff.
+//
+//	h := httpserver.NewHandle(srv, httpserver.ListenFunc(
+//	  httpserver.UnixListenFunc(httpserver.DefaultListenFunc),
+//	))
+func UnixListenFunc(fallback func(string, string) (net.Listener, error)) func(string, string) (net.Listener, error) {
+	return func(network, address string) (net.Listener, error) {
+		path, ok := strings.CutPrefix(address, UnixAddrPrefix)
+		if !ok {
+			return fallback(network, address)
+		}
+
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("unix socket %q is already in use", path)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("failed to remove stale unix socket %q: %v", path, err)
+			}
+		}
+
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		return ln, nil
+	}
+}
+
+// systemdListener is a socket passed by systemd socket activation.
+type systemdListener struct {
+	fd   int
+	name string
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdMu        sync.Mutex
+	systemdListeners []systemdListener
+)
+
+// parseSystemdListeners returns the sockets passed with LISTEN_FDS and
+// LISTEN_FDNAMES (see sd_listen_fds(3)). They are only read the first time,
+// and the variables are then cleared so that child processes don't mistake
+// them for their own.
+func parseSystemdListeners() []systemdListener {
+	systemdOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			// Passed sockets start right after stdin, stdout and stderr.
+			l := systemdListener{fd: 3 + i}
+			if i < len(names) {
+				l.name = names[i]
+			}
+			systemdListeners = append(systemdListeners, l)
+		}
+	})
+	return systemdListeners
+}
+
+// SystemdListenFunc wraps a listen function so that addresses starting with
+// "systemd:" use the matching socket passed by systemd socket activation,
+// letting the server run as a socket-activated unit. Each socket may only be
+// claimed once, by any of the functions returned. Other addresses fall back
+// to the provided function.
+//
+//	h := httpserver.NewHandle(srv, httpserver.ListenFunc(
+//	  httpserver.SystemdListenFunc(httpserver.DefaultListenFunc),
+//	))
+func SystemdListenFunc(fallback func(string, string) (net.Listener, error)) func(string, string) (net.Listener, error) {
+	listeners := parseSystemdListeners()
+
+	return func(network, address string) (net.Listener, error) {
+		name, ok := strings.CutPrefix(address, SystemdAddrPrefix)
+		if !ok {
+			return fallback(network, address)
+		}
+		if len(listeners) == 0 {
+			return nil, errors.New("no sockets were passed by systemd")
+		}
+
+		systemdMu.Lock()
+		defer systemdMu.Unlock()
+
+		index := -1
+		for i, l := range listeners {
+			// Several sockets can share a name, e.g. one per address family.
+			if l.name == name && l.fd >= 0 {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(listeners) {
+				index = i
+			}
+		}
+		if index < 0 || listeners[index].fd < 0 {
+			return nil, fmt.Errorf("no unclaimed socket %q was passed by systemd", name)
+		}
+		fd := listeners[index].fd
+		listeners[index].fd = -1
+
+		f := os.NewFile(uintptr(fd), address)
+		defer f.Close()
+
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use systemd socket %q: %v", name, err)
+		}
+		if tcpListener, ok := ln.(*net.TCPListener); ok {
+			ln = tcpKeepAliveListener{tcpListener}
+		}
+		return ln, nil
+	}
+}
@@ -0,0 +1,57 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+)
+
+type proxyAddrKey struct{}
+
+// ProxyProtocol is an option for Handle that reads a PROXY protocol header
+// from the connections of the proxies the policy trusts, so that requests'
+// RemoteAddr is the address of the client the proxy relays for. The proxy's
+// own address is available to handlers through ProxyAddr.
+func ProxyProtocol(policy proxyproto.Policy) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.proxyPolicy = &policy
+	})
+}
+
+// ProxyAddr returns the address of the proxy that relayed the connection
+// serving the request with the given context, or nil if it wasn't relayed
+// with the PROXY protocol.
+func ProxyAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(proxyAddrKey{}).(net.Addr)
+	return addr
+}
+
+// proxyConnContext stores the address of the proxy that relayed the
+// connection in its base context, chaining to any ConnContext the server
+// already had.
+func proxyConnContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		// Unwrap the connections layered on top by TLS and the other
+		// Handle options.
+		for {
+			if addr := proxyproto.ProxyAddr(c); addr != nil {
+				return context.WithValue(ctx, proxyAddrKey{}, addr)
+			}
+			switch conn := c.(type) {
+			case *tls.Conn:
+				c = conn.NetConn()
+			case *helloConn:
+				c = conn.Conn
+			case *captureConn:
+				c = conn.Conn
+			default:
+				return ctx
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package httpserver
+
+import "crypto/tls"
+
+// TLS is an option for Handle that serves HTTPS with the given configuration
+// instead of plain HTTP. The configuration must contain at least one
+// certificate or set GetCertificate.
+//
+// CaptureRaw has no effect on a TLS Handle, since the bytes on the wire are
+// encrypted.
+func TLS(config *tls.Config) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.tlsConfig = config
+	})
+}
+
+// TLSPolicy is an option for a TLS Handle that limits the TLS versions it
+// negotiates to minVersion through maxVersion, and the cipher suites it
+// negotiates up to TLS 1.2 to cipherSuites, whose order doesn't matter. Zero
+// versions and an empty list keep Go's defaults.
+//
+// Setting minVersion below TLS 1.2 or listing suites Go considers insecure
+// enables them, which is useful to see whether clients accept weak TLS.
+func TLSPolicy(minVersion, maxVersion uint16, cipherSuites []uint16) HandleOption {
+	return handleOptionFunc(func(h *Handle) {
+		h.tlsMinVersion = minVersion
+		h.tlsMaxVersion = maxVersion
+		h.tlsCipherSuites = cipherSuites
+	})
+}
+
+// serverTLSConfig returns the TLS configuration to serve with: the one given
+// to TLS or WithAutocert, restricted by TLSPolicy.
+func (h *Handle) serverTLSConfig() *tls.Config {
+	if h.tlsMinVersion == 0 && h.tlsMaxVersion == 0 && len(h.tlsCipherSuites) == 0 {
+		return h.tlsConfig
+	}
+	config := h.tlsConfig.Clone()
+	if h.tlsMinVersion != 0 {
+		config.MinVersion = h.tlsMinVersion
+	}
+	if h.tlsMaxVersion != 0 {
+		config.MaxVersion = h.tlsMaxVersion
+	}
+	if len(h.tlsCipherSuites) > 0 {
+		config.CipherSuites = h.tlsCipherSuites
+	}
+	return config
+}
@@ -2,8 +2,12 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
 )
 
 var _invalidHTTPRequestLine = []byte("INVALID\n\n")
@@ -24,8 +28,14 @@ var _ dialer = (*net.Dialer)(nil)
 // HTTP request. Instead of sending a valid one which could end up calling the
 // user-provided request handler, we send one that will be rejected by the
 // HTTP server implementation without crashing.
-func waitUntilAvailable(ctx context.Context, d dialer, addr string) error {
-	conn, err := d.DialContext(ctx, "tcp", addr)
+//
+// For HTTPS servers, which just hang up on an invalid request line, a TLS
+// handshake is attempted instead. A handshake the server refuses with an
+// alert shows it is serving as well: WithAutocert handles refuse ours, which
+// has no server name, rather than obtain a certificate during startup. If the server expects a PROXY header from
+// us, a LOCAL one is sent first.
+func waitUntilAvailable(ctx context.Context, d dialer, network, addr string, useTLS bool, proxyPolicy *proxyproto.Policy) error {
+	conn, err := d.DialContext(ctx, network, addr)
 	if err != nil {
 		return wrapNetErr(err, "failed to dial to %q", addr)
 	}
@@ -40,6 +50,23 @@ func waitUntilAvailable(ctx context.Context, d dialer, addr string) error {
 		}
 	}
 
+	if proxyPolicy != nil && proxyPolicy.Trusts(conn.LocalAddr()) {
+		if _, err := conn.Write(proxyproto.LocalHeader); err != nil {
+			return wrapNetErr(err, "failed to write PROXY header to server")
+		}
+	}
+
+	if useTLS {
+		// The server's certificate doesn't matter here, only that it
+		// answered the handshake. Old versions are offered too, for servers
+		// restricted to them by TLSPolicy.
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
+		if err := tlsConn.HandshakeContext(ctx); err != nil && !isRemoteAlert(err) {
+			return wrapNetErr(err, "failed to complete TLS handshake with server")
+		}
+		return nil
+	}
+
 	if _, err := conn.Write(_invalidHTTPRequestLine); err != nil {
 		return wrapNetErr(err, "failed to write request to server")
 	}
@@ -54,6 +81,12 @@ func waitUntilAvailable(ctx context.Context, d dialer, addr string) error {
 	return nil
 }
 
+// isRemoteAlert reports whether err is a TLS alert sent by the server.
+func isRemoteAlert(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
 // Similar to fmt.Errorf except net.Error timeouts are translated to
 // context.DeadlineExceeded.
 func wrapNetErr(err error, msg string, args ...interface{}) error {
@@ -0,0 +1,164 @@
+package ldapserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAP (RFC 4511). Only single-byte tags occur.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+// element is a decoded BER element: its tag byte and raw contents.
+type element struct {
+	tag   byte
+	value []byte
+}
+
+func (e element) constructed() bool { return e.tag&0x20 != 0 }
+
+// readElement reads one element from r, refusing ones longer than max. Input
+// that can't be decoded is reported as errProtocol.
+func readElement(r *bufio.Reader, max int) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	if tag&0x1f == 0x1f {
+		return element{}, fmt.Errorf("%w: multi-byte BER tags are not supported", errProtocol)
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return element{}, fmt.Errorf("%w: unsupported BER length of %d bytes", errProtocol, n)
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return element{}, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > max {
+		return element{}, fmt.Errorf("%w: BER element of %d bytes is too large", errProtocol, length)
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, value: value}, nil
+}
+
+// children decodes the contents of a constructed element.
+func (e element) children() ([]element, error) {
+	var (
+		children []element
+		rest     = e.value
+	)
+	for len(rest) > 0 {
+		child, n, err := parseElement(rest)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		rest = rest[n:]
+	}
+	return children, nil
+}
+
+// parseElement decodes the element at the start of b and returns it with its
+// encoded size.
+func parseElement(b []byte) (element, int, error) {
+	if len(b) < 2 {
+		return element{}, 0, io.ErrUnexpectedEOF
+	}
+	tag, length, offset := b[0], int(b[1]), 2
+	if tag&0x1f == 0x1f {
+		return element{}, 0, errors.New("multi-byte BER tags are not supported")
+	}
+	if b[1]&0x80 != 0 {
+		n := int(b[1] & 0x7f)
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return element{}, 0, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b)-offset < length {
+		return element{}, 0, io.ErrUnexpectedEOF
+	}
+	return element{tag: tag, value: b[offset : offset+length]}, offset + length, nil
+}
+
+// int decodes an INTEGER or ENUMERATED value.
+func (e element) int() int {
+	n := 0
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+// encode returns the BER encoding of an element with the given tag and
+// contents.
+func encode(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+// encodeInt returns the BER encoding of an INTEGER or ENUMERATED, in the
+// fewest two's complement bytes.
+func encodeInt(tag byte, n int) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if n >= -0x80 && n < 0x80 {
+			break
+		}
+		n >>= 8
+	}
+	return encode(tag, value)
+}
+
+func encodeString(s string) []byte { return encode(tagOctetString, []byte(s)) }
+
+// concat joins encoded elements into the contents of a constructed one.
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package ldapserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncodeInt(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x00, 0x80}},
+		{255, []byte{0x00, 0xff}},
+		{256, []byte{0x01, 0x00}},
+		{maxMessageID, []byte{0x7f, 0xff, 0xff, 0xff}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+		{-256, []byte{0xff, 0x00}},
+	}
+	for _, tt := range tests {
+		want := append([]byte{tagInteger, byte(len(tt.want))}, tt.want...)
+		got := encodeInt(tagInteger, tt.n)
+		if !bytes.Equal(got, want) {
+			t.Errorf("encodeInt(%d) = % x, want % x", tt.n, got, want)
+		}
+		if back := (element{value: got[2:]}).int(); back != tt.n {
+			t.Errorf("encodeInt(%d) decodes to %d", tt.n, back)
+		}
+	}
+}
+
+func TestReadMessageRejectsInvalidIDs(t *testing.T) {
+	// unbind wraps an UnbindRequest with the given encoded message ID.
+	unbind := func(id ...byte) []byte {
+		msg := append(encode(tagInteger, id), opUnbindRequest, 0x00)
+		return encode(tagSequence, msg)
+	}
+
+	tests := []struct {
+		name string
+		id   []byte
+	}{
+		{"negative", []byte{0xff}},
+		{"above maxInt", []byte{0x00, 0x80, 0x00, 0x00, 0x00}},
+		{"too long", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readMessage(bufio.NewReader(bytes.NewReader(unbind(tt.id...))))
+			if !errors.Is(err, errProtocol) {
+				t.Errorf("error = %v, want a protocol error", err)
+			}
+		})
+	}
+
+	id, op, err := readMessage(bufio.NewReader(bytes.NewReader(unbind(0x7f, 0xff, 0xff, 0xff))))
+	if err != nil || id != maxMessageID || op.tag != opUnbindRequest {
+		t.Errorf("readMessage = %d, 0x%02x, %v, want %d, unbind, nil", id, op.tag, err, maxMessageID)
+	}
+}
@@ -0,0 +1,138 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511, section 4.5.1)
+const (
+	filterAnd             = 0xa0
+	filterOr              = 0xa1
+	filterNot             = 0xa2
+	filterEquality        = 0xa3
+	filterSubstrings      = 0xa4
+	filterGreaterOrEqual  = 0xa5
+	filterLessOrEqual     = 0xa6
+	filterPresent         = 0x87
+	filterApprox          = 0xa8
+	filterExtensibleMatch = 0xa9
+)
+
+// maxFilterDepth bounds how deeply and, or and not filters may nest.
+const maxFilterDepth = 32
+
+// renderFilter returns the string representation (RFC 4515) of a search
+// filter, as it would appear in an ldap:// URL.
+func renderFilter(f element) (string, error) {
+	var b strings.Builder
+	if err := writeFilter(&b, f, 0); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeFilter(b *strings.Builder, f element, depth int) error {
+	if depth > maxFilterDepth {
+		return errors.New("filter nested too deeply")
+	}
+
+	switch f.tag {
+	case filterPresent:
+		fmt.Fprintf(b, "(%s=*)", f.value)
+		return nil
+	case filterAnd, filterOr, filterNot:
+		parts, err := f.children()
+		if err != nil {
+			return err
+		}
+		b.WriteString("(" + map[byte]string{filterAnd: "&", filterOr: "|", filterNot: "!"}[f.tag])
+		for _, part := range parts {
+			if err := writeFilter(b, part, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(")")
+		return nil
+	}
+
+	parts, err := f.children()
+	if err != nil {
+		return err
+	}
+	switch f.tag {
+	case filterEquality, filterGreaterOrEqual, filterLessOrEqual, filterApprox:
+		if len(parts) != 2 {
+			return errors.New("malformed attribute value assertion")
+		}
+		op := map[byte]string{filterEquality: "=", filterGreaterOrEqual: ">=", filterLessOrEqual: "<=", filterApprox: "~="}[f.tag]
+		fmt.Fprintf(b, "(%s%s%s)", parts[0].value, op, escapeValue(parts[1].value))
+	case filterSubstrings:
+		if len(parts) != 2 {
+			return errors.New("malformed substring filter")
+		}
+		substrings, err := parts[1].children()
+		if err != nil {
+			return err
+		}
+		var initial, final string
+		var any []string
+		for _, sub := range substrings {
+			switch sub.tag {
+			case 0x80:
+				initial = escapeValue(sub.value)
+			case 0x81:
+				any = append(any, escapeValue(sub.value))
+			case 0x82:
+				final = escapeValue(sub.value)
+			}
+		}
+		fmt.Fprintf(b, "(%s=%s*", parts[0].value, initial)
+		for _, s := range any {
+			b.WriteString(s + "*")
+		}
+		b.WriteString(final + ")")
+	case filterExtensibleMatch:
+		var rule, attr, value string
+		dn := false
+		for _, part := range parts {
+			switch part.tag {
+			case 0x81:
+				rule = string(part.value)
+			case 0x82:
+				attr = string(part.value)
+			case 0x83:
+				value = escapeValue(part.value)
+			case 0x84:
+				dn = len(part.value) > 0 && part.value[0] != 0
+			}
+		}
+		b.WriteString("(" + attr)
+		if dn {
+			b.WriteString(":dn")
+		}
+		if rule != "" {
+			b.WriteString(":" + rule)
+		}
+		b.WriteString(":=" + value + ")")
+	default:
+		return fmt.Errorf("unknown filter type 0x%02x", f.tag)
+	}
+	return nil
+}
+
+// escapeValue escapes the characters that can't appear literally in a filter
+// value.
+func escapeValue(v []byte) string {
+	var b strings.Builder
+	for _, c := range v {
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
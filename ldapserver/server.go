@@ -0,0 +1,371 @@
+// Package ldapserver implements an LDAP honeypot (RFC 4511) that accepts every
+// bind, answers every search with an entry holding the token, and logs bind
+// DNs, credentials and search requests. It detects SSRF through the ldap://
+// scheme and JNDI lookups, such as ${jndi:ldap://...} payloads, pointed at the
+// sheriff. The entries returned never carry Java object attributes, so a JNDI
+// client gets nothing to deserialize or load.
+package ldapserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"github.com/teknogeek/ssrf-sheriff/tcplistener"
+	"go.uber.org/zap"
+)
+
+const (
+	// idleTimeout is how long a connection may go without a request.
+	idleTimeout = 5 * time.Minute
+
+	// maxMessageBytes bounds a single request, so a client can't make the
+	// server allocate without limit.
+	maxMessageBytes = 1 << 20
+
+	// maxMessageID is maxInt from RFC 4511, the largest valid message ID.
+	maxMessageID = 1<<31 - 1
+)
+
+// Protocol operation tags
+const (
+	opBindRequest      = 0x60
+	opBindResponse     = 0x61
+	opUnbindRequest    = 0x42
+	opSearchRequest    = 0x63
+	opSearchEntry      = 0x64
+	opSearchDone       = 0x65
+	opModifyRequest    = 0x66
+	opModifyResponse   = 0x67
+	opAddRequest       = 0x68
+	opAddResponse      = 0x69
+	opDelRequest       = 0x4a
+	opDelResponse      = 0x6b
+	opModDNRequest     = 0x6c
+	opModDNResponse    = 0x6d
+	opCompareRequest   = 0x6e
+	opCompareResponse  = 0x6f
+	opAbandonRequest   = 0x50
+	opExtendedRequest  = 0x77
+	opExtendedResponse = 0x78
+)
+
+// Result codes
+const (
+	resultSuccess                  = 0
+	resultProtocolError            = 2
+	resultCompareFalse             = 5
+	resultInsufficientAccessRights = 50
+)
+
+// responses maps the requests that change the directory to their responses.
+// They are all refused.
+var responses = map[byte]byte{
+	opModifyRequest: opModifyResponse,
+	opAddRequest:    opAddResponse,
+	opDelRequest:    opDelResponse,
+	opModDNRequest:  opModDNResponse,
+}
+
+var operationNames = map[byte]string{
+	opBindRequest:     "Bind",
+	opUnbindRequest:   "Unbind",
+	opSearchRequest:   "Search",
+	opModifyRequest:   "Modify",
+	opAddRequest:      "Add",
+	opDelRequest:      "Delete",
+	opModDNRequest:    "ModifyDN",
+	opCompareRequest:  "Compare",
+	opAbandonRequest:  "Abandon",
+	opExtendedRequest: "Extended",
+}
+
+// errProtocol is returned for input that isn't a valid LDAP message.
+var errProtocol = errors.New("protocol error")
+
+// Config describes where the server listens and what it answers with.
+type Config struct {
+	// Addr is the address listened on.
+	Addr string
+
+	// Token returns the token for a client connecting from remote. It is
+	// returned in every search result entry.
+	Token func(remote net.Addr) string
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is an LDAP honeypot for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	// Server runs the accept loop and provides Start and Shutdown.
+	*tcplistener.Server
+}
+
+// New builds a Server for the given config. Connections are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	s := &Server{cfg: cfg, logger: logger}
+	s.Server = tcplistener.New("LDAP", []string{cfg.Addr}, cfg.Proxy, s.handle)
+	return s
+}
+
+// handle answers the requests sent over conn until the client hangs up,
+// unbinds or sends something that isn't LDAP.
+func (s *Server) handle(conn net.Conn) {
+	logger := s.logger.With(zap.String("IP", conn.RemoteAddr().String()))
+	if proxy := proxyproto.ProxyAddr(conn); proxy != nil {
+		logger = logger.With(zap.Stringer("Proxy", proxy))
+	}
+	logger.Info("New inbound LDAP connection")
+
+	start := time.Now()
+	token := s.cfg.Token(conn.RemoteAddr())
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	requests := 0
+	defer func() {
+		logger.Info("Closed inbound LDAP connection",
+			zap.Int("Requests", requests),
+			zap.Duration("Duration", time.Since(start)),
+		)
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		id, op, err := readMessage(r)
+		if errors.Is(err, errProtocol) {
+			logger.Info("Invalid LDAP message", zap.Error(err))
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		requests++
+		if op.tag == opUnbindRequest {
+			logger.Info("New inbound LDAP request", zap.String("Operation", "Unbind"))
+			return
+		}
+		if err := s.reply(w, logger, token, id, op); err != nil {
+			logger.Info("Invalid LDAP request", zap.Error(err))
+			w.Flush()
+			return
+		}
+		// Flush only once the client has nothing more buffered, so
+		// pipelined requests are answered in one write.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readMessage reads an LDAPMessage and returns its message ID and protocol
+// operation. Controls are ignored.
+func readMessage(r *bufio.Reader) (int, element, error) {
+	msg, err := readElement(r, maxMessageBytes)
+	if err != nil {
+		return 0, element{}, err
+	}
+	if msg.tag != tagSequence {
+		return 0, element{}, fmt.Errorf("%w: message has tag 0x%02x", errProtocol, msg.tag)
+	}
+	parts, err := msg.children()
+	if err != nil {
+		return 0, element{}, fmt.Errorf("%w: %v", errProtocol, err)
+	}
+	if len(parts) < 2 || parts[0].tag != tagInteger {
+		return 0, element{}, fmt.Errorf("%w: malformed message", errProtocol)
+	}
+	// A MessageID is 0 to maxInt, at most four bytes plus a sign byte.
+	id := parts[0].int()
+	if n := len(parts[0].value); n == 0 || n > 5 || id < 0 || id > maxMessageID {
+		return 0, element{}, fmt.Errorf("%w: invalid message ID", errProtocol)
+	}
+	return id, parts[1], nil
+}
+
+// reply logs the request op and writes the response to it, if any, to w,
+// serving token.
+func (s *Server) reply(w *bufio.Writer, logger *zap.Logger, token string, id int, op element) error {
+	name, ok := operationNames[op.tag]
+	if !ok {
+		name = fmt.Sprintf("0x%02x", op.tag)
+	}
+	logger = logger.With(zap.String("Operation", name))
+
+	switch op.tag {
+	case opBindRequest:
+		return s.bind(w, logger, id, op)
+	case opSearchRequest:
+		return s.search(w, logger, token, id, op)
+	case opAbandonRequest:
+		logger.Info("New inbound LDAP request")
+		return nil
+	case opCompareRequest:
+		logger.Info("New inbound LDAP request")
+		writeMessage(w, id, result(opCompareResponse, resultCompareFalse, ""))
+		return nil
+	case opExtendedRequest:
+		oid := ""
+		if fields, err := op.children(); err == nil && len(fields) > 0 && fields[0].tag == 0x80 {
+			oid = string(fields[0].value)
+		}
+		logger.Info("New inbound LDAP request", zap.String("Request Name", oid))
+		writeMessage(w, id, result(opExtendedResponse, resultProtocolError, "unsupported extended operation"))
+		return nil
+	}
+
+	if response, ok := responses[op.tag]; ok {
+		dn := ""
+		if fields, err := op.children(); err == nil && len(fields) > 0 && fields[0].tag == tagOctetString {
+			dn = string(fields[0].value)
+		} else if !op.constructed() {
+			// DelRequest is the DN itself.
+			dn = string(op.value)
+		}
+		logger.Info("New inbound LDAP request", zap.String("DN", dn))
+		writeMessage(w, id, result(response, resultInsufficientAccessRights, "insufficient access rights"))
+		return nil
+	}
+
+	logger.Info("Unexpected LDAP request", zap.Binary("Data", op.value))
+	return fmt.Errorf("unknown protocol operation 0x%02x", op.tag)
+}
+
+// bind logs the DN and credentials of a BindRequest and accepts it.
+func (s *Server) bind(w *bufio.Writer, logger *zap.Logger, id int, op element) error {
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 || fields[0].tag != tagInteger || fields[1].tag != tagOctetString {
+		return errors.New("malformed bind request")
+	}
+
+	logFields := []zap.Field{
+		zap.Int("Version", fields[0].int()),
+		zap.String("Bind DN", string(fields[1].value)),
+	}
+	switch auth := fields[2]; auth.tag {
+	case 0x80:
+		logFields = append(logFields,
+			zap.String("Authentication", "simple"),
+			zap.ByteString("Password", auth.value),
+		)
+	case 0xa3:
+		logFields = append(logFields, zap.String("Authentication", "SASL"))
+		if sasl, err := auth.children(); err == nil && len(sasl) > 0 {
+			logFields = append(logFields, zap.ByteString("SASL Mechanism", sasl[0].value))
+			if len(sasl) > 1 {
+				logFields = append(logFields, zap.ByteString("Credentials", sasl[1].value))
+			}
+		}
+	default:
+		logFields = append(logFields, zap.String("Authentication", fmt.Sprintf("0x%02x", auth.tag)))
+	}
+	logger.Info("New inbound LDAP bind", logFields...)
+
+	writeMessage(w, id, result(opBindResponse, resultSuccess, ""))
+	return nil
+}
+
+// search logs a SearchRequest and answers it with a single entry holding
+// token.
+func (s *Server) search(w *bufio.Writer, logger *zap.Logger, token string, id int, op element) error {
+	fields, err := op.children()
+	if err != nil || len(fields) < 8 || fields[0].tag != tagOctetString {
+		return errors.New("malformed search request")
+	}
+
+	base := string(fields[0].value)
+	filter, err := renderFilter(fields[6])
+	if err != nil {
+		return fmt.Errorf("malformed search filter: %v", err)
+	}
+	var attributes []string
+	if list, err := fields[7].children(); err == nil {
+		for _, attr := range list {
+			attributes = append(attributes, string(attr.value))
+		}
+	}
+	logger.Info("New inbound LDAP search",
+		zap.String("Base DN", base),
+		zap.String("Scope", scopeName(fields[1].int())),
+		zap.String("Filter", filter),
+		zap.Strings("Attributes", attributes),
+	)
+
+	writeMessage(w, id, entry(base, token, attributes))
+	writeMessage(w, id, result(opSearchDone, resultSuccess, ""))
+	return nil
+}
+
+// entry builds the SearchResultEntry returned for every search: base, or
+// cn=<token> for the root DSE, with token as its cn, description and the
+// value of every other attribute asked for. Java object attributes are never
+// returned.
+func entry(base, token string, attributes []string) []byte {
+	dn := base
+	if dn == "" {
+		dn = "cn=" + token
+	}
+
+	values := []struct{ name, value string }{
+		{"objectClass", "top"},
+		{"cn", token},
+		{"description", "token=" + token},
+	}
+	seen := map[string]bool{"objectclass": true, "cn": true, "description": true}
+	for _, attr := range attributes {
+		name := strings.ToLower(attr)
+		if seen[name] || name == "*" || name == "+" || name == "1.1" || strings.HasPrefix(name, "java") {
+			continue
+		}
+		seen[name] = true
+		values = append(values, struct{ name, value string }{attr, token})
+	}
+
+	var list []byte
+	for _, v := range values {
+		list = append(list, encode(tagSequence, concat(
+			encodeString(v.name),
+			encode(tagSet, encodeString(v.value)),
+		))...)
+	}
+	return encode(opSearchEntry, concat(encodeString(dn), encode(tagSequence, list)))
+}
+
+// result builds an LDAPResult with the given response tag.
+func result(tag byte, code int, message string) []byte {
+	return encode(tag, concat(
+		encodeInt(tagEnumerated, code),
+		encodeString(""),
+		encodeString(message),
+	))
+}
+
+// writeMessage writes an LDAPMessage holding op to w.
+func writeMessage(w *bufio.Writer, id int, op []byte) {
+	w.Write(encode(tagSequence, concat(encodeInt(tagInteger, id), op)))
+}
+
+func scopeName(scope int) string {
+	switch scope {
+	case 0:
+		return "base"
+	case 1:
+		return "one"
+	case 2:
+		return "sub"
+	case 3:
+		return "children"
+	}
+	return fmt.Sprint(scope)
+}
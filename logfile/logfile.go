@@ -0,0 +1,119 @@
+// Package logfile implements an append-only log file that rotates itself once
+// it reaches a size limit, keeping a fixed number of old files around.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is a log file rotated by size. Rotated files are renamed with a
+// numeric suffix, path.1 being the most recent. It is safe for concurrent
+// use.
+type File struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens path for appending, creating it and its directory if needed.
+// Once a write would take it past maxBytes it is rotated, keeping at most
+// maxBackups old files. A maxBytes of 0 never rotates.
+func Open(path string, maxBytes int64, maxBackups int) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	l := &File{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *File) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past its
+// size limit. A single write is never split across files.
+func (l *File) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files up by one, dropping the oldest, and starts a
+// new file at path.
+func (l *File) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %v", err)
+	}
+	l.f = nil
+
+	if l.maxBackups > 0 {
+		for i := l.maxBackups - 1; i > 0; i-- {
+			os.Rename(l.backup(i), l.backup(i+1))
+		}
+		if err := os.Rename(l.path, l.backup(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %v", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %v", err)
+	}
+	return l.open()
+}
+
+func (l *File) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Sync flushes the file to disk.
+func (l *File) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	return l.f.Sync()
+}
+
+// Close closes the file. Later writes fail.
+func (l *File) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -1,23 +1,89 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/teknogeek/ssrf-sheriff/handler"
 	"go.uber.org/fx"
 )
 
+var (
+	configFile    = flag.String("config", handler.DefaultConfigFile, "YAML config file")
+	mintTarget    = flag.String("mint-target", "", "print a targets.known entry with a new target ID and this label, and exit")
+	selfTest      = flag.Bool("selftest", false, "request every supported format after startup and exit non-zero if any of them fails")
+	showVersion   = flag.Bool("version", false, "print the version and exit")
+	untilCallback = flag.Bool("until-callback", false, "exit once the first callback has been answered")
+
+	overrides handler.StringsFlag
+)
+
 func main() {
+	flag.Var(&overrides, "set", "override a config key, as key=value (e.g. http.address=:9000); may be repeated")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(handler.ReadBuildInfo())
+		return
+	}
+	if *mintTarget != "" {
+		id, err := handler.NewTargetID()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to mint target: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("- id: %q\n  label: %q\n", id, *mintTarget)
+		return
+	}
+
 	fx.New(opts()).Run()
 }
 
 func opts() fx.Option {
+	invokes := []interface{}{handler.StartFilesGenerator, handler.StartServer, handler.StartDNSServer, handler.StartFTPServer, handler.StartTFTPServer, handler.StartTCPServer, handler.StartUDPServer, handler.StartSMTPServer, handler.StartRedisServer, handler.StartLDAPServer, handler.StartGRPCServer, handler.StopAfterCallbacks}
+	if *selfTest {
+		invokes = append(invokes, handler.RunSelfTest)
+	}
+	invokes = append(invokes, handler.NotifyReady)
+
+	supplies := []interface{}{handler.ConfigFlags{File: *configFile, Overrides: overrides}}
+	if *untilCallback {
+		supplies = append(supplies, handler.UntilCallback(true))
+	}
+
 	return fx.Options(
+		fx.Supply(supplies...),
 		fx.Provide(
 			handler.NewLogger,
 			handler.NewConfigProvider,
+			handler.NewMetrics,
+			handler.NewBuiltinTransforms,
+			handler.NewTransformPipeline,
+			handler.NewWebhooks,
+			handler.NewHitStore,
+			handler.NewMediaCache,
+			handler.NewEnricher,
+			handler.NewHitLog,
 			handler.NewSSRFSheriffRouter,
 			handler.NewServerRouter,
 			handler.NewHTTPServer,
+			handler.NewHTTPHandle,
+			handler.NewTLSCertificate,
+			handler.NewTLSHandle,
+			handler.NewExtraHandles,
+			handler.NewReloadTrigger,
+			handler.NewAdminHandle,
+			handler.NewDNSServer,
+			handler.NewFTPServer,
+			handler.NewTFTPServer,
+			handler.NewTCPServer,
+			handler.NewUDPServer,
+			handler.NewSMTPServer,
+			handler.NewRedisServer,
+			handler.NewLDAPServer,
+			handler.NewGRPCServer,
 		),
-		fx.Invoke(handler.StartFilesGenerator, handler.StartServer),
+		fx.Invoke(invokes...),
 	)
 }
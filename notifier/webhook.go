@@ -0,0 +1,289 @@
+// Package notifier delivers callback events to external services so hits can
+// be piped into chat or alerting while an engagement is running.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultWorkers is the number of concurrent deliveries unless
+	// Options.Workers is set.
+	DefaultWorkers = 4
+
+	// DefaultQueueSize is the number of events buffered for delivery unless
+	// Options.QueueSize is set.
+	DefaultQueueSize = 256
+)
+
+// Options tunes how Webhooks queues and delivers events. Zero values take the
+// defaults.
+type Options struct {
+	// Timeout bounds each delivery. There is no timeout if it is zero.
+	Timeout time.Duration
+
+	// Workers is the number of events delivered at the same time.
+	Workers int
+
+	// QueueSize is the number of events buffered for the workers.
+	QueueSize int
+
+	// Block makes Notify wait for room while the queue is full. By default
+	// the event is dropped instead, so responses are never slowed down.
+	Block bool
+
+	// Routes send events for some tokens to other URLs than the default
+	// ones.
+	Routes []Route
+}
+
+// Route sends the events whose token starts with TokenPrefix to URLs instead
+// of the default URLs. A whole token is a prefix of itself. When several
+// routes match, the one with the longest prefix wins.
+type Route struct {
+	TokenPrefix string
+	URLs        []string
+}
+
+// Event types.
+const (
+	// EventCallback is sent for every callback.
+	EventCallback = "callback"
+	// EventTokenEcho is sent when a token served by the sheriff comes back
+	// in a later request.
+	EventTokenEcho = "token_echo"
+)
+
+// PriorityHigh marks events that need attention right away.
+const PriorityHigh = "high"
+
+// Event describes a single callback received by the sheriff.
+type Event struct {
+	Type     string      `json:"type"`
+	Priority string      `json:"priority,omitempty"`
+	Time     time.Time   `json:"timestamp"`
+	IP       string      `json:"ip"`
+	Method   string      `json:"method"`
+	Host     string      `json:"host"`
+	Path     string      `json:"path"`
+	Token    string      `json:"token"`
+	Headers  http.Header `json:"headers"`
+
+	// Target is the ID of the target the callback was attributed to, if
+	// any.
+	Target string `json:"target,omitempty"`
+
+	// Session is the ID of the session the callback belongs to, if any.
+	Session string `json:"session,omitempty"`
+
+	// Data is what was exfiltrated through a DNS lookup, as UTF-8 text or
+	// base64 as DataEncoding says.
+	Data         string `json:"data,omitempty"`
+	DataEncoding string `json:"data_encoding,omitempty"`
+
+	// Location is where an echoed token was found: "path", "query",
+	// "header:<Name>" or "body".
+	Location string `json:"location,omitempty"`
+}
+
+// Webhooks POSTs every event as JSON to each of a list of URLs, chosen by
+// the event's token. Delivery happens in the background, by a fixed pool of
+// workers reading from a bounded queue, and failures are logged, not retried.
+type Webhooks struct {
+	urls    []string
+	routes  []Route
+	client  *http.Client
+	logger  *zap.Logger
+	workers int
+	block   bool
+
+	// mu guards stopped, and closing queue against sends to it.
+	mu      sync.RWMutex
+	stopped bool
+	queue   chan Event
+	wg      sync.WaitGroup
+
+	dropped atomic.Uint64
+}
+
+// NewWebhooks builds a Webhooks delivering to urls, or to the URLs of the
+// route matching an event's token. All of them must be absolute http or https
+// URLs.
+func NewWebhooks(urls []string, opts Options, logger *zap.Logger) (*Webhooks, error) {
+	if opts.Workers < 0 || opts.QueueSize < 0 {
+		return nil, fmt.Errorf("workers and queue size must not be negative")
+	}
+	if opts.Workers == 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+
+	if err := checkURLs(urls); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, route := range opts.Routes {
+		if route.TokenPrefix == "" {
+			return nil, fmt.Errorf("webhook route without a token prefix")
+		}
+		if seen[route.TokenPrefix] {
+			return nil, fmt.Errorf("webhook route for token prefix %q listed more than once", route.TokenPrefix)
+		}
+		seen[route.TokenPrefix] = true
+		if len(route.URLs) == 0 {
+			return nil, fmt.Errorf("webhook route for token prefix %q has no URLs", route.TokenPrefix)
+		}
+		if err := checkURLs(route.URLs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Webhooks{
+		urls:    urls,
+		routes:  opts.Routes,
+		client:  &http.Client{Timeout: opts.Timeout},
+		logger:  logger,
+		workers: opts.Workers,
+		block:   opts.Block,
+		queue:   make(chan Event, opts.QueueSize),
+	}, nil
+}
+
+func checkURLs(urls []string) error {
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid webhook URL %q: %v", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("webhook URL %q must be http or https", u)
+		}
+	}
+	return nil
+}
+
+// URLsFor returns the URLs events for token are delivered to: those of the
+// route with the longest prefix of token, or the default ones if no route
+// matches.
+func (wh *Webhooks) URLsFor(token string) []string {
+	var best *Route
+	for i, route := range wh.routes {
+		if strings.HasPrefix(token, route.TokenPrefix) && (best == nil || len(route.TokenPrefix) > len(best.TokenPrefix)) {
+			best = &wh.routes[i]
+		}
+	}
+	if best == nil {
+		return wh.urls
+	}
+	return best.URLs
+}
+
+// Start starts the workers delivering queued events.
+func (wh *Webhooks) Start(context.Context) error {
+	for i := 0; i < wh.workers; i++ {
+		wh.wg.Add(1)
+		go func() {
+			defer wh.wg.Done()
+			for event := range wh.queue {
+				wh.deliver(event)
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop stops accepting events and waits for the queued ones to be delivered
+// until the context finishes. Events notified after Stop are dropped.
+func (wh *Webhooks) Stop(ctx context.Context) error {
+	wh.mu.Lock()
+	if !wh.stopped {
+		wh.stopped = true
+		close(wh.queue)
+	}
+	wh.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wh.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify queues an event for delivery. While the queue is full, it waits for
+// room if Options.Block was set and drops the event otherwise.
+func (wh *Webhooks) Notify(event Event) {
+	wh.mu.RLock()
+	defer wh.mu.RUnlock()
+	if wh.stopped {
+		wh.drop(event, "notifier is stopped")
+		return
+	}
+
+	if wh.block {
+		wh.queue <- event
+		return
+	}
+	select {
+	case wh.queue <- event:
+	default:
+		wh.drop(event, "queue is full")
+	}
+}
+
+func (wh *Webhooks) drop(event Event, reason string) {
+	wh.logger.Warn("Dropped webhook notification, "+reason,
+		zap.String("IP", event.IP),
+		zap.String("Path", event.Path),
+		zap.Uint64("Dropped", wh.dropped.Add(1)),
+	)
+}
+
+// QueueDepth returns the number of events waiting to be delivered.
+func (wh *Webhooks) QueueDepth() int {
+	return len(wh.queue)
+}
+
+// Dropped returns the number of events dropped so far.
+func (wh *Webhooks) Dropped() uint64 {
+	return wh.dropped.Load()
+}
+
+func (wh *Webhooks) deliver(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		wh.logger.Error("Failed to encode webhook notification", zap.Error(err))
+		return
+	}
+
+	for _, u := range wh.URLsFor(event.Token) {
+		res, err := wh.client.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			wh.logger.Warn("Webhook delivery failed", zap.String("URL", u), zap.Error(err))
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			wh.logger.Warn("Webhook delivery rejected", zap.String("URL", u), zap.Int("Status", res.StatusCode))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestURLsForPicksLongestPrefix(t *testing.T) {
+	wh, err := NewWebhooks([]string{"https://default.test"}, Options{Routes: []Route{
+		{TokenPrefix: "acme", URLs: []string{"https://acme.test"}},
+		{TokenPrefix: "acme-pdf", URLs: []string{"https://pdf.test", "https://pdf2.test"}},
+		{TokenPrefix: "SECRET", URLs: []string{"https://secret.test"}},
+	}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token string
+		want  []string
+	}{
+		{"acme-web-1", []string{"https://acme.test"}},
+		{"acme-pdf-1", []string{"https://pdf.test", "https://pdf2.test"}},
+		{"SECRET", []string{"https://secret.test"}},
+		{"other", []string{"https://default.test"}},
+		{"", []string{"https://default.test"}},
+	}
+	for _, tt := range tests {
+		if got := wh.URLsFor(tt.token); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("URLsFor(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestNewWebhooksRejectsBadRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+	}{
+		{"empty prefix", []Route{{URLs: []string{"https://a.test"}}}},
+		{"no URLs", []Route{{TokenPrefix: "a"}}},
+		{"bad URL", []Route{{TokenPrefix: "a", URLs: []string{"ftp://a.test"}}}},
+		{"duplicate prefix", []Route{{TokenPrefix: "a", URLs: []string{"https://a.test"}}, {TokenPrefix: "a", URLs: []string{"https://b.test"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewWebhooks(nil, Options{Routes: tt.routes}, zap.NewNop()); err == nil {
+			t.Errorf("%s: NewWebhooks succeeded", tt.name)
+		}
+	}
+}
+
+func TestRoutedDelivery(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]string)
+	)
+	receiver := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event Event
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Errorf("%s: bad event: %v", name, err)
+			}
+			mu.Lock()
+			received[name] = append(received[name], event.Token)
+			mu.Unlock()
+		}))
+	}
+	def, routed := receiver("default"), receiver("routed")
+	defer def.Close()
+	defer routed.Close()
+
+	wh, err := NewWebhooks([]string{def.URL}, Options{Routes: []Route{{TokenPrefix: "a-", URLs: []string{routed.URL}}}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wh.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wh.Notify(Event{Type: EventCallback, Token: "a-1"})
+	wh.Notify(Event{Type: EventCallback, Token: "b-1"})
+	if err := wh.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{"default": {"b-1"}, "routed": {"a-1"}}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received %v, want %v", received, want)
+	}
+}
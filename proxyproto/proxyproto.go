@@ -0,0 +1,267 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol, versions 1 and 2, so the address of the client a TCP load balancer
+// relays a connection for survives the hop.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout is how long a proxy has to send the PROXY header after
+// connecting.
+const headerTimeout = 5 * time.Second
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// LocalHeader is a version 2 header for a connection the proxy makes on its
+// own behalf, such as a health check, rather than relaying one.
+var LocalHeader = append(append([]byte(nil), v2Signature...), 0x20, 0x00, 0x00, 0x00)
+
+// Policy says which peers send PROXY headers.
+type Policy struct {
+	// Trusted are the networks of the proxies. Connections from them must
+	// start with a PROXY header, and connections from anywhere else are
+	// passed through untouched. If Trusted is empty, every connection must
+	// start with a header.
+	Trusted []*net.IPNet
+}
+
+// Trusts reports whether connections from addr must start with a PROXY
+// header.
+func (p *Policy) Trusts(addr net.Addr) bool {
+	if len(p.Trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range p.Trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection relayed by a proxy. RemoteAddr returns the address of
+// the client the proxy relays for, and ProxyAddr the proxy's own.
+type Conn struct {
+	net.Conn
+
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client, as given by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ProxyAddr returns the address of the proxy.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyAddr returns the address of the proxy that relayed c, or nil if c
+// didn't come through one.
+func ProxyAddr(c net.Conn) net.Addr {
+	if pc, ok := c.(*Conn); ok {
+		return pc.ProxyAddr()
+	}
+	return nil
+}
+
+// Listener reads the PROXY header of connections from trusted proxies before
+// handing them out. Headers are read in the background, so a slow or silent
+// client doesn't hold up the connections accepted after it. Connections
+// from trusted proxies that don't start with a valid header are closed.
+type Listener struct {
+	net.Listener
+
+	policy Policy
+	conns  chan net.Conn
+	err    error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewListener wraps ln, reading PROXY headers as the policy says.
+func NewListener(ln net.Listener, policy Policy) *Listener {
+	l := &Listener{
+		Listener: ln,
+		policy:   policy,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.accept()
+	return l
+}
+
+func (l *Listener) accept() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.err = err
+			l.Close()
+			return
+		}
+		if !l.policy.Trusts(c.RemoteAddr()) {
+			l.deliver(c)
+			continue
+		}
+		go func() {
+			pc, err := readHeader(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			l.deliver(pc)
+		}()
+	}
+}
+
+func (l *Listener) deliver(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection, with its header already read.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections.
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+// readHeader reads the PROXY header at the start of c.
+func readHeader(c net.Conn) (*Conn, error) {
+	c.SetReadDeadline(time.Now().Add(headerTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(c)
+	pc := &Conn{Conn: c, r: r, remote: c.RemoteAddr()}
+
+	sig, err := r.Peek(len(v2Signature))
+	if err != nil && len(sig) < 6 {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(sig, v2Signature):
+		err = pc.readV2()
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		err = pc.readV1()
+	default:
+		err = errors.New("no PROXY header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readV1 reads a human-readable header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (pc *Conn) readV1() error {
+	// A version 1 header is at most 107 bytes long.
+	var line []byte
+	for len(line) < 107 {
+		b, err := pc.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("invalid PROXY v1 header")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return fmt.Errorf("invalid source address in PROXY v1 header %q", line)
+	}
+	pc.remote = &net.TCPAddr{IP: ip, Port: int(port)}
+	return nil
+}
+
+// readV2 reads a binary header.
+func (pc *Conn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(pc.r, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(pc.r, body); err != nil {
+		return err
+	}
+
+	// LOCAL commands are the proxy's own health checks, and other address
+	// families have no address we can use.
+	if hdr[12]&0x0f == 0 {
+		return nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return errors.New("short PROXY v2 IPv4 addresses")
+		}
+		pc.remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return errors.New("short PROXY v2 IPv6 addresses")
+		}
+		pc.remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package redisserver implements a Redis honeypot that speaks enough of the
+// RESP protocol to keep a client talking, answers with the token wherever a
+// value is expected, and logs every command. It detects SSRF aimed at an
+// internal Redis, such as gopher:// payloads writing keys or CONFIG SET.
+package redisserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"github.com/teknogeek/ssrf-sheriff/tcplistener"
+	"go.uber.org/zap"
+)
+
+const (
+	// idleTimeout is how long a connection may go without a command.
+	idleTimeout = 5 * time.Minute
+
+	// maxArgs and maxBulkBytes bound a single command, so a client can't
+	// make the server allocate without limit.
+	maxArgs      = 1024
+	maxBulkBytes = 1 << 20
+
+	// version is the Redis version the server claims to be.
+	version = "6.2.6"
+)
+
+// errProtocol is returned for input that isn't valid RESP.
+var errProtocol = errors.New("protocol error")
+
+// Config describes where the server listens and what it answers with.
+type Config struct {
+	// Addr is the address listened on.
+	Addr string
+
+	// Token returns the token for a client connecting from remote. It is
+	// returned by GET and included in INFO and PING replies.
+	Token func(remote net.Addr) string
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is a Redis honeypot for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	// Server runs the accept loop and provides Start and Shutdown.
+	*tcplistener.Server
+}
+
+// New builds a Server for the given config. Connections are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	s := &Server{cfg: cfg, logger: logger}
+	s.Server = tcplistener.New("Redis", []string{cfg.Addr}, cfg.Proxy, s.handle)
+	return s
+}
+
+// handle answers the commands sent over conn until the client hangs up,
+// quits or sends something that isn't RESP.
+func (s *Server) handle(conn net.Conn) {
+	logger := s.logger.With(zap.String("IP", conn.RemoteAddr().String()))
+	if proxy := proxyproto.ProxyAddr(conn); proxy != nil {
+		logger = logger.With(zap.Stringer("Proxy", proxy))
+	}
+	logger.Info("New inbound Redis connection")
+
+	start := time.Now()
+	token := s.cfg.Token(conn.RemoteAddr())
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	commands := 0
+	defer func() {
+		logger.Info("Closed inbound Redis connection",
+			zap.Int("Commands", commands),
+			zap.Duration("Duration", time.Since(start)),
+		)
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		args, err := readCommand(r)
+		if errors.Is(err, errProtocol) {
+			logger.Info("Invalid Redis command", zap.Error(err))
+			w.WriteString("-ERR Protocol error: " + err.Error() + "\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		commands++
+		cmd := strings.ToUpper(args[0])
+		logger.Info("New inbound Redis command",
+			zap.String("Command", cmd),
+			zap.Strings("Arguments", args[1:]),
+		)
+		s.reply(w, token, cmd, args[1:])
+		// Flush only once the client has nothing more buffered, so
+		// pipelined commands are answered in one write.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if cmd == "QUIT" {
+			w.Flush()
+			return
+		}
+	}
+}
+
+// reply writes the answer to a command, serving token. Commands that write
+// data are acknowledged without doing anything.
+func (s *Server) reply(w *bufio.Writer, token, cmd string, args []string) {
+	switch cmd {
+	case "PING":
+		if len(args) > 0 {
+			writeBulk(w, args[0])
+		} else {
+			w.WriteString("+PONG " + token + "\r\n")
+		}
+	case "ECHO":
+		if len(args) != 1 {
+			writeArityError(w, cmd)
+			return
+		}
+		writeBulk(w, args[0])
+	case "GET", "GETDEL", "GETEX", "HGET", "LPOP", "RPOP", "SRANDMEMBER", "SPOP":
+		writeBulk(w, token)
+	case "MGET":
+		w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+		for range args {
+			writeBulk(w, token)
+		}
+	case "KEYS":
+		w.WriteString("*1\r\n")
+		writeBulk(w, token)
+	case "EXISTS", "DEL", "UNLINK", "INCR", "DECR", "LPUSH", "RPUSH", "SADD", "HSET", "EXPIRE", "PUBLISH", "DBSIZE":
+		w.WriteString(":1\r\n")
+	case "INFO":
+		writeBulk(w, s.info(token))
+	case "CONFIG":
+		if len(args) >= 2 && strings.EqualFold(args[0], "GET") {
+			w.WriteString("*2\r\n")
+			writeBulk(w, args[1])
+			writeBulk(w, token)
+			return
+		}
+		w.WriteString("+OK\r\n")
+	case "SAVE", "BGSAVE":
+		w.WriteString("+Background saving started\r\n")
+	case "QUIT", "AUTH", "SELECT", "CLIENT", "COMMAND", "SET", "SETEX", "SETNX", "MSET", "FLUSHALL", "FLUSHDB",
+		"SLAVEOF", "REPLICAOF", "MODULE", "EVAL", "EVALSHA", "SCRIPT", "MULTI", "EXEC", "WATCH":
+		w.WriteString("+OK\r\n")
+	default:
+		w.WriteString("-ERR unknown command '" + sanitize(cmd) + "'\r\n")
+	}
+}
+
+// info returns the INFO reply, with token as the run ID.
+func (s *Server) info(token string) string {
+	return strings.Join([]string{
+		"# Server",
+		"redis_version:" + version,
+		"redis_mode:standalone",
+		"os:Linux 5.15.0-91-generic x86_64",
+		"arch_bits:64",
+		"tcp_port:6379",
+		"run_id:" + token,
+		"",
+		"# Replication",
+		"role:master",
+		"connected_slaves:0",
+		"",
+		"# Keyspace",
+		"db0:keys=1,expires=0,avg_ttl=0",
+		"",
+	}, "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func writeArityError(w *bufio.Writer, cmd string) {
+	w.WriteString("-ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command\r\n")
+}
+
+// sanitize keeps a client-supplied string from breaking out of a simple
+// string reply.
+func sanitize(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// readCommand reads one command, either a RESP array of bulk strings or an
+// inline command as typed into telnet or sent through gopher://.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got %q", errProtocol, sanitize(line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkBytes {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line of at most maxBulkBytes, without its line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxBulkBytes {
+			return "", fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package smtpserver implements a minimal SMTP server that accepts every
+// message and logs the whole conversation, including the envelope and the
+// message itself. It detects SSRF through smtp:// URL handlers and
+// gopher:// payloads aimed at mail servers.
+package smtpserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"github.com/teknogeek/ssrf-sheriff/tcplistener"
+	"go.uber.org/zap"
+)
+
+const (
+	// idleTimeout is how long a connection may go without a command.
+	idleTimeout = 5 * time.Minute
+
+	// maxLineBytes and maxRecipients bound a command line and the envelope
+	// of a message, so a client can't make the server allocate without
+	// limit. RFC 5321 asks for at least 512 bytes and 100 recipients.
+	maxLineBytes  = 4096
+	maxRecipients = 100
+)
+
+// errLineTooLong is returned by readLine for lines over maxLineBytes.
+var errLineTooLong = errors.New("line too long")
+
+// Config describes where the server listens and how it presents itself.
+type Config struct {
+	// Addr is the address listened on.
+	Addr string
+
+	// Hostname is the name the server greets clients with.
+	Hostname string
+
+	// Token returns the token for a client connecting from remote. It is
+	// included in the banner and in the replies to HELO, EHLO, DATA and
+	// QUIT, so it shows up in whatever the client reports back.
+	Token func(remote net.Addr) string
+
+	// MaxMessageBytes is the largest message accepted by DATA, and logged.
+	MaxMessageBytes int
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is an SMTP server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	// Server runs the accept loop and provides Start and Shutdown.
+	*tcplistener.Server
+}
+
+// New builds a Server for the given config. Connections are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	s := &Server{cfg: cfg, logger: logger}
+	s.Server = tcplistener.New("SMTP", []string{cfg.Addr}, cfg.Proxy, func(conn net.Conn) { newSession(s, conn).run() })
+	return s
+}
+
+// session is the state of one connection.
+type session struct {
+	s      *Server
+	conn   net.Conn
+	tp     *textproto.Conn
+	logger *zap.Logger
+	token  string
+
+	helo string
+	from string
+	to   []string
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	logger := s.logger.With(zap.String("IP", conn.RemoteAddr().String()))
+	if proxy := proxyproto.ProxyAddr(conn); proxy != nil {
+		logger = logger.With(zap.Stringer("Proxy", proxy))
+	}
+	return &session{
+		s:      s,
+		conn:   conn,
+		tp:     textproto.NewConn(conn),
+		logger: logger,
+		token:  s.cfg.Token(conn.RemoteAddr()),
+	}
+}
+
+func (ss *session) run() {
+	ss.logger.Info("New inbound SMTP connection")
+	ss.reply(220, fmt.Sprintf("%s ESMTP Postfix %s", ss.s.cfg.Hostname, ss.token))
+
+	for {
+		ss.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := ss.readLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		cmd = strings.ToUpper(cmd)
+		ss.logger.Info("New inbound SMTP command",
+			zap.String("Command", cmd),
+			zap.String("Argument", arg),
+		)
+		if cmd == "QUIT" {
+			ss.reply(221, "2.0.0 Bye "+ss.token)
+			return
+		}
+		ss.handle(cmd, arg)
+	}
+}
+
+func (ss *session) reply(code int, msg string) {
+	ss.tp.PrintfLine("%d %s", code, msg)
+}
+
+func (ss *session) handle(cmd, arg string) {
+	switch cmd {
+	case "HELO":
+		ss.helo = arg
+		ss.reset()
+		ss.reply(250, ss.s.cfg.Hostname+" "+ss.token)
+	case "EHLO":
+		ss.helo = arg
+		ss.reset()
+		ss.tp.PrintfLine("250-%s %s\r\n250-PIPELINING\r\n250-SIZE %d\r\n250-AUTH PLAIN LOGIN\r\n250-8BITMIME\r\n250 SMTPUTF8",
+			ss.s.cfg.Hostname, ss.token, ss.s.cfg.MaxMessageBytes)
+	case "MAIL":
+		ss.reset()
+		ss.from = addressArg(arg, "FROM:")
+		ss.reply(250, "2.1.0 Ok")
+	case "RCPT":
+		if len(ss.to) >= maxRecipients {
+			ss.reply(452, "4.5.3 Error: too many recipients")
+			return
+		}
+		ss.to = append(ss.to, addressArg(arg, "TO:"))
+		ss.reply(250, "2.1.5 Ok")
+	case "DATA":
+		ss.data()
+	case "AUTH":
+		ss.auth(arg)
+	case "RSET":
+		ss.reset()
+		ss.reply(250, "2.0.0 Ok")
+	case "NOOP":
+		ss.reply(250, "2.0.0 Ok")
+	case "VRFY":
+		ss.reply(252, "2.0.0 "+arg)
+	case "STARTTLS":
+		ss.reply(454, "4.7.0 TLS not available due to local problem")
+	default:
+		ss.reply(502, "5.5.2 Error: command not recognized")
+	}
+}
+
+// reset clears the envelope of the message being sent.
+func (ss *session) reset() {
+	ss.from = ""
+	ss.to = nil
+}
+
+// data reads and logs a message, accepting it unless it is too large.
+func (ss *session) data() {
+	ss.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	dr := ss.tp.DotReader()
+	msg, err := io.ReadAll(io.LimitReader(dr, int64(ss.s.cfg.MaxMessageBytes)+1))
+	if err != nil {
+		return
+	}
+	tooLarge := len(msg) > ss.s.cfg.MaxMessageBytes
+	if tooLarge {
+		msg = msg[:ss.s.cfg.MaxMessageBytes]
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return
+		}
+	}
+
+	ss.logger.Info("New inbound SMTP message",
+		zap.String("HELO", ss.helo),
+		zap.String("From", ss.from),
+		zap.Strings("To", ss.to),
+		zap.Int("Bytes", len(msg)),
+		zap.Bool("Truncated", tooLarge),
+		zap.ByteString("Data", msg),
+	)
+	ss.reset()
+
+	if tooLarge {
+		ss.reply(552, "5.3.4 Error: message file too big")
+		return
+	}
+	ss.reply(250, "2.0.0 Ok: queued as "+ss.token)
+}
+
+// auth accepts any credentials given with AUTH PLAIN or AUTH LOGIN, logging
+// them.
+func (ss *session) auth(arg string) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var user, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if initial == "" {
+			var err error
+			if initial, err = ss.challenge(""); err != nil || initial == "" {
+				return
+			}
+		}
+		// The response is authzid NUL authcid NUL password.
+		parts := strings.SplitN(decode(initial), "\x00", 3)
+		if len(parts) == 3 {
+			user, password = parts[1], parts[2]
+		}
+	case "LOGIN":
+		if initial == "" {
+			var err error
+			if initial, err = ss.challenge("Username:"); err != nil {
+				return
+			}
+		}
+		response, err := ss.challenge("Password:")
+		if err != nil {
+			return
+		}
+		user, password = decode(initial), decode(response)
+	default:
+		ss.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+
+	ss.logger.Info("SMTP login",
+		zap.String("Mechanism", strings.ToUpper(mechanism)),
+		zap.String("User", user),
+		zap.String("Password", password),
+	)
+	ss.reply(235, "2.7.0 Authentication successful")
+}
+
+// challenge sends a 334 challenge and returns the client's response.
+func (ss *session) challenge(prompt string) (string, error) {
+	ss.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt)))
+	return ss.readLine()
+}
+
+// readLine reads a line from the client, without its line ending. A line over
+// maxLineBytes is answered with an error, fails with errLineTooLong and drops
+// the connection.
+func (ss *session) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := ss.tp.R.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLineBytes {
+			ss.logger.Info("SMTP line too long, closing connection", zap.Int("Limit", maxLineBytes))
+			ss.reply(500, "5.5.0 Error: line too long")
+			ss.conn.Close()
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// decode returns the base64-decoded s, or s itself if it isn't valid base64.
+func decode(s string) string {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
+
+// addressArg returns the address given to MAIL FROM: or RCPT TO:, without its
+// angle brackets and parameters.
+func addressArg(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, "<") {
+		if end := strings.IndexByte(arg, '>'); end >= 0 {
+			return arg[1:end]
+		}
+	}
+	addr, _, _ := strings.Cut(arg, " ")
+	return addr
+}
@@ -0,0 +1,65 @@
+package smtpserver
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// dial runs a session over a pipe and returns the client's end, with the
+// banner already read.
+func dial(t *testing.T) *textproto.Conn {
+	t.Helper()
+	s := &Server{
+		cfg: Config{
+			Hostname:        "mail.localdomain",
+			Token:           func(net.Addr) string { return "tok" },
+			MaxMessageBytes: 1024,
+		},
+		logger: zap.NewNop(),
+	}
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		newSession(s, server).run()
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	tp := textproto.NewConn(client)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	return tp
+}
+
+func TestRecipientsAreCapped(t *testing.T) {
+	tp := dial(t)
+	tp.PrintfLine("MAIL FROM:<a@example.com>")
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRecipients; i++ {
+		tp.PrintfLine("RCPT TO:<r%d@example.com>", i)
+		if _, _, err := tp.ReadResponse(250); err != nil {
+			t.Fatalf("recipient %d: %v", i, err)
+		}
+	}
+	tp.PrintfLine("RCPT TO:<one-too-many@example.com>")
+	if code, _, _ := tp.ReadResponse(250); code != 452 {
+		t.Errorf("reply to recipient %d = %d, want 452", maxRecipients+1, code)
+	}
+}
+
+func TestLongLinesDropTheConnection(t *testing.T) {
+	tp := dial(t)
+	go tp.PrintfLine("NOOP %s", strings.Repeat("a", maxLineBytes))
+	if code, _, _ := tp.ReadResponse(250); code != 500 {
+		t.Errorf("reply to a long line = %d, want 500", code)
+	}
+	if _, err := tp.ReadLine(); err == nil {
+		t.Error("connection still open after a long line")
+	}
+}
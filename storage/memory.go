@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMemoryCapacity is the number of hits kept by a Memory store when no
+// capacity is given.
+const DefaultMemoryCapacity = 1000
+
+// Memory is a Store that keeps the most recent hits in memory. They are lost
+// when the process exits.
+type Memory struct {
+	mu     sync.Mutex
+	hits   []Hit
+	next   int
+	nextID int64
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns a Memory store holding up to capacity hits, dropping the
+// oldest once it is full.
+func NewMemory(capacity int) *Memory {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCapacity
+	}
+	return &Memory{hits: make([]Hit, 0, capacity)}
+}
+
+// Record stores a hit.
+func (m *Memory) Record(ctx context.Context, hit Hit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	hit.ID = m.nextID
+	if len(m.hits) < cap(m.hits) {
+		m.hits = append(m.hits, hit)
+		return nil
+	}
+	m.hits[m.next] = hit
+	m.next = (m.next + 1) % len(m.hits)
+	return nil
+}
+
+// Query returns the hits matching q, newest first.
+func (m *Memory) Query(ctx context.Context, q Query) ([]Hit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hits := []Hit{}
+	for i := len(m.hits) - 1; i >= 0; i-- {
+		hit := m.hits[(m.next+i)%len(m.hits)]
+		if (!q.Since.IsZero() && hit.Time.Before(q.Since)) ||
+			(q.IP != "" && hit.IP != q.IP) ||
+			(q.Token != "" && hit.Token != q.Token) ||
+			(q.Target != "" && hit.Target != q.Target) ||
+			(q.Session != "" && hit.Session != q.Session) {
+			continue
+		}
+		hits = append(hits, hit)
+		if q.Limit > 0 && len(hits) == q.Limit {
+			break
+		}
+	}
+	return hits, nil
+}
+
+// Close does nothing.
+func (m *Memory) Close() error {
+	return nil
+}
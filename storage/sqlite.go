@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// Registers the "sqlite3" database/sql driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS hits (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	time       INTEGER NOT NULL,
+	ip         TEXT NOT NULL,
+	method     TEXT NOT NULL,
+	host       TEXT NOT NULL,
+	path       TEXT NOT NULL,
+	token      TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	headers    TEXT NOT NULL,
+	data          TEXT NOT NULL DEFAULT '',
+	data_encoding TEXT NOT NULL DEFAULT '',
+	target        TEXT NOT NULL DEFAULT '',
+	session       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS hits_time ON hits (time);
+`
+
+// sqliteMigrations add the columns missing from databases created by older
+// versions.
+var sqliteMigrations = []struct{ column, ddl string }{
+	{"data", `ALTER TABLE hits ADD COLUMN data TEXT NOT NULL DEFAULT ''`},
+	{"data_encoding", `ALTER TABLE hits ADD COLUMN data_encoding TEXT NOT NULL DEFAULT ''`},
+	{"target", `ALTER TABLE hits ADD COLUMN target TEXT NOT NULL DEFAULT ''`},
+	{"session", `ALTER TABLE hits ADD COLUMN session TEXT NOT NULL DEFAULT ''`},
+}
+
+// SQLite is a Store backed by a SQLite database file.
+type SQLite struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLite)(nil)
+
+// OpenSQLite opens the SQLite database at path, creating it and its schema if
+// needed.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %v", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %q: %v", path, err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema in %q: %v", path, err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// migrateSQLite adds the columns listed in sqliteMigrations that the hits
+// table lacks.
+func migrateSQLite(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('hits')`)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range sqliteMigrations {
+		if columns[m.column] {
+			continue
+		}
+		if _, err := db.Exec(m.ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record stores a hit.
+func (s *SQLite) Record(ctx context.Context, hit Hit) error {
+	headers, err := json.Marshal(hit.Headers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO hits (time, ip, method, host, path, token, user_agent, headers, data, data_encoding, target, session) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hit.Time.UnixNano(), hit.IP, hit.Method, hit.Host, hit.Path, hit.Token, hit.UserAgent, string(headers), hit.Data, hit.DataEncoding, hit.Target, hit.Session,
+	)
+	return err
+}
+
+// Query returns the hits matching q, newest first.
+func (s *SQLite) Query(ctx context.Context, q Query) ([]Hit, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if q.IP != "" {
+		where = append(where, "ip = ?")
+		args = append(args, q.IP)
+	}
+	if q.Token != "" {
+		where = append(where, "token = ?")
+		args = append(args, q.Token)
+	}
+	if q.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, q.Target)
+	}
+	if q.Session != "" {
+		where = append(where, "session = ?")
+		args = append(args, q.Session)
+	}
+
+	query := `SELECT id, time, ip, method, host, path, token, user_agent, headers, data, data_encoding, target, session FROM hits`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []Hit{}
+	for rows.Next() {
+		var (
+			hit     Hit
+			nanos   int64
+			headers string
+		)
+		if err := rows.Scan(&hit.ID, &nanos, &hit.IP, &hit.Method, &hit.Host, &hit.Path, &hit.Token, &hit.UserAgent, &headers, &hit.Data, &hit.DataEncoding, &hit.Target, &hit.Session); err != nil {
+			return nil, err
+		}
+		hit.Time = time.Unix(0, nanos).UTC()
+		if err := json.Unmarshal([]byte(headers), &hit.Headers); err != nil {
+			return nil, fmt.Errorf("invalid headers stored for hit %d: %v", hit.ID, err)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
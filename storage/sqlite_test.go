@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteMigratesAndFiltersBySession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.db")
+
+	// A database created before hits had data, targets or sessions.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE hits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, time INTEGER NOT NULL, ip TEXT NOT NULL,
+		method TEXT NOT NULL, host TEXT NOT NULL, path TEXT NOT NULL, token TEXT NOT NULL,
+		user_agent TEXT NOT NULL, headers TEXT NOT NULL);
+		INSERT INTO hits (time, ip, method, host, path, token, user_agent, headers)
+		VALUES (1, '192.0.2.1', 'GET', 'old.example.com', '/', 'tok', '', 'null');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for i, session := range []string{"s1", "s2", "s1"} {
+		hit := Hit{Time: now.Add(time.Duration(i) * time.Second), IP: "192.0.2.2", Method: "GET", Path: "/", Session: session}
+		if err := store.Record(ctx, hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hits, err := store.Query(ctx, Query{Session: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].Session != "s1" || hits[1].Session != "s1" {
+		t.Errorf("hits in session s1 = %+v, want two", hits)
+	}
+
+	all, err := store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[3].Host != "old.example.com" || all[3].Session != "" {
+		t.Errorf("all hits = %+v, want the migrated hit last, without a session", all)
+	}
+}
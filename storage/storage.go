@@ -0,0 +1,55 @@
+// Package storage persists the callbacks received by the sheriff so they can
+// be queried after they have scrolled out of the logs.
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Hit is a single recorded callback.
+type Hit struct {
+	ID        int64       `json:"id"`
+	Time      time.Time   `json:"timestamp"`
+	IP        string      `json:"ip"`
+	Method    string      `json:"method"`
+	Host      string      `json:"host"`
+	Path      string      `json:"path"`
+	Token     string      `json:"token"`
+	UserAgent string      `json:"user_agent"`
+	Headers   http.Header `json:"headers"`
+
+	// Target is the ID of the target the callback was attributed to, from
+	// its path or Host.
+	Target string `json:"target,omitempty"`
+
+	// Session is the ID of the session the callback belongs to, as listed
+	// at /api/sessions. It is empty for DNS lookups.
+	Session string `json:"session,omitempty"`
+
+	// Data is what was exfiltrated through a DNS lookup, as UTF-8 text or
+	// base64 as DataEncoding says. It is empty for HTTP callbacks.
+	Data         string `json:"data,omitempty"`
+	DataEncoding string `json:"data_encoding,omitempty"`
+}
+
+// Query selects recorded hits. Zero fields don't filter.
+type Query struct {
+	// Since only returns hits recorded at or after this time.
+	Since   time.Time
+	IP      string
+	Token   string
+	Target  string
+	Session string
+
+	// Limit caps the number of hits returned, newest first.
+	Limit int
+}
+
+// Store records hits and answers queries about them.
+type Store interface {
+	Record(ctx context.Context, hit Hit) error
+	Query(ctx context.Context, q Query) ([]Hit, error)
+	Close() error
+}
@@ -0,0 +1,146 @@
+// Package tcplistener runs the accept loop shared by the sheriff's TCP
+// protocol servers: it listens on a set of addresses, optionally behind the
+// PROXY protocol, hands every connection to a protocol handler and closes
+// them all on shutdown.
+package tcplistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+)
+
+// Server accepts connections on its addresses and serves each one with its
+// handler in a goroutine of its own.
+type Server struct {
+	name   string
+	addrs  []string
+	proxy  *proxyproto.Policy
+	handle func(net.Conn)
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// New builds a Server listening on addrs. name is the protocol, used in
+// errors. If proxy is set, connections from the peers it trusts must start
+// with a PROXY protocol header. handle is called for every connection, which
+// is closed once it returns.
+func New(name string, addrs []string, proxy *proxyproto.Policy, handle func(net.Conn)) *Server {
+	return &Server{
+		name:   name,
+		addrs:  addrs,
+		proxy:  proxy,
+		handle: handle,
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// Start starts listening on every address and accepting connections in the
+// background.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) > 0 {
+		return errors.New("server is already running")
+	}
+
+	var lc net.ListenConfig
+	for _, addr := range s.addrs {
+		ln, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			for _, started := range s.listeners {
+				started.Close()
+			}
+			s.listeners = nil
+			return fmt.Errorf("error starting %s server on %q: %v", s.name, addr, err)
+		}
+		if s.proxy != nil {
+			ln = proxyproto.NewListener(ln, *s.proxy)
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+
+	for _, ln := range s.listeners {
+		s.wg.Add(1)
+		go s.serve(ln)
+	}
+	return nil
+}
+
+// Addrs returns the addresses listened on, or nil if the server isn't
+// running.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []net.Addr
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// Shutdown stops accepting connections, closes the open ones and waits for
+// their handlers to return until the context finishes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	var errs []error
+	for _, ln := range s.listeners {
+		if err := ln.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.listeners = nil
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return errors.Join(errs...)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serve(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
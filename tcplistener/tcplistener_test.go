@@ -0,0 +1,68 @@
+package tcplistener
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeAndShutdown(t *testing.T) {
+	handling := make(chan struct{})
+	s := New("test", []string{"127.0.0.1:0"}, nil, func(conn net.Conn) {
+		conn.Write([]byte("hello\n"))
+		close(handling)
+		// Block until Shutdown closes the connection.
+		io.Copy(io.Discard, conn)
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err == nil {
+		t.Error("second Start succeeded")
+	}
+
+	addrs := s.Addrs()
+	if len(addrs) != 1 {
+		t.Fatalf("Addrs = %v, want one address", addrs)
+	}
+	conn, err := net.Dial("tcp", addrs[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("read %q, %v, want the handler's greeting", line, err)
+	}
+	<-handling
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if s.Addrs() != nil {
+		t.Error("Addrs not nil after Shutdown")
+	}
+	if _, err := net.Dial("tcp", addrs[0].String()); err == nil {
+		t.Error("still accepting connections after Shutdown")
+	}
+}
+
+func TestStartFailureClosesListeners(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s := New("test", []string{"127.0.0.1:0", taken.Addr().String()}, nil, func(net.Conn) {})
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded on an address in use")
+	}
+	if s.Addrs() != nil {
+		t.Error("listeners left open after a failed Start")
+	}
+}
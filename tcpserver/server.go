@@ -0,0 +1,105 @@
+// Package tcpserver implements a raw TCP listener that greets every
+// connection with a banner and logs every byte it receives. It catches SSRF
+// through gopher://, dict:// and other schemes whose payloads never form a
+// valid HTTP request.
+package tcpserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/teknogeek/ssrf-sheriff/proxyproto"
+	"github.com/teknogeek/ssrf-sheriff/tcplistener"
+	"go.uber.org/zap"
+)
+
+// Config describes where the server listens and how it answers.
+type Config struct {
+	// Addrs are the addresses listened on.
+	Addrs []string
+
+	// Banner returns what is written to a connection from remote as soon
+	// as it is accepted.
+	Banner func(remote net.Addr) []byte
+
+	// Echo writes everything received back to the client after the banner.
+	Echo bool
+
+	// ReadTimeout closes connections that have been idle for this long.
+	ReadTimeout time.Duration
+
+	// MaxBytes is the most that is read from, and logged for, one
+	// connection. The connection is closed once it is reached.
+	MaxBytes int
+
+	// Proxy, if set, says which peers relay connections with a PROXY
+	// protocol header.
+	Proxy *proxyproto.Policy
+}
+
+// Server is a raw TCP server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	// Server runs the accept loop and provides Start and Shutdown.
+	*tcplistener.Server
+}
+
+// New builds a Server for the given config. Connections are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	s := &Server{cfg: cfg, logger: logger}
+	s.Server = tcplistener.New("TCP", cfg.Addrs, cfg.Proxy, s.handle)
+	return s
+}
+
+// handle writes the banner, then reads and logs everything the client sends
+// until it hangs up, goes idle or MaxBytes is reached.
+func (s *Server) handle(conn net.Conn) {
+	logger := s.logger.With(
+		zap.String("IP", conn.RemoteAddr().String()),
+		zap.String("Local Address", conn.LocalAddr().String()),
+	)
+	if proxy := proxyproto.ProxyAddr(conn); proxy != nil {
+		logger = logger.With(zap.Stringer("Proxy", proxy))
+	}
+	logger.Info("New inbound TCP connection")
+
+	start := time.Now()
+	if _, err := conn.Write(s.cfg.Banner(conn.RemoteAddr())); err != nil {
+		return
+	}
+
+	received := make([]byte, 0, 512)
+	buf := make([]byte, 4096)
+	for len(received) < s.cfg.MaxBytes {
+		if s.cfg.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.cfg.ReadTimeout))
+		}
+		n, err := conn.Read(buf[:min(len(buf), s.cfg.MaxBytes-len(received))])
+		if n > 0 {
+			logger.Info("New inbound TCP data",
+				zap.Int("Bytes", n),
+				zap.ByteString("Data", buf[:n]),
+			)
+			received = append(received, buf[:n]...)
+			if s.cfg.Echo {
+				conn.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("TCP read failed", zap.Error(err))
+			}
+			break
+		}
+	}
+
+	logger.Info("Closed inbound TCP connection",
+		zap.Int("Bytes", len(received)),
+		zap.ByteString("Data", received),
+		zap.Duration("Duration", time.Since(start)),
+	)
+}
@@ -0,0 +1,39 @@
+// Package templates embeds the static response templates into the binary,
+// so the sheriff can run from any working directory.
+package templates
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// embedded holds the text templates html.html, txt.txt and 404.html, the
+// well-known documents (robots.txt, security.txt, sitemap.xml, openapi.json,
+// swagger.json and the OAuth and OpenID Connect discovery documents),
+// dtd.dtd for the XXE probe, and the placeholder media that generators post-process
+// or that is served when generation is skipped.
+//
+//go:embed *.html *.txt *.xml *.json *.dtd *.gif *.jpg *.png *.mp3 *.mp4
+var embedded embed.FS
+
+// Dir returns the templates in dir, falling back to the embedded templates
+// for any file dir doesn't have. An empty dir returns the embedded templates.
+func Dir(dir string) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return overlay{upper: os.DirFS(dir), lower: embedded}
+}
+
+// overlay serves files from upper, falling back to lower.
+type overlay struct {
+	upper, lower fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	if f, err := o.upper.Open(name); err == nil {
+		return f, nil
+	}
+	return o.lower.Open(name)
+}
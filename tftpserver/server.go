@@ -0,0 +1,287 @@
+// Package tftpserver implements just enough of a TFTP server (RFC 1350) for
+// tftp:// URLs fetched by an SSRF-vulnerable client to succeed. Every file it
+// is asked for has the same contents, and every read and write request is
+// logged, which detects SSRF through the tftp:// scheme supported by libcurl.
+package tftpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TFTP opcodes
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opData  = 3
+	opAck   = 4
+	opError = 5
+)
+
+// TFTP error codes
+const (
+	errNotDefined       = 0
+	errAccessViolation  = 2
+	errIllegalOperation = 4
+)
+
+const (
+	// blockSize is the size of every DATA block but the last. Options such
+	// as blksize are logged but not negotiated, which clients accept.
+	blockSize = 512
+
+	// ackTimeout is how long to wait for each block to be acknowledged, and
+	// retries how many times a block is sent before giving up.
+	ackTimeout = 3 * time.Second
+	retries    = 5
+
+	// maxTransfers caps the transfers in progress. Read requests beyond it
+	// are dropped, since each one opens a socket and sends packets to an
+	// address that may be spoofed.
+	maxTransfers = 32
+)
+
+// Config describes where the server listens and what it serves.
+type Config struct {
+	// Addr is the address requests are received on.
+	Addr string
+
+	// Content returns what is served as the contents of every file to a
+	// client at remote.
+	Content func(remote net.Addr) []byte
+}
+
+// Server is a TFTP server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	conn net.PacketConn
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// transfers holds a value for every transfer in progress.
+	transfers chan struct{}
+}
+
+// New builds a Server for the given config. Requests are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	return &Server{cfg: cfg, logger: logger, transfers: make(chan struct{}, maxTransfers)}
+}
+
+// Start starts listening and answering requests in the background.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return errors.New("server is already running")
+	}
+
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting TFTP server on %q: %v", s.cfg.Addr, err)
+	}
+	s.conn = conn
+	s.done = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.serve(conn)
+	return nil
+}
+
+// Shutdown stops listening, abandons transfers in progress and waits for them
+// to end until the context finishes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+		close(s.done)
+		s.conn = nil
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve reads requests from conn until it is closed.
+func (s *Server) serve(conn net.PacketConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Debug("TFTP read failed", zap.Error(err))
+			continue
+		}
+		s.handle(conn, addr, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// handle logs a request and answers it: read requests with a transfer of
+// Content from a new port, as the protocol has it, anything else with an
+// error.
+func (s *Server) handle(conn net.PacketConn, addr net.Addr, packet []byte) {
+	logger := s.logger.With(
+		zap.String("IP", addr.String()),
+		zap.String("Local Address", conn.LocalAddr().String()),
+	)
+	if len(packet) < 2 {
+		logger.Info("Malformed TFTP packet", zap.Binary("Data", packet))
+		return
+	}
+
+	opcode := binary.BigEndian.Uint16(packet)
+	if opcode != opRRQ && opcode != opWRQ {
+		logger.Info("Unexpected TFTP packet", zap.Uint16("Opcode", opcode), zap.Binary("Data", packet))
+		conn.WriteTo(errorPacket(errIllegalOperation, "illegal TFTP operation"), addr)
+		return
+	}
+
+	fields := strings.Split(string(packet[2:]), "\x00")
+	if len(fields) < 3 {
+		logger.Info("Malformed TFTP request", zap.Binary("Data", packet))
+		conn.WriteTo(errorPacket(errNotDefined, "malformed request"), addr)
+		return
+	}
+	filename, mode := fields[0], fields[1]
+	// Options (RFC 2347) follow as name/value pairs.
+	var options []string
+	for i := 2; i+1 < len(fields); i += 2 {
+		options = append(options, fields[i]+"="+fields[i+1])
+	}
+	requestFields := []zap.Field{
+		zap.String("Filename", filename),
+		zap.String("Mode", mode),
+		zap.Strings("Options", options),
+	}
+
+	if opcode == opWRQ {
+		logger.Info("New inbound TFTP write request", requestFields...)
+		conn.WriteTo(errorPacket(errAccessViolation, string(s.cfg.Content(addr))), addr)
+		return
+	}
+	logger.Info("New inbound TFTP read request", requestFields...)
+
+	select {
+	case s.transfers <- struct{}{}:
+	default:
+		logger.Warn("Too many TFTP transfers in progress, dropping read request", zap.Int("Limit", maxTransfers))
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.transfers }()
+		s.transfer(conn.LocalAddr(), addr, logger.With(zap.String("Filename", filename)))
+	}()
+}
+
+// transfer sends Content to addr from a new port on the same host as local,
+// block by block, waiting for each to be acknowledged.
+func (s *Server) transfer(local, addr net.Addr, logger *zap.Logger) {
+	host := ""
+	if udpAddr, ok := local.(*net.UDPAddr); ok && !udpAddr.IP.IsUnspecified() {
+		host = udpAddr.IP.String()
+	}
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		logger.Warn("Failed to open TFTP transfer port", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	// Abandon the transfer at shutdown.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	start := time.Now()
+	content := s.cfg.Content(addr)
+	ack := make([]byte, 516)
+	for block := uint16(1); ; block++ {
+		offset := (int(block) - 1) * blockSize
+		end := min(offset+blockSize, len(content))
+		data := make([]byte, 4, 4+end-offset)
+		binary.BigEndian.PutUint16(data, opData)
+		binary.BigEndian.PutUint16(data[2:], block)
+		data = append(data, content[offset:end]...)
+
+		acked := false
+		for attempt := 0; attempt < retries && !acked; attempt++ {
+			if _, err := conn.WriteTo(data, addr); err != nil {
+				logger.Debug("TFTP write failed", zap.Error(err))
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(ackTimeout))
+			for {
+				n, from, err := conn.ReadFrom(ack)
+				if err != nil {
+					break
+				}
+				if from.String() != addr.String() || n < 4 {
+					continue
+				}
+				if op := binary.BigEndian.Uint16(ack); op == opError {
+					logger.Info("TFTP transfer aborted by client", zap.ByteString("Message", bytes.TrimRight(ack[4:n], "\x00")))
+					return
+				} else if op == opAck && binary.BigEndian.Uint16(ack[2:]) == block {
+					acked = true
+					break
+				}
+			}
+		}
+		if !acked {
+			logger.Info("TFTP transfer timed out", zap.Int("Block", int(block)))
+			return
+		}
+		// A block shorter than blockSize, possibly empty, ends the transfer.
+		if end-offset < blockSize {
+			break
+		}
+	}
+
+	logger.Info("Finished TFTP transfer",
+		zap.Int("Bytes", len(content)),
+		zap.Duration("Duration", time.Since(start)),
+	)
+}
+
+// errorPacket builds an ERROR packet.
+func errorPacket(code uint16, message string) []byte {
+	packet := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(packet, opError)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, message...)
+	return append(packet, 0)
+}
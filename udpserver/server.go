@@ -0,0 +1,159 @@
+// Package udpserver implements a UDP listener that logs every datagram it
+// receives and answers it with the token. It catches SSRF through tftp://,
+// syslog, SNMP and other UDP payloads that no TCP listener ever sees.
+//
+// A reply is never larger than the datagram it answers, so the server can't
+// be used to amplify traffic towards a spoofed source address.
+package udpserver
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// maxDatagram is the largest UDP payload.
+const maxDatagram = 65535
+
+// Config describes where the server listens and how it answers.
+type Config struct {
+	// Addrs are the addresses listened on.
+	Addrs []string
+
+	// Reply, if set, returns what is sent back for every datagram received,
+	// cut to the size of the datagram. Nothing is sent if it is empty.
+	Reply func() []byte
+
+	// AllowedSources, if any are given, are the networks replies are sent
+	// to. Datagrams from anywhere else are logged but not answered.
+	AllowedSources []*net.IPNet
+}
+
+// Server is a UDP server for a Config.
+type Server struct {
+	cfg    Config
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	conns []net.PacketConn
+	wg    sync.WaitGroup
+}
+
+// New builds a Server for the given config. Datagrams are logged to logger.
+func New(cfg Config, logger *zap.Logger) *Server {
+	return &Server{cfg: cfg, logger: logger}
+}
+
+// Start starts listening on every address and reading datagrams in the
+// background.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) > 0 {
+		return errors.New("server is already running")
+	}
+
+	var lc net.ListenConfig
+	for _, addr := range s.cfg.Addrs {
+		conn, err := lc.ListenPacket(ctx, "udp", addr)
+		if err != nil {
+			for _, started := range s.conns {
+				started.Close()
+			}
+			s.conns = nil
+			return fmt.Errorf("error starting UDP server on %q: %v", addr, err)
+		}
+		s.conns = append(s.conns, conn)
+	}
+
+	for _, conn := range s.conns {
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+	return nil
+}
+
+// Shutdown stops listening and waits for the readers to return until the
+// context finishes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	var errs []error
+	for _, conn := range s.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.conns = nil
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return errors.Join(errs...)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve logs and answers every datagram received on conn until it is
+// closed.
+func (s *Server) serve(conn net.PacketConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Debug("UDP read failed", zap.Error(err))
+			continue
+		}
+
+		s.logger.Info("New inbound UDP datagram",
+			zap.String("IP", addr.String()),
+			zap.String("Local Address", conn.LocalAddr().String()),
+			zap.Int("Bytes", n),
+			zap.String("Data", hex.EncodeToString(buf[:n])),
+			zap.ByteString("Data Text", buf[:n]),
+		)
+		if s.cfg.Reply == nil || !s.allowed(addr) {
+			continue
+		}
+		reply := s.cfg.Reply()
+		reply = reply[:min(len(reply), n)]
+		if len(reply) == 0 {
+			continue
+		}
+		if _, err := conn.WriteTo(reply, addr); err != nil {
+			s.logger.Debug("UDP reply failed", zap.String("IP", addr.String()), zap.Error(err))
+		}
+	}
+}
+
+// allowed reports whether replies may be sent to addr.
+func (s *Server) allowed(addr net.Addr) bool {
+	if len(s.cfg.AllowedSources) == 0 {
+		return true
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range s.cfg.AllowedSources {
+		if ipNet.Contains(udpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}